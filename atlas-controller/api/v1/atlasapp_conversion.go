@@ -0,0 +1,228 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"encoding/json"
+
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"atlas-controller/api/v1beta2"
+)
+
+// ConvertTo converts this AtlasApp to the v1beta2 hub version
+func (src *AtlasApp) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta2.AtlasApp)
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec = v1beta2.AtlasAppSpec{
+		Environment: src.Spec.Environment,
+		Version:     src.Spec.Version,
+		MigrationId: src.Spec.MigrationId,
+		Workload: v1beta2.WorkloadSpec{
+			Image: v1beta2.ImageSpec{
+				Repository:  src.Spec.Image,
+				Digest:      src.Spec.ImageDigest,
+				PullSecrets: src.Spec.ImagePullSecrets,
+			},
+			Replicas:                  src.Spec.Replicas,
+			Resources:                 src.Spec.Resources,
+			Env:                       src.Spec.Env,
+			EnvFrom:                   src.Spec.EnvFrom,
+			Volumes:                   src.Spec.Volumes,
+			VolumeMounts:              src.Spec.VolumeMounts,
+			TopologySpreadConstraints: src.Spec.TopologySpreadConstraints,
+			Affinity:                  src.Spec.Affinity,
+		},
+		Rollout: v1beta2.RolloutSpec{
+			AutoRollback: src.Spec.AutoRollback,
+		},
+		Promotion: v1beta2.PromotionSpec{
+			Pipeline:        src.Spec.Pipeline,
+			AutoPromote:     src.Spec.AutoPromote,
+			NextEnvironment: src.Spec.NextEnvironment,
+			RequireApproval: src.Spec.RequireApproval,
+		},
+		Deletion: v1beta2.DeletionSpec{
+			Policy: v1beta2.DeletionPolicy(src.Spec.DeletionPolicy),
+		},
+	}
+
+	if src.Spec.HealthCheckPath != "" || src.Spec.HealthCheck != nil {
+		dst.Spec.Health = &v1beta2.HealthSpec{Path: src.Spec.HealthCheckPath}
+		if check := src.Spec.HealthCheck; check != nil {
+			dst.Spec.Health.Target = v1beta2.HealthCheckTarget(check.Target)
+			dst.Spec.Health.Port = check.Port
+			dst.Spec.Health.TimeoutSeconds = check.TimeoutSeconds
+			dst.Spec.Health.ExpectedStatusCodes = check.ExpectedStatusCodes
+			dst.Spec.Health.SuccessThreshold = check.SuccessThreshold
+			dst.Spec.Health.FailureThreshold = check.FailureThreshold
+			dst.Spec.Health.IntervalSeconds = check.IntervalSeconds
+		}
+	}
+
+	if src.Spec.Strategy != nil {
+		if err := convertJSON(src.Spec.Strategy.Canary, &dst.Spec.Rollout.Canary); err != nil {
+			return err
+		}
+		if err := convertJSON(src.Spec.Strategy.BlueGreen, &dst.Spec.Rollout.BlueGreen); err != nil {
+			return err
+		}
+	}
+	if err := convertJSON(src.Spec.Migration, &dst.Spec.Migration); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.Service, &dst.Spec.Service); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.Exposure, &dst.Spec.Exposure); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.NetworkPolicy, &dst.Spec.NetworkPolicy); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.PreDeleteHooks, &dst.Spec.Deletion.PreDeleteHooks); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.Overrides, &dst.Spec.Promotion.Overrides); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.DisruptionBudget, &dst.Spec.Workload.DisruptionBudget); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.Autoscaling, &dst.Spec.Workload.Autoscaling); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.Ports, &dst.Spec.Workload.Ports); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.LivenessProbe, &dst.Spec.Workload.Probes.Liveness); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.ReadinessProbe, &dst.Spec.Workload.Probes.Readiness); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.StartupProbe, &dst.Spec.Workload.Probes.Startup); err != nil {
+		return err
+	}
+	dst.Status = v1beta2.AtlasAppStatus{}
+	return convertJSON(&src.Status, &dst.Status)
+}
+
+// ConvertFrom converts the v1beta2 hub version to this AtlasApp
+func (dst *AtlasApp) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta2.AtlasApp)
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec = AtlasAppSpec{
+		Environment:               src.Spec.Environment,
+		Version:                   src.Spec.Version,
+		Image:                     src.Spec.Workload.Image.Repository,
+		ImageDigest:               src.Spec.Workload.Image.Digest,
+		ImagePullSecrets:          src.Spec.Workload.Image.PullSecrets,
+		MigrationId:               src.Spec.MigrationId,
+		Replicas:                  src.Spec.Workload.Replicas,
+		Resources:                 src.Spec.Workload.Resources,
+		Env:                       src.Spec.Workload.Env,
+		EnvFrom:                   src.Spec.Workload.EnvFrom,
+		Volumes:                   src.Spec.Workload.Volumes,
+		VolumeMounts:              src.Spec.Workload.VolumeMounts,
+		TopologySpreadConstraints: src.Spec.Workload.TopologySpreadConstraints,
+		Affinity:                  src.Spec.Workload.Affinity,
+		Pipeline:                  src.Spec.Promotion.Pipeline,
+		AutoPromote:               src.Spec.Promotion.AutoPromote,
+		NextEnvironment:           src.Spec.Promotion.NextEnvironment,
+		RequireApproval:           src.Spec.Promotion.RequireApproval,
+		AutoRollback:              src.Spec.Rollout.AutoRollback,
+		DeletionPolicy:            DeletionPolicy(src.Spec.Deletion.Policy),
+	}
+
+	if health := src.Spec.Health; health != nil {
+		dst.Spec.HealthCheckPath = health.Path
+		check := HealthCheckSpec{
+			Target:              HealthCheckTarget(health.Target),
+			Port:                health.Port,
+			TimeoutSeconds:      health.TimeoutSeconds,
+			ExpectedStatusCodes: health.ExpectedStatusCodes,
+			SuccessThreshold:    health.SuccessThreshold,
+			FailureThreshold:    health.FailureThreshold,
+			IntervalSeconds:     health.IntervalSeconds,
+		}
+		// v1 keeps the path apart, only settings beyond it need a healthCheck
+		if check.Target != "" || check.Port != 0 || check.TimeoutSeconds != 0 || len(check.ExpectedStatusCodes) > 0 ||
+			check.SuccessThreshold != 0 || check.FailureThreshold != 0 || check.IntervalSeconds != 0 {
+			dst.Spec.HealthCheck = &check
+		}
+	}
+
+	if src.Spec.Rollout.Canary != nil || src.Spec.Rollout.BlueGreen != nil {
+		dst.Spec.Strategy = &RolloutStrategy{}
+		if err := convertJSON(src.Spec.Rollout.Canary, &dst.Spec.Strategy.Canary); err != nil {
+			return err
+		}
+		if err := convertJSON(src.Spec.Rollout.BlueGreen, &dst.Spec.Strategy.BlueGreen); err != nil {
+			return err
+		}
+	}
+	if err := convertJSON(src.Spec.Migration, &dst.Spec.Migration); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.Service, &dst.Spec.Service); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.Exposure, &dst.Spec.Exposure); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.NetworkPolicy, &dst.Spec.NetworkPolicy); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.Deletion.PreDeleteHooks, &dst.Spec.PreDeleteHooks); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.Promotion.Overrides, &dst.Spec.Overrides); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.Workload.DisruptionBudget, &dst.Spec.DisruptionBudget); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.Workload.Autoscaling, &dst.Spec.Autoscaling); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.Workload.Ports, &dst.Spec.Ports); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.Workload.Probes.Liveness, &dst.Spec.LivenessProbe); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.Workload.Probes.Readiness, &dst.Spec.ReadinessProbe); err != nil {
+		return err
+	}
+	if err := convertJSON(src.Spec.Workload.Probes.Startup, &dst.Spec.StartupProbe); err != nil {
+		return err
+	}
+	dst.Status = AtlasAppStatus{}
+	return convertJSON(&src.Status, &dst.Status)
+}
+
+// convertJSON copies between types that have the same shape in both versions
+func convertJSON(in, out any) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
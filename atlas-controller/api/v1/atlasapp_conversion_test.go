@@ -0,0 +1,139 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"atlas-controller/api/v1beta2"
+)
+
+// newConvertedApp returns an AtlasApp setting a field of every kind the
+// conversion has to carry
+func newConvertedApp() *AtlasApp {
+	replicas := int32(3)
+	maxReplicas := int32(6)
+	backoffLimit := int32(2)
+	deadline := int64(600)
+	maxUnavailable := intstr.FromInt(1)
+	readyAt := metav1.Unix(1700000000, 0)
+
+	app := newWebhookApp("dev", "1.22.0")
+	app.Spec.Image = "registry.example.com/payments"
+	app.Spec.ImageDigest = "sha256:0123456789abcdef"
+	app.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+	app.Spec.Migration = &MigrationSpec{Command: []string{"migrate"}, BackoffLimit: &backoffLimit, ActiveDeadlineSeconds: &deadline}
+	app.Spec.Autoscaling = &AutoscalingSpec{MinReplicas: &replicas, MaxReplicas: maxReplicas}
+	app.Spec.Resources = corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+	}
+	app.Spec.Env = []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "debug"}}
+	app.Spec.DisruptionBudget = &DisruptionBudgetSpec{MaxUnavailable: &maxUnavailable}
+	app.Spec.Ports = []PortSpec{{Name: "http", ContainerPort: 8080, ServicePort: 80}, {Name: "metrics", ContainerPort: 9090}}
+	app.Spec.LivenessProbe = &ProbeSpec{Type: ProbeTypeHTTPGet, Path: "/live", Port: "http", PeriodSeconds: 20}
+	app.Spec.ReadinessProbe = &ProbeSpec{Type: ProbeTypeTCPSocket, Port: "http"}
+	app.Spec.StartupProbe = &ProbeSpec{Type: ProbeTypeExec, Command: []string{"cat", "/tmp/started"}, FailureThreshold: 30}
+	app.Spec.Service = &ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, LoadBalancerSourceRanges: []string{"10.0.0.0/8"}}
+	app.Spec.Exposure = &ExposureSpec{Type: ExposureTypeIngress, Host: "payments.example.com", TLS: &ExposureTLS{SecretName: "payments-tls"}}
+	app.Spec.NetworkPolicy = &NetworkPolicySpec{
+		Ingress: []NetworkPolicyPeer{{Namespaces: []string{"checkout"}}},
+		Egress:  []NetworkPolicyDestination{{CIDR: "10.1.0.0/16", Ports: []int32{5432}}},
+	}
+	app.Spec.Pipeline = "payments"
+	app.Spec.AutoPromote = true
+	app.Spec.NextEnvironment = "qa"
+	app.Spec.Overrides = []EnvironmentOverride{{Environment: "prod", Replicas: &replicas, Env: []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "info"}}}}
+	app.Spec.HealthCheckPath = "/health"
+	app.Spec.HealthCheck = &HealthCheckSpec{Target: HealthCheckTargetPods, ExpectedStatusCodes: []int32{200, 204}, FailureThreshold: 3}
+	app.Spec.Strategy = &RolloutStrategy{Canary: &CanaryStrategy{Steps: []CanaryStep{{Weight: 25, PauseSeconds: 60}, {Weight: 50}}}}
+	app.Spec.AutoRollback = true
+	app.Spec.DeletionPolicy = DeletionPolicyCascade
+	app.Spec.PreDeleteHooks = []DeletionHook{{Name: "drain", Command: []string{"drain"}, IgnoreFailure: true}}
+
+	app.Status = AtlasAppStatus{
+		ObservedGeneration: 4,
+		Phase:              PhaseReady,
+		Ready:              true,
+		ReadyReplicas:      3,
+		TotalReplicas:      3,
+		DeployedVersion:    "1.22.0",
+		LastKnownGood:      &Revision{Version: "1.21.0", Image: "registry.example.com/payments:1.21.0", MigrationId: 5, ReadyAt: &readyAt},
+		URL:                "https://payments.example.com/",
+		History: []RevisionHistory{{
+			Version:     "1.22.0",
+			MigrationId: 6,
+			Image:       "registry.example.com/payments:1.22.0",
+			Trigger:     RevisionTriggerManual,
+			StartedAt:   readyAt,
+			ReadyAt:     &readyAt,
+			Outcome:     RevisionOutcomeSucceeded,
+		}},
+	}
+	return app
+}
+
+var _ = Describe("AtlasApp conversion", func() {
+	It("moves the flat v1 fields into the v1beta2 groups", func() {
+		hub := &v1beta2.AtlasApp{}
+		Expect(newConvertedApp().ConvertTo(hub)).To(Succeed())
+
+		Expect(hub.Spec.Workload.Image.Repository).To(Equal("registry.example.com/payments"))
+		Expect(hub.Spec.Workload.Image.Digest).To(Equal("sha256:0123456789abcdef"))
+		Expect(hub.Spec.Workload.Probes.Startup.Command).To(Equal([]string{"cat", "/tmp/started"}))
+		Expect(hub.Spec.Rollout.Canary.Steps).To(HaveLen(2))
+		Expect(hub.Spec.Rollout.AutoRollback).To(BeTrue())
+		Expect(hub.Spec.Promotion.NextEnvironment).To(Equal("qa"))
+		Expect(hub.Spec.Promotion.Overrides).To(HaveLen(1))
+		Expect(hub.Spec.Health.Path).To(Equal("/health"))
+		Expect(hub.Spec.Health.ExpectedStatusCodes).To(Equal([]int32{200, 204}))
+		Expect(string(hub.Spec.Deletion.Policy)).To(Equal(string(DeletionPolicyCascade)))
+		Expect(hub.Spec.Deletion.PreDeleteHooks).To(HaveLen(1))
+		Expect(hub.Status.DeployedVersion).To(Equal("1.22.0"))
+	})
+
+	It("round trips an AtlasApp through v1beta2", func() {
+		app := newConvertedApp()
+		hub := &v1beta2.AtlasApp{}
+		Expect(app.ConvertTo(hub)).To(Succeed())
+
+		converted := &AtlasApp{}
+		Expect(converted.ConvertFrom(hub)).To(Succeed())
+		Expect(converted.ObjectMeta).To(Equal(app.ObjectMeta))
+		Expect(converted.Spec).To(Equal(app.Spec))
+		Expect(converted.Status).To(Equal(app.Status))
+	})
+
+	It("keeps a health check path without further settings flat", func() {
+		app := newWebhookApp("dev", "1.22.0")
+		app.Spec.HealthCheckPath = "/health"
+		hub := &v1beta2.AtlasApp{}
+		Expect(app.ConvertTo(hub)).To(Succeed())
+
+		converted := &AtlasApp{}
+		Expect(converted.ConvertFrom(hub)).To(Succeed())
+		Expect(converted.Spec.HealthCheckPath).To(Equal("/health"))
+		Expect(converted.Spec.HealthCheck).To(BeNil())
+		Expect(converted.Spec.Strategy).To(BeNil())
+		Expect(converted.Spec).To(Equal(app.Spec))
+	})
+})
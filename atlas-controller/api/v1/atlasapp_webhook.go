@@ -0,0 +1,606 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/version"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// AllowDowngradeAnnotation permits lowering spec.version or spec.migrationId
+	// to the release in AllowDowngradeToAnnotation. Its value must explain why
+	// the downgrade is needed.
+	AllowDowngradeAnnotation = "atlas.io/allow-downgrade"
+
+	// AllowDowngradeToAnnotation names the release a downgrade is allowed to, as
+	// <version>/<migrationId>. The controller removes both annotations once the
+	// release has been rolled out.
+	AllowDowngradeToAnnotation = "atlas.io/allow-downgrade-to"
+)
+
+// atlasapplog is for logging in this package.
+var atlasapplog = logf.Log.WithName("atlasapp-resource")
+
+// DowngradeError reports a version or migration ID lower than the current one
+type DowngradeError struct {
+	// Field is the spec field being lowered
+	Field string
+	// From is the current value
+	From string
+	// To is the requested value
+	To string
+}
+
+func (e *DowngradeError) Error() string {
+	return fmt.Sprintf("%s must not be lowered from %s to %s", e.Field, e.From, e.To)
+}
+
+// CheckDowngrade returns a DowngradeError if moving from one version and
+// migration ID to another lowers either of them. Versions are compared by
+// semver, versions that cannot be parsed are never considered a downgrade.
+func CheckDowngrade(fromVersion string, fromMigrationId int, toVersion string, toMigrationId int) error {
+	if toMigrationId < fromMigrationId {
+		return &DowngradeError{Field: "migrationId", From: fmt.Sprint(fromMigrationId), To: fmt.Sprint(toMigrationId)}
+	}
+
+	from, err := parseVersion(fromVersion)
+	if err != nil {
+		return nil
+	}
+	to, err := parseVersion(toVersion)
+	if err != nil {
+		return nil
+	}
+	if to.LessThan(from) {
+		return &DowngradeError{Field: "version", From: fromVersion, To: toVersion}
+	}
+	return nil
+}
+
+// parseVersion parses a semantic version, falling back to generic versions like 1.21
+func parseVersion(v string) (*version.Version, error) {
+	if parsed, err := version.ParseSemantic(v); err == nil {
+		return parsed, nil
+	}
+	return version.ParseGeneric(v)
+}
+
+// DowngradeTarget returns the release named in the AllowDowngradeToAnnotation, if any
+func DowngradeTarget(obj metav1.Object) (string, int, bool) {
+	target, ok := obj.GetAnnotations()[AllowDowngradeToAnnotation]
+	if !ok {
+		return "", 0, false
+	}
+	i := strings.LastIndex(target, "/")
+	if i < 0 {
+		return "", 0, false
+	}
+	migrationId, err := strconv.Atoi(target[i+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return target[:i], migrationId, true
+}
+
+// DowngradeOverride returns the reason given in the AllowDowngradeAnnotation if
+// the AllowDowngradeToAnnotation names the given version and migration ID
+func DowngradeOverride(obj metav1.Object, version string, migrationId int) (string, bool) {
+	reason := strings.TrimSpace(obj.GetAnnotations()[AllowDowngradeAnnotation])
+	targetVersion, targetMigrationId, ok := DowngradeTarget(obj)
+	if reason == "" || !ok || targetVersion != version || targetMigrationId != migrationId {
+		return "", false
+	}
+	return reason, true
+}
+
+// DowngradeHint explains how to allow a downgrade to the given version and migration ID
+func DowngradeHint(version string, migrationId int) string {
+	return fmt.Sprintf("set the %s annotation to the reason and %s to %s/%d to allow it",
+		AllowDowngradeAnnotation, AllowDowngradeToAnnotation, version, migrationId)
+}
+
+// SetupWebhookWithManager registers the AtlasApp webhooks with the manager
+func (r *AtlasApp) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithDefaulter(&atlasAppDefaulter{client: mgr.GetClient()}).
+		WithValidator(&atlasAppValidator{client: mgr.GetClient()}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-atlas-io-v1-atlasapp,mutating=true,failurePolicy=fail,sideEffects=None,groups=atlas.io,resources=atlasapps,verbs=create,versions=v1,name=matlasapp.kb.io,admissionReviewVersions=v1
+
+// environmentDefaults are the spec values set on new AtlasApps of an environment
+type environmentDefaults struct {
+	replicas        int32
+	healthCheckPath string
+
+	// highlyAvailable adds a disruption budget and spreads the pods across
+	// zones and nodes
+	highlyAvailable bool
+}
+
+// defaultsByEnvironment holds the defaults of the default pipeline stages, other
+// environments get fallbackDefaults
+var defaultsByEnvironment = map[string]environmentDefaults{
+	"dev":   {replicas: 1, healthCheckPath: "/"},
+	"stage": {replicas: 2, healthCheckPath: "/"},
+	"prod":  {replicas: 3, healthCheckPath: "/", highlyAvailable: true},
+}
+
+var fallbackDefaults = environmentDefaults{replicas: 1, healthCheckPath: "/"}
+
+// atlasAppDefaulter fills in defaults on new AtlasApps. Existing AtlasApps are
+// left alone, so fields can still be cleared or set to zero later on.
+type atlasAppDefaulter struct {
+	// client reads the AtlasPipeline an AtlasApp references
+	client client.Reader
+}
+
+var _ webhook.CustomDefaulter = &atlasAppDefaulter{}
+
+// Default implements webhook.CustomDefaulter
+func (d *atlasAppDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	app, ok := obj.(*AtlasApp)
+	if !ok {
+		return fmt.Errorf("expected an AtlasApp but got a %T", obj)
+	}
+	spec := &app.Spec
+
+	defaults, ok := defaultsByEnvironment[spec.Environment]
+	if !ok {
+		defaults = fallbackDefaults
+	}
+	if spec.Replicas == 0 {
+		spec.Replicas = defaults.replicas
+	}
+	if spec.HealthCheckPath == "" {
+		spec.HealthCheckPath = defaults.healthCheckPath
+	}
+	if defaults.highlyAvailable {
+		defaultAvailability(spec)
+	}
+
+	pipeline, err := GetPipeline(ctx, d.client, spec.Pipeline)
+	if err != nil {
+		// The validator reports a missing pipeline
+		return client.IgnoreNotFound(err)
+	}
+	// Stages fanning out to several stages promote to all of them
+	if spec.NextEnvironment == "" {
+		if next, err := pipeline.NextStages(spec.Environment); err == nil && len(next) == 1 {
+			spec.NextEnvironment = next[0].Name
+		}
+	}
+	if stage, ok := pipeline.Stage(spec.Environment); ok && stage.RequireApproval {
+		spec.RequireApproval = true
+	}
+
+	atlasapplog.Info("Defaulted AtlasApp", "name", app.Name, "namespace", app.Namespace)
+	return nil
+}
+
+// defaultAvailability keeps a node drain from taking down all pods, unless the
+// spec brings its own disruption budget or scheduling rules
+func defaultAvailability(spec *AtlasAppSpec) {
+	if spec.DisruptionBudget == nil {
+		maxUnavailable := intstr.FromInt32(1)
+		spec.DisruptionBudget = &DisruptionBudgetSpec{MaxUnavailable: &maxUnavailable}
+	}
+	if len(spec.TopologySpreadConstraints) == 0 && spec.Affinity == nil {
+		for _, key := range []string{corev1.LabelTopologyZone, corev1.LabelHostname} {
+			spec.TopologySpreadConstraints = append(spec.TopologySpreadConstraints, corev1.TopologySpreadConstraint{
+				MaxSkew:           1,
+				TopologyKey:       key,
+				WhenUnsatisfiable: corev1.ScheduleAnyway,
+			})
+		}
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-atlas-io-v1-atlasapp,mutating=false,failurePolicy=fail,sideEffects=None,groups=atlas.io,resources=atlasapps,verbs=create;update,versions=v1,name=vatlasapp.kb.io,admissionReviewVersions=v1
+
+// atlasAppValidator validates AtlasApp changes
+type atlasAppValidator struct {
+	// client reads the AtlasPipeline an AtlasApp references
+	client client.Reader
+}
+
+var _ webhook.CustomValidator = &atlasAppValidator{}
+
+// ValidateCreate implements webhook.CustomValidator
+func (v *atlasAppValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	app, ok := obj.(*AtlasApp)
+	if !ok {
+		return nil, fmt.Errorf("expected an AtlasApp but got a %T", obj)
+	}
+
+	warnings, allErrs := v.validateSpec(ctx, app)
+	if err := validateApprover(ctx, nil, app); err != nil {
+		allErrs = append(allErrs, err)
+	}
+	if len(allErrs) > 0 {
+		return warnings, apierrors.NewInvalid(GroupVersion.WithKind("AtlasApp").GroupKind(), app.Name, allErrs)
+	}
+	return warnings, nil
+}
+
+// ValidateUpdate implements webhook.CustomValidator
+func (v *atlasAppValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldApp, ok := oldObj.(*AtlasApp)
+	if !ok {
+		return nil, fmt.Errorf("expected an AtlasApp but got a %T", oldObj)
+	}
+	newApp, ok := newObj.(*AtlasApp)
+	if !ok {
+		return nil, fmt.Errorf("expected an AtlasApp but got a %T", newObj)
+	}
+
+	// Metadata and finalizer updates must not fail on specs admitted before validation existed
+	var warnings admission.Warnings
+	var allErrs field.ErrorList
+	if !equality.Semantic.DeepEqual(oldApp.Spec, newApp.Spec) {
+		warnings, allErrs = v.validateSpec(ctx, newApp)
+	}
+
+	if err := validateApprover(ctx, oldApp, newApp); err != nil {
+		allErrs = append(allErrs, err)
+	}
+
+	if newApp.Spec.Environment != oldApp.Spec.Environment {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "environment"), newApp.Spec.Environment,
+			fmt.Sprintf("field is immutable, it was %q", oldApp.Spec.Environment)))
+	}
+
+	if err := CheckDowngrade(oldApp.Spec.Version, oldApp.Spec.MigrationId, newApp.Spec.Version, newApp.Spec.MigrationId); err != nil && !restoresRollback(oldApp, newApp) {
+		downgrade := err.(*DowngradeError)
+		if reason, ok := DowngradeOverride(newApp, newApp.Spec.Version, newApp.Spec.MigrationId); ok {
+			atlasapplog.Info("Allowing downgrade", "name", newApp.Name, "namespace", newApp.Namespace, "field", downgrade.Field, "reason", reason)
+			warnings = append(warnings, fmt.Sprintf("%s lowered from %s to %s: %s", downgrade.Field, downgrade.From, downgrade.To, reason))
+		} else {
+			allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", downgrade.Field),
+				fmt.Sprintf("%s, %s", err.Error(), DowngradeHint(newApp.Spec.Version, newApp.Spec.MigrationId))))
+		}
+	}
+
+	if len(allErrs) > 0 {
+		return warnings, apierrors.NewInvalid(GroupVersion.WithKind("AtlasApp").GroupKind(), newApp.Name, allErrs)
+	}
+	return warnings, nil
+}
+
+// validateSpec checks the spec of an AtlasApp on its own and against its pipeline
+func (v *atlasAppValidator) validateSpec(ctx context.Context, app *AtlasApp) (admission.Warnings, field.ErrorList) {
+	var warnings admission.Warnings
+	var allErrs field.ErrorList
+	spec := &app.Spec
+	specPath := field.NewPath("spec")
+
+	for _, msg := range validation.IsDNS1123Label(spec.Environment) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("environment"), spec.Environment, msg))
+	}
+	if _, err := version.ParseSemantic(spec.Version); err != nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("version"), spec.Version, "must be a semantic version, e.g. 1.21.0"))
+	}
+	if spec.MigrationId < 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("migrationId"), spec.MigrationId, "must not be negative"))
+	}
+	if spec.Replicas < 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("replicas"), spec.Replicas, "must not be negative"))
+	}
+	if spec.NextEnvironment != "" && spec.NextEnvironment == spec.Environment {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("nextEnvironment"), spec.NextEnvironment, "must differ from environment"))
+	}
+
+	if autoscaling := spec.Autoscaling; autoscaling != nil && autoscaling.MinReplicas != nil && *autoscaling.MinReplicas > autoscaling.MaxReplicas {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("autoscaling", "maxReplicas"), autoscaling.MaxReplicas, "must not be lower than minReplicas"))
+	}
+	if budget := spec.DisruptionBudget; budget != nil && budget.MinAvailable != nil && budget.MaxUnavailable != nil {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("disruptionBudget", "maxUnavailable"), "may not be combined with minAvailable"))
+	}
+	if strategy := spec.Strategy; strategy != nil && strategy.Canary != nil && strategy.BlueGreen != nil {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("strategy", "blueGreen"), "may not be combined with canary"))
+	}
+
+	hookNames := map[string]bool{}
+	for i, hook := range spec.PreDeleteHooks {
+		if hookNames[hook.Name] {
+			allErrs = append(allErrs, field.Duplicate(specPath.Child("preDeleteHooks").Index(i).Child("name"), hook.Name))
+		}
+		hookNames[hook.Name] = true
+	}
+
+	for i, env := range spec.Env {
+		if env.Name == "MIGRATION_ID" || env.Name == "ENVIRONMENT" {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("env").Index(i).Child("name"), "is set by the controller"))
+		}
+	}
+	volumeNames := map[string]bool{}
+	for _, volume := range spec.Volumes {
+		volumeNames[volume.Name] = true
+	}
+	for i, mount := range spec.VolumeMounts {
+		if !volumeNames[mount.Name] {
+			allErrs = append(allErrs, field.NotFound(specPath.Child("volumeMounts").Index(i).Child("name"), mount.Name))
+		}
+	}
+	portNames := map[string]bool{}
+	containerPorts := map[string]bool{}
+	for i, port := range spec.Ports {
+		portPath := specPath.Child("ports").Index(i)
+		if portNames[port.Name] {
+			allErrs = append(allErrs, field.Duplicate(portPath.Child("name"), port.Name))
+		}
+		portNames[port.Name] = true
+
+		key := fmt.Sprintf("%d/%s", port.ContainerPort, port.Protocol)
+		if containerPorts[key] {
+			allErrs = append(allErrs, field.Duplicate(portPath.Child("containerPort"), port.ContainerPort))
+		}
+		containerPorts[key] = true
+	}
+	if len(spec.Ports) == 0 {
+		portNames["http"] = true
+	}
+	probes := []struct {
+		name  string
+		probe *ProbeSpec
+	}{
+		{"livenessProbe", spec.LivenessProbe},
+		{"readinessProbe", spec.ReadinessProbe},
+		{"startupProbe", spec.StartupProbe},
+	}
+	for _, p := range probes {
+		if p.probe == nil {
+			continue
+		}
+		if p.probe.Port != "" && !portNames[p.probe.Port] {
+			allErrs = append(allErrs, field.NotFound(specPath.Child(p.name, "port"), p.probe.Port))
+		}
+		if p.probe.Type == ProbeTypeExec && len(p.probe.Command) == 0 {
+			allErrs = append(allErrs, field.Required(specPath.Child(p.name, "command"), "must be set for Exec probes"))
+		}
+	}
+
+	allErrs = append(allErrs, validateService(spec, specPath)...)
+	if spec.Exposure != nil {
+		allErrs = append(allErrs, validateExposure(app, portNames, specPath.Child("exposure"))...)
+	}
+	if spec.NetworkPolicy != nil {
+		allErrs = append(allErrs, validateNetworkPolicy(spec.NetworkPolicy, specPath.Child("networkPolicy"))...)
+	}
+
+	overrideEnvironments := map[string]bool{}
+	for i, override := range spec.Overrides {
+		if overrideEnvironments[override.Environment] {
+			allErrs = append(allErrs, field.Duplicate(specPath.Child("overrides").Index(i).Child("environment"), override.Environment))
+		}
+		overrideEnvironments[override.Environment] = true
+		if autoscaling := override.Autoscaling; autoscaling != nil && autoscaling.MinReplicas != nil && *autoscaling.MinReplicas > autoscaling.MaxReplicas {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("overrides").Index(i).Child("autoscaling", "maxReplicas"),
+				autoscaling.MaxReplicas, "must not be lower than minReplicas"))
+		}
+	}
+
+	pipeline, err := GetPipeline(ctx, v.client, spec.Pipeline)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return warnings, append(allErrs, field.InternalError(specPath.Child("pipeline"), err))
+		}
+		// The pipeline may be created after the app, the controller reports it until then
+		return append(warnings, fmt.Sprintf("AtlasPipeline %s not found, environment and nextEnvironment are not checked", spec.Pipeline)), allErrs
+	}
+	pipelineName := PipelineDisplayName(spec.Pipeline)
+
+	index := pipeline.stageIndex(spec.Environment)
+	if index < 0 {
+		allErrs = append(allErrs, field.NotSupported(specPath.Child("environment"), spec.Environment, pipeline.stageNames()))
+		return warnings, allErrs
+	}
+	if spec.NextEnvironment != "" && spec.NextEnvironment != spec.Environment {
+		next, err := pipeline.NextStages(spec.Environment)
+		if err != nil {
+			// The controller reports the pipeline until it is fixed
+			warnings = append(warnings, fmt.Sprintf("AtlasPipeline %s is invalid, nextEnvironment is not checked: %v", pipelineName, err))
+		} else if !containsStage(next, spec.NextEnvironment) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("nextEnvironment"), spec.NextEnvironment,
+				fmt.Sprintf("must be a stage %s promotes to in pipeline %s, stages cannot be skipped", spec.Environment, pipelineName)))
+		}
+	}
+	if spec.RequireApproval && index == 0 && !pipeline.Stages[0].RequireApproval {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("requireApproval"),
+			fmt.Sprintf("%s is the first stage of pipeline %s, nothing is promoted into it", spec.Environment, pipelineName)))
+	}
+
+	return warnings, allErrs
+}
+
+// validateService checks that spec.service only uses settings of its Service type
+func validateService(spec *AtlasAppSpec, specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	service := spec.Service
+	if service == nil {
+		service = &ServiceSpec{}
+	}
+	path := specPath.Child("service")
+
+	external := service.Type == corev1.ServiceTypeNodePort || service.Type == corev1.ServiceTypeLoadBalancer
+	if !external {
+		for i, port := range spec.Ports {
+			if port.NodePort != 0 {
+				allErrs = append(allErrs, field.Forbidden(specPath.Child("ports").Index(i).Child("nodePort"), "requires a NodePort or LoadBalancer service"))
+			}
+		}
+		if service.ExternalTrafficPolicy != "" {
+			allErrs = append(allErrs, field.Forbidden(path.Child("externalTrafficPolicy"), "requires a NodePort or LoadBalancer service"))
+		}
+	}
+	if service.Type != corev1.ServiceTypeLoadBalancer && len(service.LoadBalancerSourceRanges) > 0 {
+		allErrs = append(allErrs, field.Forbidden(path.Child("loadBalancerSourceRanges"), "requires a LoadBalancer service"))
+	}
+	for i, cidr := range service.LoadBalancerSourceRanges {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("loadBalancerSourceRanges").Index(i), cidr, "must be a CIDR, e.g. 10.0.0.0/8"))
+		}
+	}
+	if service.SessionAffinityTimeoutSeconds != nil && service.SessionAffinity != corev1.ServiceAffinityClientIP {
+		allErrs = append(allErrs, field.Forbidden(path.Child("sessionAffinityTimeoutSeconds"), "requires ClientIP session affinity"))
+	}
+	return allErrs
+}
+
+// validateNetworkPolicy checks the namespaces and destinations of spec.networkPolicy
+func validateNetworkPolicy(policy *NetworkPolicySpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	type peer struct {
+		path *field.Path
+		peer NetworkPolicyPeer
+	}
+	var peers []peer
+	for i, p := range policy.Ingress {
+		peers = append(peers, peer{path.Child("ingress").Index(i), p})
+	}
+	if policy.IngressController != nil {
+		peers = append(peers, peer{path.Child("ingressController"), *policy.IngressController})
+	}
+	for _, p := range peers {
+		for i, namespace := range p.peer.Namespaces {
+			for _, msg := range validation.IsDNS1123Label(namespace) {
+				allErrs = append(allErrs, field.Invalid(p.path.Child("namespaces").Index(i), namespace, msg))
+			}
+		}
+	}
+
+	for i, destination := range policy.Egress {
+		destinationPath := path.Child("egress").Index(i)
+		if _, _, err := net.ParseCIDR(destination.CIDR); err != nil {
+			allErrs = append(allErrs, field.Invalid(destinationPath.Child("cidr"), destination.CIDR, "must be a CIDR, e.g. 10.0.12.0/24"))
+		}
+		for j, port := range destination.Ports {
+			for _, msg := range validation.IsValidPortNum(int(port)) {
+				allErrs = append(allErrs, field.Invalid(destinationPath.Child("ports").Index(j), port, msg))
+			}
+		}
+	}
+	return allErrs
+}
+
+// validateExposure checks spec.exposure, portNames holds the names of the app's ports
+func validateExposure(app *AtlasApp, portNames map[string]bool, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	exposure := app.Spec.Exposure
+
+	host, err := app.ExposureHost()
+	if err != nil {
+		allErrs = append(allErrs, field.Invalid(path.Child("host"), exposure.Host, err.Error()))
+	} else {
+		for _, msg := range validation.IsDNS1123Subdomain(host) {
+			allErrs = append(allErrs, field.Invalid(path.Child("host"), exposure.Host, fmt.Sprintf("renders to %q: %s", host, msg)))
+		}
+	}
+	for i, p := range exposure.Paths {
+		if !strings.HasPrefix(p, "/") {
+			allErrs = append(allErrs, field.Invalid(path.Child("paths").Index(i), p, "must start with /"))
+		}
+	}
+	if exposure.Port != "" && !portNames[exposure.Port] {
+		allErrs = append(allErrs, field.NotFound(path.Child("port"), exposure.Port))
+	}
+
+	if exposure.Type == ExposureTypeHTTPRoute {
+		if exposure.Gateway == nil {
+			allErrs = append(allErrs, field.Required(path.Child("gateway"), "must be set for HTTPRoute"))
+		}
+		if exposure.TLS != nil && exposure.TLS.SecretName != "" {
+			allErrs = append(allErrs, field.Forbidden(path.Child("tls", "secretName"), "TLS of an HTTPRoute is terminated by the Gateway listener"))
+		}
+		if exposure.IngressClassName != "" {
+			allErrs = append(allErrs, field.Forbidden(path.Child("ingressClassName"), "only applies to Ingress"))
+		}
+	} else if exposure.Gateway != nil {
+		allErrs = append(allErrs, field.Forbidden(path.Child("gateway"), "only applies to HTTPRoute"))
+	}
+	return allErrs
+}
+
+// containsStage reports if stages contains the stage with the given environment name
+func containsStage(stages []*PipelineStage, name string) bool {
+	for _, stage := range stages {
+		if stage.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateApprover checks that an approved-by annotation being set names the
+// user making the request, so the approval recorded in the status identifies
+// the approver instead of repeating a claim
+func validateApprover(ctx context.Context, oldApp, newApp *AtlasApp) *field.Error {
+	approver, ok := newApp.Annotations[ApprovedByAnnotation]
+	if !ok {
+		return nil
+	}
+	if oldApp != nil {
+		if previous, ok := oldApp.Annotations[ApprovedByAnnotation]; ok && previous == approver {
+			return nil
+		}
+	}
+
+	path := field.NewPath("metadata", "annotations").Key(ApprovedByAnnotation)
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return field.InternalError(path, err)
+	}
+	if approver != req.UserInfo.Username {
+		return field.Forbidden(path, fmt.Sprintf("must be %q, the user giving the approval", req.UserInfo.Username))
+	}
+	return nil
+}
+
+// restoresRollback reports if the new spec asks for the release an automatic
+// rollback restored, which is what is running already
+func restoresRollback(oldApp, newApp *AtlasApp) bool {
+	rollback := oldApp.Status.Rollback
+	return rollback != nil && newApp.Spec.Version == rollback.RestoredTo.Version &&
+		newApp.Spec.MigrationId == rollback.RestoredTo.MigrationId
+}
+
+// ValidateDelete implements webhook.CustomValidator
+func (v *atlasAppValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
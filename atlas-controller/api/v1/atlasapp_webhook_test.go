@@ -0,0 +1,288 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// newWebhookApp returns an AtlasApp passing validation in the default pipeline
+func newWebhookApp(environment, version string) *AtlasApp {
+	return &AtlasApp{
+		ObjectMeta: metav1.ObjectMeta{Name: "atlas", Namespace: "payments-" + environment},
+		Spec: AtlasAppSpec{
+			Environment: environment,
+			Version:     version,
+			MigrationId: 6,
+			Replicas:    1,
+		},
+	}
+}
+
+var _ = Describe("AtlasApp validating webhook", func() {
+	var validator *atlasAppValidator
+
+	BeforeEach(func() {
+		validator = &atlasAppValidator{client: newReader(paymentsPipeline)}
+	})
+
+	expectInvalid := func(err error, substring string) {
+		Expect(err).To(HaveOccurred())
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring(substring))
+	}
+
+	It("accepts a valid spec", func() {
+		app := newWebhookApp("dev", "1.22.0")
+		app.Spec.NextEnvironment = "stage"
+		warnings, err := validator.ValidateCreate(asUser("jane.doe"), app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warnings).To(BeEmpty())
+	})
+
+	It("rejects invalid fields", func() {
+		app := newWebhookApp("dev", "latest")
+		app.Spec.Replicas = -1
+		app.Spec.Strategy = &RolloutStrategy{Canary: &CanaryStrategy{}, BlueGreen: &BlueGreenStrategy{}}
+		_, err := validator.ValidateCreate(asUser("jane.doe"), app)
+		expectInvalid(err, "spec.version")
+		expectInvalid(err, "spec.replicas")
+		expectInvalid(err, "spec.strategy.blueGreen")
+	})
+
+	It("only accepts a stage the environment promotes to as nextEnvironment", func() {
+		app := newWebhookApp("dev", "1.22.0")
+		app.Spec.NextEnvironment = "prod"
+		_, err := validator.ValidateCreate(asUser("jane.doe"), app)
+		expectInvalid(err, "stages cannot be skipped")
+
+		app.Spec.Pipeline = paymentsPipeline.Name
+		app.Spec.NextEnvironment = "qa"
+		_, err = validator.ValidateCreate(asUser("jane.doe"), app)
+		Expect(err).NotTo(HaveOccurred())
+
+		app.Spec.NextEnvironment = "prod"
+		_, err = validator.ValidateCreate(asUser("jane.doe"), app)
+		expectInvalid(err, "must be a stage dev promotes to in pipeline payments")
+	})
+
+	It("accepts any of several next stages as nextEnvironment", func() {
+		validator.client = newReader(regionsPipeline)
+
+		app := newWebhookApp("qa", "1.22.0")
+		app.Spec.Pipeline = regionsPipeline.Name
+		for _, next := range []string{"prod-eu", "prod-us"} {
+			app.Spec.NextEnvironment = next
+			_, err := validator.ValidateCreate(asUser("jane.doe"), app)
+			Expect(err).NotTo(HaveOccurred(), next)
+		}
+
+		// Stages without next are final once the pipeline lists next
+		app = newWebhookApp("prod-eu", "1.22.0")
+		app.Spec.Pipeline = regionsPipeline.Name
+		app.Spec.NextEnvironment = "prod-us"
+		_, err := validator.ValidateCreate(asUser("jane.doe"), app)
+		expectInvalid(err, "spec.nextEnvironment")
+	})
+
+	It("rejects an environment that is not a stage of the pipeline", func() {
+		app := newWebhookApp("qa", "1.22.0")
+		_, err := validator.ValidateCreate(asUser("jane.doe"), app)
+		expectInvalid(err, "spec.environment")
+	})
+
+	It("warns about a pipeline that does not exist yet", func() {
+		app := newWebhookApp("dev", "1.22.0")
+		app.Spec.Pipeline = "missing"
+		warnings, err := validator.ValidateCreate(asUser("jane.doe"), app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warnings).To(ContainElement(ContainSubstring("AtlasPipeline missing not found")))
+	})
+
+	It("keeps the environment immutable", func() {
+		oldApp := newWebhookApp("dev", "1.22.0")
+		newApp := oldApp.DeepCopy()
+		newApp.Spec.Environment = "stage"
+		_, err := validator.ValidateUpdate(asUser("jane.doe"), oldApp, newApp)
+		expectInvalid(err, "field is immutable")
+	})
+
+	It("does not validate unchanged specs again", func() {
+		oldApp := newWebhookApp("dev", "latest")
+		newApp := oldApp.DeepCopy()
+		newApp.Finalizers = []string{"atlas.io/finalizer"}
+		_, err := validator.ValidateUpdate(asUser("system:serviceaccount:atlas-system:atlas-controller"), oldApp, newApp)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("approvals", func() {
+		It("requires the approver to be the requesting user", func() {
+			oldApp := newWebhookApp("prod", "1.22.0")
+			newApp := oldApp.DeepCopy()
+			newApp.Annotations = map[string]string{ApprovedByAnnotation: "john.doe"}
+			_, err := validator.ValidateUpdate(asUser("jane.doe"), oldApp, newApp)
+			expectInvalid(err, `must be "jane.doe", the user giving the approval`)
+
+			newApp.Annotations[ApprovedByAnnotation] = "jane.doe"
+			_, err = validator.ValidateUpdate(asUser("jane.doe"), oldApp, newApp)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("lets other users change an AtlasApp carrying an approval", func() {
+			oldApp := newWebhookApp("prod", "1.22.0")
+			oldApp.Annotations = map[string]string{ApprovedByAnnotation: "jane.doe"}
+			newApp := oldApp.DeepCopy()
+			newApp.Spec.Replicas = 3
+			_, err := validator.ValidateUpdate(asUser("john.doe"), oldApp, newApp)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("downgrades", func() {
+		var oldApp, newApp *AtlasApp
+
+		BeforeEach(func() {
+			oldApp = newWebhookApp("dev", "1.22.0")
+			newApp = oldApp.DeepCopy()
+			newApp.Spec.Version = "1.21.0"
+		})
+
+		It("rejects a lower version without an override", func() {
+			_, err := validator.ValidateUpdate(asUser("jane.doe"), oldApp, newApp)
+			expectInvalid(err, "spec.version")
+			expectInvalid(err, AllowDowngradeToAnnotation+" to 1.21.0/6")
+		})
+
+		It("rejects a lower migration ID without an override", func() {
+			newApp.Spec.Version = oldApp.Spec.Version
+			newApp.Spec.MigrationId = 5
+			_, err := validator.ValidateUpdate(asUser("jane.doe"), oldApp, newApp)
+			expectInvalid(err, "spec.migrationId")
+		})
+
+		It("allows the release the override names with a warning", func() {
+			newApp.Annotations = map[string]string{
+				AllowDowngradeAnnotation:   "INC-4711",
+				AllowDowngradeToAnnotation: "1.21.0/6",
+			}
+			warnings, err := validator.ValidateUpdate(asUser("jane.doe"), oldApp, newApp)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ContainElement(ContainSubstring("INC-4711")))
+		})
+
+		It("rejects a release other than the override names", func() {
+			newApp.Annotations = map[string]string{
+				AllowDowngradeAnnotation:   "INC-4711",
+				AllowDowngradeToAnnotation: "1.20.0/6",
+			}
+			_, err := validator.ValidateUpdate(asUser("jane.doe"), oldApp, newApp)
+			expectInvalid(err, "spec.version")
+		})
+
+		It("allows going back to the release a rollback restored", func() {
+			oldApp.Status.Rollback = &RollbackStatus{
+				Failed:     Revision{Version: "1.22.0", MigrationId: 6},
+				RestoredTo: Revision{Version: "1.21.0", MigrationId: 6},
+			}
+			_, err := validator.ValidateUpdate(asUser("jane.doe"), oldApp, newApp)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("AtlasApp defaulting webhook", func() {
+	var defaulter *atlasAppDefaulter
+
+	BeforeEach(func() {
+		defaulter = &atlasAppDefaulter{client: newReader(paymentsPipeline)}
+	})
+
+	defaulted := func(app *AtlasApp) *AtlasApp {
+		Expect(defaulter.Default(asUser("jane.doe"), app)).To(Succeed())
+		return app
+	}
+
+	It("fills in the defaults of the environment", func() {
+		app := newWebhookApp("dev", "1.22.0")
+		app.Spec.Replicas = 0
+		spec := defaulted(app).Spec
+		Expect(spec.Replicas).To(Equal(int32(1)))
+		Expect(spec.HealthCheckPath).To(Equal("/"))
+		Expect(spec.NextEnvironment).To(Equal("stage"))
+		Expect(spec.RequireApproval).To(BeFalse())
+		Expect(spec.DisruptionBudget).To(BeNil())
+		Expect(spec.TopologySpreadConstraints).To(BeEmpty())
+	})
+
+	It("makes prod highly available and gated on approval", func() {
+		app := newWebhookApp("prod", "1.22.0")
+		app.Spec.Replicas = 0
+		spec := defaulted(app).Spec
+		Expect(spec.Replicas).To(Equal(int32(3)))
+		Expect(spec.NextEnvironment).To(BeEmpty())
+		Expect(spec.RequireApproval).To(BeTrue())
+		Expect(spec.DisruptionBudget).NotTo(BeNil())
+		Expect(spec.DisruptionBudget.MaxUnavailable).To(Equal(&intstr.IntOrString{Type: intstr.Int, IntVal: 1}))
+		Expect(spec.TopologySpreadConstraints).To(HaveLen(2))
+		Expect(spec.TopologySpreadConstraints[0].TopologyKey).To(Equal(corev1.LabelTopologyZone))
+		Expect(spec.TopologySpreadConstraints[1].TopologyKey).To(Equal(corev1.LabelHostname))
+	})
+
+	It("keeps the values the spec sets", func() {
+		app := newWebhookApp("prod", "1.22.0")
+		app.Spec.Replicas = 5
+		app.Spec.HealthCheckPath = "/health"
+		app.Spec.Affinity = &corev1.Affinity{}
+		minAvailable := intstr.FromString("50%")
+		app.Spec.DisruptionBudget = &DisruptionBudgetSpec{MinAvailable: &minAvailable}
+		spec := defaulted(app).Spec
+		Expect(spec.Replicas).To(Equal(int32(5)))
+		Expect(spec.HealthCheckPath).To(Equal("/health"))
+		Expect(spec.DisruptionBudget.MinAvailable).To(Equal(&minAvailable))
+		Expect(spec.DisruptionBudget.MaxUnavailable).To(BeNil())
+		Expect(spec.TopologySpreadConstraints).To(BeEmpty())
+	})
+
+	It("takes the next stage and approval gate from the referenced pipeline", func() {
+		app := newWebhookApp("qa", "1.22.0")
+		app.Spec.Pipeline = paymentsPipeline.Name
+		spec := defaulted(app).Spec
+		Expect(spec.NextEnvironment).To(Equal("prod"))
+		Expect(spec.RequireApproval).To(BeTrue())
+	})
+
+	It("leaves nextEnvironment empty on a stage promoting to several stages", func() {
+		defaulter.client = newReader(regionsPipeline)
+		app := newWebhookApp("qa", "1.22.0")
+		app.Spec.Pipeline = regionsPipeline.Name
+		Expect(defaulted(app).Spec.NextEnvironment).To(BeEmpty())
+	})
+
+	It("leaves the pipeline settings alone when the pipeline does not exist", func() {
+		app := newWebhookApp("dev", "1.22.0")
+		app.Spec.Pipeline = "missing"
+		spec := defaulted(app).Spec
+		Expect(spec.NextEnvironment).To(BeEmpty())
+		Expect(spec.Replicas).To(Equal(int32(1)))
+	})
+})
@@ -0,0 +1,192 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PipelineStage defines one environment of a promotion pipeline
+type PipelineStage struct {
+	// Name specifies the environment name of the stage, e.g. dev
+	Name string `json:"name"`
+
+	// Namespace specifies where the AtlasApps of this stage live (defaults to Name)
+	Namespace string `json:"namespace,omitempty"`
+
+	// RequireApproval makes promotions into this stage go through an AtlasPromotion,
+	// and is set on the AtlasApps the controller creates in this stage
+	RequireApproval bool `json:"requireApproval,omitempty"`
+
+	// AutoPromote is set on the AtlasApps the controller creates in this stage
+	AutoPromote bool `json:"autoPromote,omitempty"`
+
+	// Next lists the stages this stage promotes to, e.g. two prod regions. They
+	// have to come later in the list of stages. Once a stage of the pipeline
+	// sets next, stages without it are final.
+	//+listType=set
+	Next []string `json:"next,omitempty"`
+}
+
+// AtlasPipelineSpec defines the desired state of AtlasPipeline
+type AtlasPipelineSpec struct {
+	// Stages lists the environments in promotion order. Stages promote to the
+	// stages in their next list, or to the stage following them if no stage of
+	// the pipeline sets next. Stage names are unique.
+	//+kubebuilder:validation:MinItems=1
+	//+listType=map
+	//+listMapKey=name
+	Stages []PipelineStage `json:"stages"`
+}
+
+// DefaultPipelineSpec returns the pipeline used by AtlasApps that don't reference one
+func DefaultPipelineSpec() AtlasPipelineSpec {
+	return AtlasPipelineSpec{
+		Stages: []PipelineStage{
+			{Name: "dev", AutoPromote: true},
+			{Name: "stage", AutoPromote: true},
+			{Name: "prod", RequireApproval: true},
+		},
+	}
+}
+
+// GetPipeline returns the referenced AtlasPipeline, or the default pipeline
+// when name is empty
+func GetPipeline(ctx context.Context, c client.Reader, name string) (*AtlasPipelineSpec, error) {
+	if name == "" {
+		pipeline := DefaultPipelineSpec()
+		return &pipeline, nil
+	}
+
+	var pipeline AtlasPipeline
+	if err := c.Get(ctx, types.NamespacedName{Name: name}, &pipeline); err != nil {
+		return nil, err
+	}
+	return &pipeline.Spec, nil
+}
+
+// PipelineDisplayName returns the name used for a pipeline reference in messages
+func PipelineDisplayName(name string) string {
+	if name == "" {
+		return "default"
+	}
+	return name
+}
+
+// StageNamespace returns the namespace AtlasApps of the stage live in
+func (s PipelineStage) StageNamespace() string {
+	if s.Namespace != "" {
+		return s.Namespace
+	}
+	return s.Name
+}
+
+// Stage returns the stage with the given environment name
+func (p *AtlasPipelineSpec) Stage(name string) (*PipelineStage, bool) {
+	for i := range p.Stages {
+		if p.Stages[i].Name == name {
+			return &p.Stages[i], true
+		}
+	}
+	return nil, false
+}
+
+// NextStages returns the stages the given environment promotes to, none for
+// the last stage. It fails if next names a stage that does not come later in
+// the pipeline, so promotions never run in a cycle.
+func (p *AtlasPipelineSpec) NextStages(name string) ([]*PipelineStage, error) {
+	index := p.stageIndex(name)
+	if index < 0 {
+		return nil, nil
+	}
+
+	if !p.listsNext() {
+		if index+1 < len(p.Stages) {
+			return []*PipelineStage{&p.Stages[index+1]}, nil
+		}
+		return nil, nil
+	}
+
+	var stages []*PipelineStage
+	for _, next := range p.Stages[index].Next {
+		nextIndex := p.stageIndex(next)
+		if nextIndex <= index {
+			return nil, fmt.Errorf("stage %s promotes to %s, which is not a later stage of the pipeline", name, next)
+		}
+		stages = append(stages, &p.Stages[nextIndex])
+	}
+	return stages, nil
+}
+
+// listsNext reports if any stage lists the stages it promotes to
+func (p *AtlasPipelineSpec) listsNext() bool {
+	for i := range p.Stages {
+		if len(p.Stages[i].Next) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// stageIndex returns the position of the stage with the given environment name, or -1
+func (p *AtlasPipelineSpec) stageIndex(name string) int {
+	for i := range p.Stages {
+		if p.Stages[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// stageNames returns the environment names of the stages in order
+func (p *AtlasPipelineSpec) stageNames() []string {
+	names := make([]string, 0, len(p.Stages))
+	for _, stage := range p.Stages {
+		names = append(names, stage.Name)
+	}
+	return names
+}
+
+// AtlasPipeline defines the ordered environments AtlasApps are promoted through
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Stages",type="string",JSONPath=".spec.stages[*].name"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type AtlasPipeline struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec AtlasPipelineSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// AtlasPipelineList contains a list of AtlasPipeline
+type AtlasPipelineList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AtlasPipeline `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AtlasPipeline{}, &AtlasPipelineList{})
+}
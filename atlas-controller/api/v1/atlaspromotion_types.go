@@ -0,0 +1,150 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Labels set on AtlasPromotions to find them from their source AtlasApp
+const (
+	// SourceNamespaceLabel is the namespace of the source AtlasApp
+	SourceNamespaceLabel = "atlas.io/source-namespace"
+	// SourceNameLabel is the name of the source AtlasApp
+	SourceNameLabel = "atlas.io/source-name"
+)
+
+// PromotionDecision is the approve/reject decision on an AtlasPromotion
+type PromotionDecision string
+
+const (
+	// PromotionDecisionPending means nobody has decided on the promotion yet
+	PromotionDecisionPending PromotionDecision = "Pending"
+	// PromotionDecisionApproved lets the controller apply the promotion
+	PromotionDecisionApproved PromotionDecision = "Approved"
+	// PromotionDecisionRejected closes the promotion without applying it
+	PromotionDecisionRejected PromotionDecision = "Rejected"
+)
+
+// Phases reported in AtlasPromotionStatus.Phase
+const (
+	PromotionPhasePending    = "Pending"
+	PromotionPhaseApplied    = "Applied"
+	PromotionPhaseRejected   = "Rejected"
+	PromotionPhaseSuperseded = "Superseded"
+	PromotionPhaseFailed     = "Failed"
+)
+
+// AppReference identifies an AtlasApp
+type AppReference struct {
+	// Name of the AtlasApp
+	Name string `json:"name"`
+
+	// Namespace of the AtlasApp
+	Namespace string `json:"namespace"`
+}
+
+// AtlasPromotionSpec defines the desired state of AtlasPromotion
+type AtlasPromotionSpec struct {
+	// Source references the AtlasApp the version is promoted from
+	Source AppReference `json:"source"`
+
+	// Target references the AtlasApp that receives the promotion
+	Target AppReference `json:"target"`
+
+	// TargetEnvironment specifies the environment the version is promoted to
+	TargetEnvironment string `json:"targetEnvironment"`
+
+	// Version specifies the application version being promoted. It has to be
+	// the last known-good version of the source, whose release is applied.
+	Version string `json:"version"`
+
+	// Image specifies the container image repository being promoted
+	Image string `json:"image,omitempty"`
+
+	// ImageDigest specifies the image digest being promoted
+	ImageDigest string `json:"imageDigest,omitempty"`
+
+	// MigrationId specifies the database migration version being promoted. It
+	// has to be the last known-good migration of the source.
+	MigrationId int `json:"migrationId"`
+
+	// Decision approves or rejects the promotion
+	//+kubebuilder:validation:Enum=Pending;Approved;Rejected
+	//+kubebuilder:default=Pending
+	Decision PromotionDecision `json:"decision,omitempty"`
+
+	// DecidedBy identifies who approved or rejected the promotion. The
+	// validating webhook only accepts the username of the requesting user.
+	DecidedBy string `json:"decidedBy,omitempty"`
+
+	// Reason explains the decision
+	Reason string `json:"reason,omitempty"`
+}
+
+// AtlasPromotionStatus defines the observed state of AtlasPromotion
+type AtlasPromotionStatus struct {
+	// ObservedGeneration is the most recent generation observed by the controller
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Phase represents the current phase of the promotion
+	Phase string `json:"phase,omitempty"`
+
+	// Message provides additional information about the current state
+	Message string `json:"message,omitempty"`
+
+	// DecidedAt indicates when the controller observed the decision
+	DecidedAt *metav1.Time `json:"decidedAt,omitempty"`
+
+	// AppliedAt indicates when the promotion was applied to the target AtlasApp
+	AppliedAt *metav1.Time `json:"appliedAt,omitempty"`
+
+	// TargetGeneration is the generation of the target AtlasApp the promotion
+	// wrote. The approval of the promotion covers that generation only.
+	TargetGeneration int64 `json:"targetGeneration,omitempty"`
+}
+
+// AtlasPromotion is a request to promote a version from one environment to the next
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Source",type="string",JSONPath=".spec.source.name"
+//+kubebuilder:printcolumn:name="Target",type="string",JSONPath=".spec.targetEnvironment"
+//+kubebuilder:printcolumn:name="Version",type="string",JSONPath=".spec.version"
+//+kubebuilder:printcolumn:name="Migration",type="integer",JSONPath=".spec.migrationId"
+//+kubebuilder:printcolumn:name="Decision",type="string",JSONPath=".spec.decision"
+//+kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type AtlasPromotion struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AtlasPromotionSpec   `json:"spec,omitempty"`
+	Status AtlasPromotionStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// AtlasPromotionList contains a list of AtlasPromotion
+type AtlasPromotionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AtlasPromotion `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AtlasPromotion{}, &AtlasPromotionList{})
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// SetupWebhookWithManager registers the AtlasPromotion webhook with the manager
+func (r *AtlasPromotion) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithValidator(&atlasPromotionValidator{}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-atlas-io-v1-atlaspromotion,mutating=false,failurePolicy=fail,sideEffects=None,groups=atlas.io,resources=atlaspromotions,verbs=create;update,versions=v1,name=vatlaspromotion.kb.io,admissionReviewVersions=v1
+
+// atlasPromotionValidator validates AtlasPromotion changes
+type atlasPromotionValidator struct{}
+
+var _ webhook.CustomValidator = &atlasPromotionValidator{}
+
+// ValidateCreate implements webhook.CustomValidator
+func (v *atlasPromotionValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	promotion, ok := obj.(*AtlasPromotion)
+	if !ok {
+		return nil, fmt.Errorf("expected an AtlasPromotion but got a %T", obj)
+	}
+	return nil, promotionError(promotion, validatePromotion(ctx, nil, promotion))
+}
+
+// ValidateUpdate implements webhook.CustomValidator
+func (v *atlasPromotionValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldPromotion, ok := oldObj.(*AtlasPromotion)
+	if !ok {
+		return nil, fmt.Errorf("expected an AtlasPromotion but got a %T", oldObj)
+	}
+	promotion, ok := newObj.(*AtlasPromotion)
+	if !ok {
+		return nil, fmt.Errorf("expected an AtlasPromotion but got a %T", newObj)
+	}
+	return nil, promotionError(promotion, validatePromotion(ctx, oldPromotion, promotion))
+}
+
+// ValidateDelete implements webhook.CustomValidator
+func (v *atlasPromotionValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validatePromotion keeps the target in the namespace of the AtlasPromotion, so
+// creating one never reaches further than updating AtlasApps in that namespace,
+// and checks that a decision being made names the user making it
+func validatePromotion(ctx context.Context, oldPromotion, promotion *AtlasPromotion) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if promotion.Spec.Target.Namespace != promotion.Namespace {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("target", "namespace"), promotion.Spec.Target.Namespace,
+			"must be the namespace of the AtlasPromotion"))
+	}
+
+	decided := promotion.Spec.DecidedBy != "" ||
+		(promotion.Spec.Decision != "" && promotion.Spec.Decision != PromotionDecisionPending)
+	changed := oldPromotion == nil ||
+		oldPromotion.Spec.Decision != promotion.Spec.Decision ||
+		oldPromotion.Spec.DecidedBy != promotion.Spec.DecidedBy
+	if decided && changed {
+		path := specPath.Child("decidedBy")
+		req, err := admission.RequestFromContext(ctx)
+		if err != nil {
+			return append(allErrs, field.InternalError(path, err))
+		}
+		if promotion.Spec.DecidedBy != req.UserInfo.Username {
+			allErrs = append(allErrs, field.Forbidden(path, fmt.Sprintf("must be %q, the user deciding the promotion", req.UserInfo.Username)))
+		}
+	}
+
+	return allErrs
+}
+
+// promotionError wraps validation errors of an AtlasPromotion, if any
+func promotionError(promotion *AtlasPromotion, allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("AtlasPromotion").GroupKind(), promotion.Name, allErrs)
+}
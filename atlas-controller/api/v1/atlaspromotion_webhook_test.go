@@ -0,0 +1,89 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("AtlasPromotion validating webhook", func() {
+	var (
+		validator *atlasPromotionValidator
+		promotion *AtlasPromotion
+	)
+
+	BeforeEach(func() {
+		validator = &atlasPromotionValidator{}
+		promotion = &AtlasPromotion{
+			ObjectMeta: metav1.ObjectMeta{Name: "atlas-stage-to-prod-1.22.0-m6", Namespace: "payments-prod"},
+			Spec: AtlasPromotionSpec{
+				Source:            AppReference{Name: "atlas", Namespace: "payments-stage"},
+				Target:            AppReference{Name: "atlas", Namespace: "payments-prod"},
+				TargetEnvironment: "prod",
+				Version:           "1.22.0",
+				MigrationId:       6,
+			},
+		}
+	})
+
+	It("accepts a pending promotion without a decider", func() {
+		_, err := validator.ValidateCreate(context.Background(), promotion)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("keeps the target in the namespace of the promotion", func() {
+		promotion.Spec.Target.Namespace = "payments-stage"
+		_, err := validator.ValidateCreate(asUser("jane.doe"), promotion)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.target.namespace"))
+	})
+
+	It("requires the decider to be the requesting user", func() {
+		oldPromotion := promotion.DeepCopy()
+		promotion.Spec.Decision = PromotionDecisionApproved
+		promotion.Spec.DecidedBy = "john.doe"
+		_, err := validator.ValidateUpdate(asUser("jane.doe"), oldPromotion, promotion)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring(`must be "jane.doe", the user deciding the promotion`))
+
+		promotion.Spec.DecidedBy = "jane.doe"
+		_, err = validator.ValidateUpdate(asUser("jane.doe"), oldPromotion, promotion)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("requires a decider with the decision", func() {
+		promotion.Spec.Decision = PromotionDecisionRejected
+		_, err := validator.ValidateCreate(asUser("jane.doe"), promotion)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.decidedBy"))
+	})
+
+	It("lets other users update a decided promotion", func() {
+		promotion.Spec.Decision = PromotionDecisionApproved
+		promotion.Spec.DecidedBy = "jane.doe"
+		oldPromotion := promotion.DeepCopy()
+		promotion.Spec.Reason = "Passed QA"
+		_, err := validator.ValidateUpdate(asUser("john.doe"), oldPromotion, promotion)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -0,0 +1,86 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// These tests call the webhooks directly. The AtlasPipelines they read come
+// from a fake client, the requesting user from the admission request in the context.
+
+var testScheme = runtime.NewScheme()
+
+func TestWebhooks(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Webhook Suite")
+}
+
+var _ = BeforeSuite(func() {
+	Expect(AddToScheme(testScheme)).To(Succeed())
+})
+
+// newReader returns a client reading the given AtlasPipelines
+func newReader(pipelines ...*AtlasPipeline) client.Reader {
+	builder := fake.NewClientBuilder().WithScheme(testScheme)
+	for _, pipeline := range pipelines {
+		builder = builder.WithObjects(pipeline)
+	}
+	return builder.Build()
+}
+
+// asUser returns a context carrying an admission request made by the given user
+func asUser(username string) context.Context {
+	return admission.NewContextWithRequest(context.Background(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			UserInfo: authenticationv1.UserInfo{Username: username},
+		},
+	})
+}
+
+// paymentsPipeline is a pipeline with a QA stage between dev and prod
+var paymentsPipeline = &AtlasPipeline{
+	ObjectMeta: metav1.ObjectMeta{Name: "payments"},
+	Spec: AtlasPipelineSpec{Stages: []PipelineStage{
+		{Name: "dev", AutoPromote: true},
+		{Name: "qa", RequireApproval: true},
+		{Name: "prod", RequireApproval: true},
+	}},
+}
+
+// regionsPipeline is a pipeline fanning out from QA to two prod regions
+var regionsPipeline = &AtlasPipeline{
+	ObjectMeta: metav1.ObjectMeta{Name: "regions"},
+	Spec: AtlasPipelineSpec{Stages: []PipelineStage{
+		{Name: "qa", Next: []string{"prod-eu", "prod-us"}},
+		{Name: "prod-eu", RequireApproval: true},
+		{Name: "prod-us", RequireApproval: true},
+	}},
+}
@@ -0,0 +1,938 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package v1beta2
+
+import (
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// AtlasAppSpec defines the desired state of AtlasApp
+type AtlasAppSpec struct {
+	// Environment specifies the deployment environment, a stage of the promotion pipeline
+	Environment string `json:"environment"`
+
+	// Version specifies the application version to deploy, used as the image tag
+	Version string `json:"version"`
+
+	// MigrationId specifies the database migration version
+	//+kubebuilder:validation:Minimum=0
+	MigrationId int `json:"migrationId"`
+
+	// Migration configures the Job that migrates the database to MigrationId before rollout
+	Migration *MigrationSpec `json:"migration,omitempty"`
+
+	// Workload configures the pods running the application
+	Workload WorkloadSpec `json:"workload,omitempty"`
+
+	// Rollout configures how new versions replace the running one
+	Rollout RolloutSpec `json:"rollout,omitempty"`
+
+	// Promotion configures how versions move through the pipeline
+	Promotion PromotionSpec `json:"promotion,omitempty"`
+
+	// Health configures the application health checks, they are disabled if omitted
+	Health *HealthSpec `json:"health,omitempty"`
+
+	// Service configures the Service in front of the application pods
+	Service *ServiceSpec `json:"service,omitempty"`
+
+	// Exposure makes the controller own an Ingress or HTTPRoute exposing the
+	// application, its URL is published in status.url
+	Exposure *ExposureSpec `json:"exposure,omitempty"`
+
+	// NetworkPolicy makes the controller own NetworkPolicies limiting the
+	// traffic of the application pods and its Jobs
+	NetworkPolicy *NetworkPolicySpec `json:"networkPolicy,omitempty"`
+
+	// Deletion configures what happens when the AtlasApp is deleted
+	Deletion DeletionSpec `json:"deletion,omitempty"`
+}
+
+// WorkloadSpec defines the pods running the application
+type WorkloadSpec struct {
+	// Image specifies the container image, tagged with the spec version
+	Image ImageSpec `json:"image,omitempty"`
+
+	// Replicas specifies the number of replicas to deploy
+	//+kubebuilder:validation:Minimum=0
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// Autoscaling lets a HorizontalPodAutoscaler owned by the controller scale
+	// the application between its limits, replicas is then ignored
+	Autoscaling *AutoscalingSpec `json:"autoscaling,omitempty"`
+
+	// Resources specifies the compute resources of the application container
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Env lists additional environment variables of the application container.
+	// MIGRATION_ID and ENVIRONMENT are always set by the controller.
+	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// EnvFrom populates environment variables from ConfigMaps or Secrets
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
+
+	// Volumes lists volumes of the application pods, e.g. ConfigMaps or Secrets
+	Volumes []corev1.Volume `json:"volumes,omitempty"`
+
+	// VolumeMounts mounts Volumes into the application container
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+
+	// DisruptionBudget makes the controller own a PodDisruptionBudget for the
+	// application pods, so node drains keep some of them running
+	DisruptionBudget *DisruptionBudgetSpec `json:"disruptionBudget,omitempty"`
+
+	// TopologySpreadConstraints spread the application pods across nodes or
+	// zones. Constraints without a labelSelector select the pods of the AtlasApp.
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+
+	// Affinity specifies scheduling constraints of the application pods
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// Ports lists the ports of the application container, also exposed by the
+	// Service (defaults to a single port named http on 80)
+	Ports []PortSpec `json:"ports,omitempty"`
+
+	// Probes configures the probes of the application container
+	Probes ProbesSpec `json:"probes,omitempty"`
+}
+
+// ImageSpec defines the container image of the application
+type ImageSpec struct {
+	// Repository specifies the image repository, e.g. ghcr.io/org/app (defaults to nginx)
+	Repository string `json:"repository,omitempty"`
+
+	// Digest pins the image to an exact digest, e.g. sha256:...
+	//+kubebuilder:validation:Pattern=`^sha256:[a-f0-9]{64}$`
+	Digest string `json:"digest,omitempty"`
+
+	// PullSecrets references secrets in the namespace used to pull the image
+	PullSecrets []corev1.LocalObjectReference `json:"pullSecrets,omitempty"`
+}
+
+// RolloutSpec defines how new versions replace the running one (defaults to a rolling update)
+type RolloutSpec struct {
+	// Canary rolls new versions out to a canary Deployment in steps
+	Canary *CanaryStrategy `json:"canary,omitempty"`
+
+	// BlueGreen rolls new versions out to an idle Deployment and switches the Service over
+	// once it is healthy. Mutually exclusive with Canary.
+	BlueGreen *BlueGreenStrategy `json:"blueGreen,omitempty"`
+
+	// AutoRollback restores the last known-good version and migration ID when the
+	// rollout never becomes ready or fails its health checks
+	AutoRollback bool `json:"autoRollback,omitempty"`
+}
+
+// PromotionSpec defines how versions move through the pipeline
+type PromotionSpec struct {
+	// Pipeline references the cluster-scoped AtlasPipeline defining the promotion
+	// chain (defaults to dev -> stage -> prod)
+	Pipeline string `json:"pipeline,omitempty"`
+
+	// AutoPromote enables automatic promotion to the next environment once ready
+	AutoPromote bool `json:"autoPromote,omitempty"`
+
+	// NextEnvironment specifies the next environment for promotion (defaults to the next pipeline stage)
+	NextEnvironment string `json:"nextEnvironment,omitempty"`
+
+	// RequireApproval requires manual approval for deployment
+	RequireApproval bool `json:"requireApproval,omitempty"`
+
+	// Overrides adjust the settings of the AtlasApps created by promotion into an
+	// environment, e.g. larger resource limits in prod
+	Overrides []EnvironmentOverride `json:"overrides,omitempty"`
+}
+
+// EnvironmentOverride defines settings that replace the promoted ones in an environment
+type EnvironmentOverride struct {
+	// Environment specifies the environment the override applies to
+	Environment string `json:"environment"`
+
+	// Replicas replaces the promoted number of replicas
+	//+kubebuilder:validation:Minimum=0
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Autoscaling replaces the promoted autoscaling settings
+	Autoscaling *AutoscalingSpec `json:"autoscaling,omitempty"`
+
+	// Resources replaces the promoted compute resources
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Service replaces the promoted Service settings
+	Service *ServiceSpec `json:"service,omitempty"`
+
+	// Env sets environment variables, replacing promoted ones with the same name
+	Env []corev1.EnvVar `json:"env,omitempty"`
+}
+
+// DeletionSpec defines what happens when the AtlasApp is deleted
+type DeletionSpec struct {
+	// Policy specifies what happens to the AtlasApps this one promoted to (defaults to Orphan)
+	//+kubebuilder:validation:Enum=Orphan;Cascade
+	Policy DeletionPolicy `json:"policy,omitempty"`
+
+	// PreDeleteHooks lists Jobs run in order before the AtlasApp is deleted
+	PreDeleteHooks []DeletionHook `json:"preDeleteHooks,omitempty"`
+}
+
+// PortSpec defines a port of the application container and its Service
+type PortSpec struct {
+	// Name identifies the port in probes and the Service
+	//+kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	//+kubebuilder:validation:MaxLength=15
+	Name string `json:"name"`
+
+	// ContainerPort specifies the port the application listens on
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=65535
+	ContainerPort int32 `json:"containerPort"`
+
+	// ServicePort specifies the port the Service exposes (defaults to ContainerPort)
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=65535
+	ServicePort int32 `json:"servicePort,omitempty"`
+
+	// Protocol specifies the port protocol (defaults to TCP)
+	//+kubebuilder:validation:Enum=TCP;UDP;SCTP
+	Protocol corev1.Protocol `json:"protocol,omitempty"`
+
+	// NodePort pins the node port of NodePort and LoadBalancer Services
+	// (allocated by the cluster if omitted)
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=65535
+	NodePort int32 `json:"nodePort,omitempty"`
+}
+
+// ServiceSpec defines the Service in front of the application pods. Its ports
+// are those of the application, see PortSpec.
+type ServiceSpec struct {
+	// Type specifies the Service type (defaults to ClusterIP)
+	//+kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	Type corev1.ServiceType `json:"type,omitempty"`
+
+	// Annotations are set on the Service, e.g. to configure a cloud load balancer
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// SessionAffinity routes the requests of a client to the same pod with
+	// ClientIP (defaults to None)
+	//+kubebuilder:validation:Enum=None;ClientIP
+	SessionAffinity corev1.ServiceAffinity `json:"sessionAffinity,omitempty"`
+
+	// SessionAffinityTimeoutSeconds specifies how long ClientIP affinity sticks
+	// (defaults to 3 hours)
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=86400
+	SessionAffinityTimeoutSeconds *int32 `json:"sessionAffinityTimeoutSeconds,omitempty"`
+
+	// ExternalTrafficPolicy specifies if NodePort and LoadBalancer traffic is
+	// only routed to pods on the receiving node, preserving the client IP with Local
+	//+kubebuilder:validation:Enum=Cluster;Local
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicy `json:"externalTrafficPolicy,omitempty"`
+
+	// LoadBalancerSourceRanges restricts the clients of a LoadBalancer Service
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges,omitempty"`
+}
+
+// ProbeType selects how a probe checks the application container
+type ProbeType string
+
+const (
+	// ProbeTypeHTTPGet sends an HTTP GET request to Path
+	ProbeTypeHTTPGet ProbeType = "HTTPGet"
+	// ProbeTypeTCPSocket opens a TCP connection to the port
+	ProbeTypeTCPSocket ProbeType = "TCPSocket"
+	// ProbeTypeExec runs Command in the container
+	ProbeTypeExec ProbeType = "Exec"
+)
+
+// ProbeSpec defines a liveness, readiness or startup probe of the application
+// container. Timings left empty use the controller defaults for the probe.
+type ProbeSpec struct {
+	// Type selects how the container is checked (defaults to HTTPGet)
+	//+kubebuilder:validation:Enum=HTTPGet;TCPSocket;Exec
+	Type ProbeType `json:"type,omitempty"`
+
+	// Path specifies the HTTP path to request (defaults to /)
+	Path string `json:"path,omitempty"`
+
+	// Port names the entry of ports to check (defaults to the first one)
+	Port string `json:"port,omitempty"`
+
+	// Command specifies the command run by Exec probes
+	Command []string `json:"command,omitempty"`
+
+	// InitialDelaySeconds specifies the delay before the first check
+	//+kubebuilder:validation:Minimum=0
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+
+	// PeriodSeconds specifies the interval between checks
+	//+kubebuilder:validation:Minimum=0
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+
+	// TimeoutSeconds specifies the timeout of a check
+	//+kubebuilder:validation:Minimum=0
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+
+	// FailureThreshold specifies the consecutive failures after which the probe fails
+	//+kubebuilder:validation:Minimum=0
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+}
+
+// ProbesSpec groups the probes of the application container
+type ProbesSpec struct {
+	// Liveness configures the liveness probe (defaults to GET / after 30s, every 10s)
+	Liveness *ProbeSpec `json:"liveness,omitempty"`
+
+	// Readiness configures the readiness probe (defaults to GET / after 5s, every 5s)
+	Readiness *ProbeSpec `json:"readiness,omitempty"`
+
+	// Startup configures a startup probe for slow starting applications
+	Startup *ProbeSpec `json:"startup,omitempty"`
+}
+
+// AutoscalingSpec defines the HorizontalPodAutoscaler of the application
+type AutoscalingSpec struct {
+	// MinReplicas specifies the lower replica limit (defaults to 1)
+	//+kubebuilder:validation:Minimum=1
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// MaxReplicas specifies the upper replica limit
+	//+kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// TargetCPUUtilizationPercentage specifies the average CPU utilization to
+	// scale to, relative to the CPU requests in resources
+	//+kubebuilder:validation:Minimum=1
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+
+	// TargetMemoryUtilizationPercentage specifies the average memory utilization
+	// to scale to, relative to the memory requests in resources
+	//+kubebuilder:validation:Minimum=1
+	TargetMemoryUtilizationPercentage *int32 `json:"targetMemoryUtilizationPercentage,omitempty"`
+
+	// Behavior configures the scaling speed in both directions
+	Behavior *autoscalingv2.HorizontalPodAutoscalerBehavior `json:"behavior,omitempty"`
+}
+
+// DisruptionBudgetSpec defines the PodDisruptionBudget of the application.
+// Only one of MinAvailable and MaxUnavailable may be set.
+type DisruptionBudgetSpec struct {
+	// MinAvailable specifies the pods that must stay available during
+	// voluntary disruptions, as a number or percentage
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+
+	// MaxUnavailable specifies the pods that may be unavailable during
+	// voluntary disruptions, as a number or percentage (defaults to 1)
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// ExposureType selects the object exposing the application outside the cluster
+type ExposureType string
+
+const (
+	// ExposureTypeIngress exposes the application through a networking.k8s.io Ingress
+	ExposureTypeIngress ExposureType = "Ingress"
+	// ExposureTypeHTTPRoute exposes the application through a Gateway API HTTPRoute
+	ExposureTypeHTTPRoute ExposureType = "HTTPRoute"
+)
+
+// ExposureSpec defines how the application is exposed outside the cluster
+type ExposureSpec struct {
+	// Type selects an Ingress or a Gateway API HTTPRoute (defaults to Ingress)
+	//+kubebuilder:validation:Enum=Ingress;HTTPRoute
+	Type ExposureType `json:"type,omitempty"`
+
+	// Host specifies the hostname, as a template that can use {{.Name}},
+	// {{.Namespace}} and {{.Environment}}, e.g. atlas-{{.Environment}}.example.com
+	Host string `json:"host"`
+
+	// Paths lists the path prefixes routed to the application (defaults to /)
+	Paths []string `json:"paths,omitempty"`
+
+	// Port names the entry of ports to route to (defaults to the first one)
+	Port string `json:"port,omitempty"`
+
+	// TLS serves the host over HTTPS
+	TLS *ExposureTLS `json:"tls,omitempty"`
+
+	// IngressClassName selects the Ingress controller (defaults to the cluster default)
+	IngressClassName string `json:"ingressClassName,omitempty"`
+
+	// Gateway references the Gateway an HTTPRoute attaches to, required for HTTPRoute
+	Gateway *GatewayReference `json:"gateway,omitempty"`
+
+	// Annotations are set on the Ingress or HTTPRoute, e.g. for cert-manager
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// NetworkPolicySpec defines the traffic allowed to and from the application.
+// Everything else is denied once it is set.
+type NetworkPolicySpec struct {
+	// Ingress lists the peers allowed to connect to the application ports, in
+	// addition to the other pods of the AtlasApp and the controller's pods
+	Ingress []NetworkPolicyPeer `json:"ingress,omitempty"`
+
+	// IngressController allows the pods of the Ingress controller or Gateway
+	// serving spec.exposure to connect to the application ports
+	IngressController *NetworkPolicyPeer `json:"ingressController,omitempty"`
+
+	// Egress lists the destinations the application and its migration and
+	// pre-delete Jobs may connect to, e.g. the database
+	Egress []NetworkPolicyDestination `json:"egress,omitempty"`
+
+	// DisableDNS stops allowing DNS lookups, which are allowed to the kube-dns
+	// pods of the cluster by default
+	DisableDNS bool `json:"disableDNS,omitempty"`
+}
+
+// NetworkPolicyPeer selects pods allowed to connect to the application
+type NetworkPolicyPeer struct {
+	// Namespaces lists the namespaces of the pods by name (defaults to the
+	// namespace of the AtlasApp)
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// PodLabels selects the pods by label (defaults to all pods of the namespaces)
+	PodLabels map[string]string `json:"podLabels,omitempty"`
+}
+
+// NetworkPolicyDestination defines an IP range the application may connect to
+type NetworkPolicyDestination struct {
+	// CIDR specifies the IP range, e.g. 10.0.12.0/24 for the database subnet
+	CIDR string `json:"cidr"`
+
+	// Ports lists the allowed TCP ports (defaults to all ports)
+	Ports []int32 `json:"ports,omitempty"`
+}
+
+// ExposureTLS defines the TLS settings of the exposed host
+type ExposureTLS struct {
+	// SecretName references the TLS certificate of an Ingress. HTTPRoutes
+	// leave it empty, TLS is terminated by the Gateway listener.
+	SecretName string `json:"secretName,omitempty"`
+}
+
+// GatewayReference references a Gateway API Gateway
+type GatewayReference struct {
+	// Name specifies the Gateway name
+	Name string `json:"name"`
+
+	// Namespace specifies the Gateway namespace (defaults to the AtlasApp namespace)
+	Namespace string `json:"namespace,omitempty"`
+
+	// SectionName selects a listener of the Gateway
+	SectionName string `json:"sectionName,omitempty"`
+}
+
+// DeletionPolicy specifies what happens to downstream AtlasApps on deletion
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyOrphan keeps the AtlasApps this one promoted to
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+	// DeletionPolicyCascade deletes the AtlasApps this one promoted to, which
+	// apply their own deletion policy in turn
+	DeletionPolicyCascade DeletionPolicy = "Cascade"
+)
+
+// DeletionHook defines a Job run before the AtlasApp is deleted
+type DeletionHook struct {
+	// Name identifies the hook, it is part of the Job name
+	//+kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	//+kubebuilder:validation:MaxLength=20
+	Name string `json:"name"`
+
+	// Image specifies the hook container image (defaults to the application image)
+	Image string `json:"image,omitempty"`
+
+	// Command overrides the entrypoint of the hook container
+	Command []string `json:"command,omitempty"`
+
+	// Args specifies the arguments of the hook container
+	Args []string `json:"args,omitempty"`
+
+	// SecretRef references a secret in the namespace whose keys are exposed as environment variables
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// BackoffLimit specifies the number of retries before the hook is considered failed (defaults to 0)
+	//+kubebuilder:validation:Minimum=0
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+
+	// ActiveDeadlineSeconds limits how long the hook may run
+	//+kubebuilder:validation:Minimum=1
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+
+	// IgnoreFailure lets the deletion proceed when the hook fails, otherwise it
+	// is blocked until the hook is removed from the spec
+	IgnoreFailure bool `json:"ignoreFailure,omitempty"`
+}
+
+// BlueGreenStrategy defines a blue/green rollout
+type BlueGreenStrategy struct {
+	// ScaleDownDelaySeconds specifies how long the previous color keeps running after
+	// the switch, so it can be switched back to instantly (defaults to 600)
+	//+kubebuilder:validation:Minimum=0
+	ScaleDownDelaySeconds *int32 `json:"scaleDownDelaySeconds,omitempty"`
+}
+
+// CanaryStrategy defines the steps of a canary rollout
+type CanaryStrategy struct {
+	// Steps lists the canary steps in order, the new version is promoted after the last one
+	//+kubebuilder:validation:MinItems=1
+	Steps []CanaryStep `json:"steps"`
+}
+
+// CanaryStep defines the share of replicas running the new version and how long it is held
+type CanaryStep struct {
+	// Weight specifies the percentage of replicas running the new version
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=100
+	Weight int32 `json:"weight"`
+
+	// PauseSeconds specifies how long the step is held after its health checks pass
+	//+kubebuilder:validation:Minimum=0
+	PauseSeconds int32 `json:"pauseSeconds,omitempty"`
+}
+
+// MigrationSpec defines the Job that runs database migrations
+type MigrationSpec struct {
+	// Image specifies the migration container image (defaults to the application image)
+	Image string `json:"image,omitempty"`
+
+	// Command overrides the entrypoint of the migration container
+	Command []string `json:"command,omitempty"`
+
+	// Args specifies the arguments of the migration container
+	Args []string `json:"args,omitempty"`
+
+	// SecretRef references a secret in the namespace whose keys are exposed as environment variables
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// BackoffLimit specifies the number of retries before the migration is considered failed (defaults to 0)
+	//+kubebuilder:validation:Minimum=0
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+
+	// ActiveDeadlineSeconds limits how long the migration may run
+	//+kubebuilder:validation:Minimum=1
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+}
+
+// HealthCheckTarget selects what the controller sends health check requests to
+type HealthCheckTarget string
+
+const (
+	// HealthCheckTargetService probes the application through its managed Service
+	HealthCheckTargetService HealthCheckTarget = "Service"
+	// HealthCheckTargetPods probes every ready pod IP individually
+	HealthCheckTargetPods HealthCheckTarget = "Pods"
+)
+
+// HealthSpec defines the application health endpoint and how it is probed
+type HealthSpec struct {
+	// Path specifies the health check endpoint, e.g. /healthz
+	Path string `json:"path,omitempty"`
+
+	// Target selects whether the Service or each ready pod is probed (defaults to Service)
+	//+kubebuilder:validation:Enum=Service;Pods
+	Target HealthCheckTarget `json:"target,omitempty"`
+
+	// Port specifies the port the health endpoint is served on (defaults to the
+	// first entry of ports, its service port for the Service target)
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port,omitempty"`
+
+	// TimeoutSeconds specifies the timeout of a single health check request (defaults to 5)
+	//+kubebuilder:validation:Minimum=1
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+
+	// ExpectedStatusCodes lists the HTTP status codes treated as healthy (defaults to any 2xx)
+	ExpectedStatusCodes []int32 `json:"expectedStatusCodes,omitempty"`
+
+	// SuccessThreshold specifies the consecutive successful checks needed to become healthy (defaults to 1)
+	//+kubebuilder:validation:Minimum=1
+	SuccessThreshold int32 `json:"successThreshold,omitempty"`
+
+	// FailureThreshold specifies the consecutive failed checks needed to become unhealthy (defaults to 3)
+	//+kubebuilder:validation:Minimum=1
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+
+	// IntervalSeconds specifies the delay between health checks (defaults to 10)
+	//+kubebuilder:validation:Minimum=1
+	IntervalSeconds int32 `json:"intervalSeconds,omitempty"`
+}
+
+// AtlasAppStatus defines the observed state of AtlasApp
+type AtlasAppStatus struct {
+	// ObservedGeneration is the most recent generation observed by the controller
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Phase represents the current phase of the application
+	Phase string `json:"phase,omitempty"`
+
+	// Ready indicates if the application is ready and healthy
+	Ready bool `json:"ready,omitempty"`
+
+	// ReadyReplicas indicates the number of ready replicas
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+
+	// TotalReplicas indicates the total number of replicas
+	TotalReplicas int32 `json:"totalReplicas,omitempty"`
+
+	// LastUpdate indicates when the deployment was last updated
+	LastUpdate *metav1.Time `json:"lastUpdate,omitempty"`
+
+	// ApprovalRequired indicates if manual approval is needed
+	ApprovalRequired bool `json:"approvalRequired,omitempty"`
+
+	// Approval records the approval the current spec is deployed under
+	Approval *ApprovalStatus `json:"approval,omitempty"`
+
+	// DeployedVersion is the version last rolled out, lower versions are refused without an override
+	DeployedVersion string `json:"deployedVersion,omitempty"`
+
+	// DeployedMigrationId is the migration ID last rolled out, lower IDs are refused without an override
+	DeployedMigrationId int `json:"deployedMigrationId,omitempty"`
+
+	// AppliedMigrationId is the migration ID whose migration Job last succeeded
+	AppliedMigrationId int `json:"appliedMigrationId,omitempty"`
+
+	// LastKnownGood is the most recent release that reached the Ready phase
+	LastKnownGood *Revision `json:"lastKnownGood,omitempty"`
+
+	// Rollback records the automatic rollback of the spec version, if it failed
+	Rollback *RollbackStatus `json:"rollback,omitempty"`
+
+	// PromotionPending indicates if promotion to next env is pending
+	PromotionPending bool `json:"promotionPending,omitempty"`
+
+	// Conditions represents the current conditions of the application
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// Message provides additional information about the current state
+	Message string `json:"message,omitempty"`
+
+	// URL is where the application is reachable through spec.exposure
+	URL string `json:"url,omitempty"`
+
+	// HealthCheck records the outcome of the most recent health checks
+	HealthCheck *HealthCheckStatus `json:"healthCheck,omitempty"`
+
+	// Canary reports the progress of the current or last canary rollout
+	Canary *CanaryStatus `json:"canary,omitempty"`
+
+	// BlueGreen reports the colors of a blue/green rollout
+	BlueGreen *BlueGreenStatus `json:"blueGreen,omitempty"`
+
+	// History lists the most recently deployed revisions, newest first
+	History []RevisionHistory `json:"history,omitempty"`
+
+	// DriftCorrections lists the most recent out-of-band changes to managed objects that were reverted
+	DriftCorrections []DriftCorrection `json:"driftCorrections,omitempty"`
+}
+
+// CanaryPhase is the state of a canary rollout
+type CanaryPhase string
+
+const (
+	// CanaryPhaseProgressing means the canary is stepping through spec.strategy.canary.steps
+	CanaryPhaseProgressing CanaryPhase = "Progressing"
+	// CanaryPhasePromoted means all steps passed and the new version replaced the stable one
+	CanaryPhasePromoted CanaryPhase = "Promoted"
+	// CanaryPhaseAborted means the canary failed and the stable version was restored
+	CanaryPhaseAborted CanaryPhase = "Aborted"
+)
+
+// CanaryStatus reports the progress of a canary rollout
+type CanaryStatus struct {
+	// Phase is the state of the canary rollout
+	Phase CanaryPhase `json:"phase"`
+
+	// Version is the version rolled out as canary
+	Version string `json:"version"`
+
+	// Image is the image reference rolled out as canary
+	Image string `json:"image"`
+
+	// StableVersion is the version the stable Deployment runs
+	StableVersion string `json:"stableVersion,omitempty"`
+
+	// StableImage is the image reference the stable Deployment runs
+	StableImage string `json:"stableImage,omitempty"`
+
+	// Generation is the AtlasApp generation the canary last acted on, an aborted canary is retried once it changes
+	Generation int64 `json:"generation,omitempty"`
+
+	// Step is the index of the current step in spec.strategy.canary.steps
+	Step int32 `json:"step"`
+
+	// Weight is the percentage of replicas currently running the canary
+	Weight int32 `json:"weight,omitempty"`
+
+	// StepHealthyAt indicates when the current step passed its health checks
+	StepHealthyAt *metav1.Time `json:"stepHealthyAt,omitempty"`
+
+	// Message provides additional information about the canary
+	Message string `json:"message,omitempty"`
+}
+
+// DeploymentColor is one of the two Deployments of a blue/green rollout
+type DeploymentColor string
+
+const (
+	ColorBlue  DeploymentColor = "blue"
+	ColorGreen DeploymentColor = "green"
+)
+
+// BlueGreenStatus reports the state of a blue/green rollout
+type BlueGreenStatus struct {
+	// ActiveColor is the color the Service currently selects
+	ActiveColor DeploymentColor `json:"activeColor,omitempty"`
+
+	// ActiveVersion is the version the active color runs
+	ActiveVersion string `json:"activeVersion,omitempty"`
+
+	// PreviewColor is the idle color the new version is rolled out to
+	PreviewColor DeploymentColor `json:"previewColor,omitempty"`
+
+	// PreviewVersion is the version rolled out to the preview color
+	PreviewVersion string `json:"previewVersion,omitempty"`
+
+	// PreviewImage is the image reference rolled out to the preview color
+	PreviewImage string `json:"previewImage,omitempty"`
+
+	// Aborted indicates the preview failed its health checks and was scaled down
+	Aborted bool `json:"aborted,omitempty"`
+
+	// Generation is the AtlasApp generation the preview last acted on, an aborted preview is retried once it changes
+	Generation int64 `json:"generation,omitempty"`
+
+	// SwitchedAt indicates when the Service was last switched to another color
+	SwitchedAt *metav1.Time `json:"switchedAt,omitempty"`
+
+	// ScaleDownAt indicates when the previous color will be scaled down
+	ScaleDownAt *metav1.Time `json:"scaleDownAt,omitempty"`
+
+	// Message provides additional information about the blue/green rollout
+	Message string `json:"message,omitempty"`
+}
+
+// Revision identifies a release of an AtlasApp
+type Revision struct {
+	// Version is the application version
+	Version string `json:"version"`
+
+	// Image is the image reference the version ran as
+	Image string `json:"image"`
+
+	// MigrationId is the database migration version
+	MigrationId int `json:"migrationId"`
+
+	// ReadyAt indicates when the release reached the Ready phase
+	ReadyAt *metav1.Time `json:"readyAt,omitempty"`
+}
+
+// RollbackStatus records an automatic rollback to the last known-good release
+type RollbackStatus struct {
+	// Failed is the release that failed, it is not rolled out again until the spec changes
+	Failed Revision `json:"failed"`
+
+	// RestoredTo is the known-good release running instead
+	RestoredTo Revision `json:"restoredTo"`
+
+	// Reason explains why the failed release was rolled back
+	Reason string `json:"reason"`
+
+	// Time indicates when the rollback happened
+	Time metav1.Time `json:"time"`
+}
+
+// RevisionTrigger is what caused a revision to be deployed
+type RevisionTrigger string
+
+const (
+	// RevisionTriggerManual means the AtlasApp spec was changed directly
+	RevisionTriggerManual RevisionTrigger = "Manual"
+	// RevisionTriggerPromotion means the revision was promoted from another AtlasApp
+	RevisionTriggerPromotion RevisionTrigger = "Promotion"
+	// RevisionTriggerRollback means the revision was restored by an automatic rollback
+	RevisionTriggerRollback RevisionTrigger = "Rollback"
+)
+
+// RevisionOutcome is the result of deploying a revision
+type RevisionOutcome string
+
+const (
+	// RevisionOutcomeProgressing means the revision is being rolled out
+	RevisionOutcomeProgressing RevisionOutcome = "Progressing"
+	// RevisionOutcomeSucceeded means the revision reached the Ready phase
+	RevisionOutcomeSucceeded RevisionOutcome = "Succeeded"
+	// RevisionOutcomeFailed means the migration, rollout or health checks of the revision failed
+	RevisionOutcomeFailed RevisionOutcome = "Failed"
+	// RevisionOutcomeAborted means a canary or blue/green rollout of the revision was aborted
+	RevisionOutcomeAborted RevisionOutcome = "Aborted"
+	// RevisionOutcomeRolledBack means the revision failed and was replaced by the last known-good one
+	RevisionOutcomeRolledBack RevisionOutcome = "RolledBack"
+	// RevisionOutcomeSuperseded means a newer revision was deployed before this one finished
+	RevisionOutcomeSuperseded RevisionOutcome = "Superseded"
+)
+
+// RevisionHistory records a revision the AtlasApp deployed
+type RevisionHistory struct {
+	// Version is the application version
+	Version string `json:"version"`
+
+	// MigrationId is the database migration version
+	MigrationId int `json:"migrationId"`
+
+	// Image is the image reference deployed
+	Image string `json:"image"`
+
+	// ImageDigest is the digest the image was pinned to, if any
+	ImageDigest string `json:"imageDigest,omitempty"`
+
+	// Trigger is what caused the revision to be deployed
+	Trigger RevisionTrigger `json:"trigger"`
+
+	// TriggeredBy details the trigger, e.g. the AtlasApp a promotion came from
+	TriggeredBy string `json:"triggeredBy,omitempty"`
+
+	// StartedAt indicates when the rollout of the revision started
+	StartedAt metav1.Time `json:"startedAt"`
+
+	// ReadyAt indicates when the revision reached the Ready phase
+	ReadyAt *metav1.Time `json:"readyAt,omitempty"`
+
+	// Outcome is the result of deploying the revision
+	Outcome RevisionOutcome `json:"outcome"`
+}
+
+// ApprovalStatus records who approved a deployment and what exactly was approved
+type ApprovalStatus struct {
+	// ApprovedBy identifies who approved the deployment
+	ApprovedBy string `json:"approvedBy"`
+
+	// ApprovedAt indicates when the controller accepted the approval
+	ApprovedAt metav1.Time `json:"approvedAt"`
+
+	// Version is the approved application version
+	Version string `json:"version"`
+
+	// MigrationId is the approved database migration version
+	MigrationId int `json:"migrationId"`
+
+	// Generation is the AtlasApp generation the approval is valid for, any
+	// later spec change invalidates it
+	Generation int64 `json:"generation"`
+
+	// Promotion is the namespace/name of the AtlasPromotion that carried the
+	// approval, if it was not given with annotations
+	Promotion string `json:"promotion,omitempty"`
+}
+
+// DriftCorrection records a managed object that was reverted to the desired state
+type DriftCorrection struct {
+	// Kind is the kind of the corrected object
+	Kind string `json:"kind"`
+
+	// Name is the name of the corrected object
+	Name string `json:"name"`
+
+	// Time indicates when the drift was corrected
+	Time metav1.Time `json:"time"`
+}
+
+// HealthCheckStatus defines the observed health of the application
+type HealthCheckStatus struct {
+	// Version is the application version the counters below refer to
+	Version string `json:"version,omitempty"`
+
+	// Image is the container image the counters below refer to
+	Image string `json:"image,omitempty"`
+
+	// MigrationId is the migration ID the counters below refer to
+	MigrationId int `json:"migrationId,omitempty"`
+
+	// Healthy indicates if the success threshold has been reached for the release
+	Healthy bool `json:"healthy,omitempty"`
+
+	// ConsecutiveSuccesses counts the successful checks since the last failure
+	ConsecutiveSuccesses int32 `json:"consecutiveSuccesses,omitempty"`
+
+	// ConsecutiveFailures counts the failed checks since the last success
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+
+	// LastCheckTime indicates when the application was last probed
+	LastCheckTime *metav1.Time `json:"lastCheckTime,omitempty"`
+
+	// Results holds the per-target results of the most recent check
+	Results []HealthCheckResult `json:"results,omitempty"`
+}
+
+// HealthCheckResult is the outcome of probing a single target
+type HealthCheckResult struct {
+	// URL is the address that was probed
+	URL string `json:"url"`
+
+	// Healthy indicates if the target answered with an expected status code
+	Healthy bool `json:"healthy"`
+
+	// StatusCode is the HTTP status code returned by the target
+	StatusCode int32 `json:"statusCode,omitempty"`
+
+	// Message describes why the check failed
+	Message string `json:"message,omitempty"`
+}
+
+// AtlasApp defines an Atlas application deployment
+//+kubebuilder:object:root=true
+//+kubebuilder:storageversion
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Environment",type="string",JSONPath=".spec.environment"
+//+kubebuilder:printcolumn:name="Image",type="string",JSONPath=".spec.workload.image.repository",priority=1
+//+kubebuilder:printcolumn:name="Version",type="string",JSONPath=".spec.version"
+//+kubebuilder:printcolumn:name="Migration",type="integer",JSONPath=".spec.migrationId"
+//+kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="Ready",type="boolean",JSONPath=".status.ready"
+//+kubebuilder:printcolumn:name="Replicas",type="string",JSONPath=".status.readyReplicas"
+//+kubebuilder:printcolumn:name="URL",type="string",JSONPath=".status.url",priority=1
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type AtlasApp struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AtlasAppSpec   `json:"spec,omitempty"`
+	Status AtlasAppStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// AtlasAppList contains a list of AtlasApp
+type AtlasAppList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AtlasApp `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AtlasApp{}, &AtlasAppList{})
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/csaupgrade"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+const (
+	// fieldManager is the server-side apply field manager owning managed objects
+	fieldManager = "atlas-controller"
+
+	// maxDriftCorrections bounds the drift corrections kept in the status
+	maxDriftCorrections = 10
+)
+
+// legacyFieldManagers are the field managers older controller versions used for
+// Create/Update calls ("manager" in the container image, "main" with go run)
+var legacyFieldManagers = sets.New("manager", "main", fieldManager)
+
+// applyOwned server-side applies obj as a child of the AtlasApp. Fields owned by
+// other managers are taken over, so any manual change to a field the controller
+// sets is reverted. With detectDrift, a change to an existing object while the
+// AtlasApp generation has already been observed is recorded as drift. Callers
+// changing objects on purpose without a new generation, e.g. during a canary,
+// turn it off.
+func (r *AtlasAppReconciler) applyOwned(ctx context.Context, atlasApp *atlasv1.AtlasApp, obj client.Object, existing client.Object, detectDrift bool) error {
+	log := log.FromContext(ctx)
+
+	gvk, err := r.GroupVersionKindFor(obj)
+	if err != nil {
+		return err
+	}
+	// Apply requests need the type information in the body
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+
+	if err := ctrl.SetControllerReference(atlasApp, obj, r.Scheme); err != nil {
+		return err
+	}
+
+	err = r.Get(ctx, client.ObjectKeyFromObject(obj), existing)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new "+gvk.Kind, "Namespace", obj.GetNamespace(), "Name", obj.GetName())
+		return r.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+	} else if err != nil {
+		return err
+	}
+
+	if !metav1.IsControlledBy(existing, atlasApp) {
+		return fmt.Errorf("%s %s already exists and is not owned by AtlasApp %s", gvk.Kind, existing.GetName(), atlasApp.Name)
+	}
+
+	// Hand fields set through Create/Update by older versions over to the apply
+	// manager, otherwise fields removed from the desired state would never be pruned
+	patch, err := csaupgrade.UpgradeManagedFieldsPatch(existing, legacyFieldManagers, fieldManager)
+	if err != nil {
+		return err
+	}
+	if patch != nil {
+		log.Info("Upgrading managed fields to server-side apply", "kind", gvk.Kind, "Name", existing.GetName())
+		if err := r.Patch(ctx, existing, client.RawPatch(types.JSONPatchType, patch)); err != nil {
+			return err
+		}
+	}
+
+	before, err := runtime.DefaultUnstructuredConverter.ToUnstructured(existing)
+	if err != nil {
+		return err
+	}
+
+	if err := r.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership); err != nil {
+		return err
+	}
+
+	after, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+
+	if detectDrift && drifted(before, after) && atlasApp.Status.ObservedGeneration == atlasApp.Generation {
+		log.Info("Corrected drift", "kind", gvk.Kind, "Name", obj.GetName())
+		r.recordDrift(atlasApp, gvk.Kind, obj.GetName())
+	}
+
+	return nil
+}
+
+// drifted reports if applying changed the spec or labels of an object
+func drifted(before, after map[string]interface{}) bool {
+	beforeMeta, _ := before["metadata"].(map[string]interface{})
+	afterMeta, _ := after["metadata"].(map[string]interface{})
+
+	return !equality.Semantic.DeepEqual(before["spec"], after["spec"]) ||
+		!equality.Semantic.DeepEqual(beforeMeta["labels"], afterMeta["labels"])
+}
+
+// recordDrift adds a drift correction to the status and emits an event
+func (r *AtlasAppReconciler) recordDrift(atlasApp *atlasv1.AtlasApp, kind, name string) {
+	correction := atlasv1.DriftCorrection{
+		Kind: kind,
+		Name: name,
+		Time: metav1.Now(),
+	}
+
+	corrections := append([]atlasv1.DriftCorrection{correction}, atlasApp.Status.DriftCorrections...)
+	if len(corrections) > maxDriftCorrections {
+		corrections = corrections[:maxDriftCorrections]
+	}
+	atlasApp.Status.DriftCorrections = corrections
+
+	r.Recorder.Eventf(atlasApp, corev1.EventTypeWarning, "DriftCorrected",
+		"Reverted out-of-band changes to %s %s", kind, name)
+}
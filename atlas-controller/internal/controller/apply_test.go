@@ -0,0 +1,109 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+var _ = Describe("Drift correction", func() {
+	var (
+		r        *AtlasAppReconciler
+		atlasApp *atlasv1.AtlasApp
+		key      types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		r = newAppReconciler()
+		atlasApp = newApp(newNamespace("drift"), "dev", "1.0.0")
+		atlasApp.Spec.HealthCheckPath = ""
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		key = client.ObjectKeyFromObject(atlasApp)
+
+		reconcileApp(r, atlasApp)
+		markDeploymentReady(key)
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.DriftCorrections).To(BeEmpty())
+	})
+
+	It("reverts manual changes to the Deployment and records them", func() {
+		deployment := getDeployment(key)
+		container := &deployment.Spec.Template.Spec.Containers[0]
+		container.Image = "nginx:hotfix"
+		container.ReadinessProbe.PeriodSeconds = 60
+		for i := range container.Env {
+			if container.Env[i].Name == "ENVIRONMENT" {
+				container.Env[i].Value = "prod"
+			}
+		}
+		replicas := int32(3)
+		deployment.Spec.Replicas = &replicas
+		Expect(k8sClient.Update(ctx, deployment, client.FieldOwner("kubectl-edit"))).To(Succeed())
+
+		_, updated := reconcileApp(r, atlasApp)
+		deployment = getDeployment(key)
+		Expect(*deployment.Spec.Replicas).To(Equal(int32(1)))
+		Expect(deployment.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.0.0"))
+		Expect(deployment.Spec.Template.Spec.Containers[0].ReadinessProbe.PeriodSeconds).To(Equal(int32(5)))
+		Expect(deployment.Spec.Template.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "ENVIRONMENT", Value: "dev"}))
+
+		Expect(updated.Status.DriftCorrections).To(HaveLen(1))
+		Expect(updated.Status.DriftCorrections[0].Kind).To(Equal("Deployment"))
+		Expect(updated.Status.DriftCorrections[0].Name).To(Equal("atlas"))
+		Expect(r.Recorder.(*record.FakeRecorder).Events).To(Receive(ContainSubstring("DriftCorrected")))
+	})
+
+	It("reverts manual changes to the Service labels", func() {
+		service := &corev1.Service{}
+		Expect(k8sClient.Get(ctx, key, service)).To(Succeed())
+		service.Labels[appNameLabel] = "other"
+		Expect(k8sClient.Update(ctx, service, client.FieldOwner("kubectl-edit"))).To(Succeed())
+
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(k8sClient.Get(ctx, key, service)).To(Succeed())
+		Expect(service.Labels[appNameLabel]).To(Equal("atlas"))
+		Expect(updated.Status.DriftCorrections).To(HaveLen(1))
+		Expect(updated.Status.DriftCorrections[0].Kind).To(Equal("Service"))
+	})
+
+	It("does not count a spec change as drift", func() {
+		latest := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, key, latest)).To(Succeed())
+		latest.Spec.Replicas = 2
+		Expect(k8sClient.Update(ctx, latest)).To(Succeed())
+
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(*getDeployment(key).Spec.Replicas).To(Equal(int32(2)))
+		Expect(updated.Status.DriftCorrections).To(BeEmpty())
+	})
+
+	It("bounds the drift corrections kept in the status", func() {
+		app := newApp("default", "dev", "1.0.0")
+		for i := 0; i < maxDriftCorrections+2; i++ {
+			r.recordDrift(app, "Deployment", "atlas")
+		}
+		Expect(app.Status.DriftCorrections).To(HaveLen(maxDriftCorrections))
+	})
+})
@@ -0,0 +1,186 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+// approvalAnnotations are removed from the AtlasApp once they have been evaluated
+var approvalAnnotations = []string{
+	atlasv1.ApprovedByAnnotation,
+	atlasv1.ApprovedVersionAnnotation,
+	atlasv1.ApprovedMigrationAnnotation,
+}
+
+// isApproved reports if the recorded approval covers the current generation
+func isApproved(atlasApp *atlasv1.AtlasApp) bool {
+	approval := atlasApp.Status.Approval
+	return approval != nil &&
+		approval.Generation == atlasApp.Generation &&
+		approval.Version == atlasApp.Spec.Version &&
+		approval.MigrationId == atlasApp.Spec.MigrationId
+}
+
+// checkApproval evaluates pending approval annotations and reports if the
+// current spec may be deployed. Accepted approvals are recorded in the status
+// for the current generation, so any spec change requires a new approval.
+func (r *AtlasAppReconciler) checkApproval(ctx context.Context, atlasApp *atlasv1.AtlasApp) (bool, error) {
+	log := log.FromContext(ctx)
+
+	if _, ok := atlasApp.Annotations[atlasv1.ApprovedByAnnotation]; !ok {
+		if isApproved(atlasApp) {
+			return true, nil
+		}
+		// Drop approvals for earlier generations so they are never mistaken as current
+		approval, err := r.promotionApproval(ctx, atlasApp)
+		if err != nil {
+			return false, err
+		}
+		atlasApp.Status.Approval = approval
+		if approval != nil {
+			log.Info("Deployment approved by promotion", "promotion", approval.Promotion, "approvedBy", approval.ApprovedBy)
+			r.Recorder.Eventf(atlasApp, corev1.EventTypeNormal, "Approved",
+				"Version %s with migration %d approved by %s in AtlasPromotion %s", approval.Version, approval.MigrationId, approval.ApprovedBy, approval.Promotion)
+		}
+		return isApproved(atlasApp), nil
+	}
+
+	approval, err := approvalFromAnnotations(atlasApp)
+	if err != nil {
+		log.Info("Rejecting approval", "reason", err.Error())
+		r.Recorder.Event(atlasApp, corev1.EventTypeWarning, "ApprovalRejected", err.Error())
+	} else {
+		log.Info("Deployment approved", "approvedBy", approval.ApprovedBy, "version", approval.Version, "migrationId", approval.MigrationId)
+		r.Recorder.Eventf(atlasApp, corev1.EventTypeNormal, "Approved",
+			"Version %s with migration %d approved by %s", approval.Version, approval.MigrationId, approval.ApprovedBy)
+		atlasApp.Status.Approval = approval
+
+		// Record the approval before the annotations carrying it are removed
+		if err := r.writeStatus(ctx, atlasApp); err != nil {
+			return false, err
+		}
+	}
+
+	// The annotations are one-shot, the status is the record of the approval
+	if err := r.removeAnnotations(ctx, atlasApp, approvalAnnotations); err != nil {
+		return false, err
+	}
+
+	return isApproved(atlasApp), nil
+}
+
+// approvalFromAnnotations validates the approval annotations against the spec
+func approvalFromAnnotations(atlasApp *atlasv1.AtlasApp) (*atlasv1.ApprovalStatus, error) {
+	annotations := atlasApp.Annotations
+
+	approvedBy := annotations[atlasv1.ApprovedByAnnotation]
+	if approvedBy == "" {
+		return nil, fmt.Errorf("annotation %s must name the approver", atlasv1.ApprovedByAnnotation)
+	}
+
+	version := annotations[atlasv1.ApprovedVersionAnnotation]
+	if version != atlasApp.Spec.Version {
+		return nil, fmt.Errorf("approved version %q does not match spec version %q", version, atlasApp.Spec.Version)
+	}
+
+	migrationId, err := strconv.Atoi(annotations[atlasv1.ApprovedMigrationAnnotation])
+	if err != nil {
+		return nil, fmt.Errorf("annotation %s must be a migration ID: %w", atlasv1.ApprovedMigrationAnnotation, err)
+	}
+	if migrationId != atlasApp.Spec.MigrationId {
+		return nil, fmt.Errorf("approved migration %d does not match spec migration %d", migrationId, atlasApp.Spec.MigrationId)
+	}
+
+	return &atlasv1.ApprovalStatus{
+		ApprovedBy:  approvedBy,
+		ApprovedAt:  metav1.Now(),
+		Version:     version,
+		MigrationId: migrationId,
+		Generation:  atlasApp.Generation,
+	}, nil
+}
+
+// promotionApproval finds an applied AtlasPromotion that wrote the current
+// generation of the AtlasApp. Its decision was made by the user recorded in
+// decidedBy, which the validating webhook checks, so it approves that
+// generation in place of the approval annotations.
+func (r *AtlasAppReconciler) promotionApproval(ctx context.Context, atlasApp *atlasv1.AtlasApp) (*atlasv1.ApprovalStatus, error) {
+	var promotions atlasv1.AtlasPromotionList
+	if err := r.List(ctx, &promotions, client.InNamespace(atlasApp.Namespace)); err != nil {
+		return nil, err
+	}
+	for _, promotion := range promotions.Items {
+		if promotion.Spec.Target.Name != atlasApp.Name ||
+			promotion.Spec.Target.Namespace != atlasApp.Namespace ||
+			promotion.Spec.Decision != atlasv1.PromotionDecisionApproved ||
+			promotion.Status.Phase != atlasv1.PromotionPhaseApplied ||
+			promotion.Status.TargetGeneration != atlasApp.Generation ||
+			promotion.Spec.Version != atlasApp.Spec.Version ||
+			promotion.Spec.MigrationId != atlasApp.Spec.MigrationId {
+			continue
+		}
+		approvedAt := metav1.Now()
+		if promotion.Status.AppliedAt != nil {
+			approvedAt = *promotion.Status.AppliedAt
+		}
+		return &atlasv1.ApprovalStatus{
+			ApprovedBy:  promotion.Spec.DecidedBy,
+			ApprovedAt:  approvedAt,
+			Version:     promotion.Spec.Version,
+			MigrationId: promotion.Spec.MigrationId,
+			Generation:  atlasApp.Generation,
+			Promotion:   client.ObjectKeyFromObject(&promotion).String(),
+		}, nil
+	}
+	return nil, nil
+}
+
+// removeAnnotations strips the given one-shot annotations from the AtlasApp
+func (r *AtlasAppReconciler) removeAnnotations(ctx context.Context, atlasApp *atlasv1.AtlasApp, keys []string) error {
+	// Patching refreshes the object from the server, keep the status computed so far
+	status := atlasApp.Status.DeepCopy()
+
+	patch := client.MergeFrom(atlasApp.DeepCopy())
+	for _, key := range keys {
+		delete(atlasApp.Annotations, key)
+	}
+	if err := r.Patch(ctx, atlasApp, patch); err != nil {
+		return err
+	}
+
+	atlasApp.Status = *status
+	return nil
+}
+
+// approvalMessage explains how to approve the current spec
+func approvalMessage(atlasApp *atlasv1.AtlasApp) string {
+	return fmt.Sprintf("Deployment of version %s with migration %d requires manual approval: annotate with %s=<your username>, %s=%s and %s=%d",
+		atlasApp.Spec.Version, atlasApp.Spec.MigrationId,
+		atlasv1.ApprovedByAnnotation,
+		atlasv1.ApprovedVersionAnnotation, atlasApp.Spec.Version,
+		atlasv1.ApprovedMigrationAnnotation, atlasApp.Spec.MigrationId)
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+// annotate merges the annotations into the stored AtlasApp
+func annotate(atlasApp *atlasv1.AtlasApp, annotations map[string]string) {
+	latest := &atlasv1.AtlasApp{}
+	Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(atlasApp), latest)).To(Succeed())
+	patch := client.MergeFrom(latest.DeepCopy())
+	if latest.Annotations == nil {
+		latest.Annotations = map[string]string{}
+	}
+	for k, v := range annotations {
+		latest.Annotations[k] = v
+	}
+	Expect(k8sClient.Patch(ctx, latest, patch)).To(Succeed())
+}
+
+var _ = Describe("Manual approval", func() {
+	var (
+		r        *AtlasAppReconciler
+		atlasApp *atlasv1.AtlasApp
+	)
+
+	BeforeEach(func() {
+		r = newAppReconciler()
+		atlasApp = newApp(newNamespace("approval"), "prod", "1.22.0")
+		atlasApp.Spec.MigrationId = 6
+		atlasApp.Spec.RequireApproval = true
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+	})
+
+	deploymentExists := func() bool {
+		err := k8sClient.Get(ctx, types.NamespacedName{Name: atlasApp.Name, Namespace: atlasApp.Namespace}, &appsv1.Deployment{})
+		if errors.IsNotFound(err) {
+			return false
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return true
+	}
+
+	It("blocks the rollout until the spec is approved", func() {
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhasePendingApproval))
+		Expect(updated.Status.ApprovalRequired).To(BeTrue())
+		Expect(updated.Status.Message).To(ContainSubstring("atlas.io/approved-version=1.22.0"))
+		Expect(deploymentExists()).To(BeFalse())
+	})
+
+	It("records a matching approval and removes the annotations", func() {
+		reconcileApp(r, atlasApp)
+		annotate(atlasApp, map[string]string{
+			atlasv1.ApprovedByAnnotation:        "jane.doe",
+			atlasv1.ApprovedVersionAnnotation:   "1.22.0",
+			atlasv1.ApprovedMigrationAnnotation: "6",
+		})
+
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Approval).NotTo(BeNil())
+		Expect(updated.Status.Approval.ApprovedBy).To(Equal("jane.doe"))
+		Expect(updated.Status.Approval.Version).To(Equal("1.22.0"))
+		Expect(updated.Status.Approval.MigrationId).To(Equal(6))
+		Expect(updated.Status.Approval.Generation).To(Equal(updated.Generation))
+		Expect(updated.Status.ApprovalRequired).To(BeFalse())
+		Expect(updated.Annotations).NotTo(HaveKey(atlasv1.ApprovedByAnnotation))
+		Expect(updated.Annotations).NotTo(HaveKey(atlasv1.ApprovedVersionAnnotation))
+		Expect(updated.Annotations).NotTo(HaveKey(atlasv1.ApprovedMigrationAnnotation))
+		Expect(deploymentExists()).To(BeTrue())
+	})
+
+	It("rejects an approval for another version", func() {
+		annotate(atlasApp, map[string]string{
+			atlasv1.ApprovedByAnnotation:        "jane.doe",
+			atlasv1.ApprovedVersionAnnotation:   "1.21.0",
+			atlasv1.ApprovedMigrationAnnotation: "6",
+		})
+
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhasePendingApproval))
+		Expect(updated.Status.Approval).To(BeNil())
+		Expect(updated.Annotations).NotTo(HaveKey(atlasv1.ApprovedByAnnotation))
+		Expect(deploymentExists()).To(BeFalse())
+	})
+
+	It("requires a new approval after a spec change", func() {
+		annotate(atlasApp, map[string]string{
+			atlasv1.ApprovedByAnnotation:        "jane.doe",
+			atlasv1.ApprovedVersionAnnotation:   "1.22.0",
+			atlasv1.ApprovedMigrationAnnotation: "6",
+		})
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Approval).NotTo(BeNil())
+
+		updated.Spec.Replicas = 3
+		Expect(k8sClient.Update(ctx, updated)).To(Succeed())
+
+		_, updated = reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhasePendingApproval))
+		Expect(updated.Status.Approval).To(BeNil())
+	})
+})
@@ -18,35 +18,79 @@ package controller
 
 import (
 	"context"
+	goerrors "errors"
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	atlasv1 "atlas-controller/api/v1"
 )
 
+const (
+	// defaultImageRepository is used when AtlasAppSpec.Image is not set
+	defaultImageRepository = "nginx"
+
+	// appNameLabel carries the owning AtlasApp name on managed objects and pods
+	appNameLabel = "atlas.io/app"
+
+	// legacyResourceName is the fixed Deployment and Service name used by older controller versions
+	legacyResourceName = "atlas"
+)
+
 // AtlasAppReconciler reconciles a AtlasApp object
 type AtlasAppReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// Recorder emits events on the AtlasApp, e.g. when drift is corrected
+	Recorder record.EventRecorder
+
+	// HTTPClient is used for application health checks (defaults to http.DefaultClient)
+	HTTPClient *http.Client
+
+	// ControllerNamespace is the namespace the controller runs in. Its pods are
+	// admitted to the application pods by spec.networkPolicy, so the health
+	// checks get through; if unset they are only matched in the app's namespace.
+	ControllerNamespace string
 }
 
 //+kubebuilder:rbac:groups=atlas.io,resources=atlasapps,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=atlas.io,resources=atlasapps/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=atlas.io,resources=atlasapps/finalizers,verbs=update
+//+kubebuilder:rbac:groups=atlas.io,resources=atlaspromotions,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups=atlas.io,resources=atlaspipelines,verbs=get;list;watch
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -64,365 +108,596 @@ func (r *AtlasAppReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{}, err
 	}
 
+	if !atlasApp.DeletionTimestamp.IsZero() {
+		return r.reconcileDelete(ctx, &atlasApp)
+	}
+	if err := r.ensureFinalizer(ctx, &atlasApp); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	log.Info("Reconciling AtlasApp", "environment", atlasApp.Spec.Environment, "version", atlasApp.Spec.Version)
 
-	// 2. Check if approval is required for prod deployments
-	if atlasApp.Spec.Environment == "prod" && atlasApp.Spec.RequireApproval && !atlasApp.Status.ApprovalRequired {
-		return r.handleApprovalRequired(ctx, &atlasApp)
+	// 2. Refuse lowering the version or migration without an override
+	if err := r.checkDowngrade(ctx, &atlasApp); err != nil {
+		return r.handleDowngradeRejected(ctx, &atlasApp, err)
+	}
+
+	// 3. Block the rollout until the current spec has been approved
+	if atlasApp.Spec.RequireApproval {
+		approved, err := r.checkApproval(ctx, &atlasApp)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !approved {
+			return r.handleApprovalRequired(ctx, &atlasApp)
+		}
+	} else {
+		atlasApp.Status.Approval = nil
+	}
+	atlasApp.Status.ApprovalRequired = false
+
+	// Keep a rolled back release from being rolled out again until the spec changes
+	if rolledBack(&atlasApp) {
+		return r.reconcileRollback(ctx, &atlasApp, true)
+	}
+	atlasApp.Status.Rollback = nil
+	startRevision(&atlasApp)
+
+	// 4. Migrate the database before rolling out, a failed migration blocks rollout and promotion.
+	// The network policies come first, they open the egress the migration Job needs.
+	if err := r.reconcileNetworkPolicy(ctx, &atlasApp, true); err != nil {
+		return r.updateStatus(ctx, &atlasApp, atlasv1.PhaseFailed, false, err.Error())
+	}
+	if result, migrated, err := r.reconcileMigration(ctx, &atlasApp); err != nil || !migrated {
+		return result, err
 	}
 
-	// 3. Create or update the deployment
-	if err := r.reconcileDeployment(ctx, &atlasApp); err != nil {
-		return r.updateStatus(ctx, &atlasApp, "Failed", false, err.Error())
+	// 5. Create or update the deployment, stepping through a canary or blue/green rollout if configured
+	atlasApp.Status.DeployedVersion = atlasApp.Spec.Version
+	atlasApp.Status.DeployedMigrationId = atlasApp.Spec.MigrationId
+	if atlasApp.Spec.Strategy != nil && atlasApp.Spec.Strategy.Canary != nil && atlasApp.Spec.Strategy.BlueGreen != nil {
+		return r.updateStatus(ctx, &atlasApp, atlasv1.PhaseFailed, false, "spec.strategy.canary and spec.strategy.blueGreen are mutually exclusive")
+	}
+	if blueGreenEnabled(&atlasApp) {
+		if result, done, err := r.reconcileBlueGreen(ctx, &atlasApp); err != nil || !done {
+			return result, err
+		}
+	} else {
+		if atlasApp.Spec.Strategy != nil && atlasApp.Spec.Strategy.Canary != nil {
+			if result, done, err := r.reconcileCanary(ctx, &atlasApp); err != nil || !done {
+				return result, err
+			}
+		}
+		if err := r.reconcileDeployment(ctx, &atlasApp); err != nil {
+			return r.updateStatus(ctx, &atlasApp, atlasv1.PhaseFailed, false, err.Error())
+		}
 	}
 
-	// 4. Create or update the service
-	if err := r.reconcileService(ctx, &atlasApp); err != nil {
-		return r.updateStatus(ctx, &atlasApp, "Failed", false, err.Error())
+	// 6. Create or update the service, the HPA, the PDB and the exposure
+	if err := r.reconcileService(ctx, &atlasApp, true); err != nil {
+		return r.updateStatus(ctx, &atlasApp, atlasv1.PhaseFailed, false, err.Error())
+	}
+	if err := r.reconcileAutoscaler(ctx, &atlasApp, true); err != nil {
+		return r.updateStatus(ctx, &atlasApp, atlasv1.PhaseFailed, false, err.Error())
+	}
+	if err := r.reconcileDisruptionBudget(ctx, &atlasApp, true); err != nil {
+		return r.updateStatus(ctx, &atlasApp, atlasv1.PhaseFailed, false, err.Error())
+	}
+	if err := r.reconcileExposure(ctx, &atlasApp, true); err != nil {
+		return r.updateStatus(ctx, &atlasApp, atlasv1.PhaseFailed, false, err.Error())
 	}
 
-	// 5. Check deployment status
-	ready, err := r.checkDeploymentStatus(ctx, &atlasApp)
+	// 7. Check deployment status
+	ready, stalled, err := r.checkDeploymentStatus(ctx, &atlasApp)
 	if err != nil {
-		return r.updateStatus(ctx, &atlasApp, "Failed", false, err.Error())
+		return r.updateStatus(ctx, &atlasApp, atlasv1.PhaseFailed, false, err.Error())
 	}
 
 	if !ready {
-		return r.updateStatus(ctx, &atlasApp, "Deploying", false, "Waiting for deployment to be ready")
+		if stalled && canRollback(&atlasApp) {
+			return r.rollback(ctx, &atlasApp, "Deployment exceeded its progress deadline")
+		}
+		return r.updateStatus(ctx, &atlasApp, atlasv1.PhaseDeploying, false, "Waiting for deployment to be ready")
+	}
+
+	if err := r.cleanupLegacyResources(ctx, &atlasApp); err != nil {
+		return r.updateStatus(ctx, &atlasApp, atlasv1.PhaseFailed, false, err.Error())
+	}
+	if err := r.cleanupCanary(ctx, &atlasApp); err != nil {
+		return r.updateStatus(ctx, &atlasApp, atlasv1.PhaseFailed, false, err.Error())
+	}
+	if err := r.cleanupBlueGreen(ctx, &atlasApp); err != nil {
+		return r.updateStatus(ctx, &atlasApp, atlasv1.PhaseFailed, false, err.Error())
 	}
 
-	// 6. Perform health check
+	// 8. Perform health check
 	if atlasApp.Spec.HealthCheckPath != "" {
-		healthy, err := r.performHealthCheck(ctx, &atlasApp)
+		healthy, err := r.performHealthCheck(ctx, &atlasApp, "")
 		if err != nil {
-			return r.updateStatus(ctx, &atlasApp, "Failed", false, fmt.Sprintf("Health check failed: %v", err))
+			return r.updateStatus(ctx, &atlasApp, atlasv1.PhaseFailed, false, fmt.Sprintf("Health check failed: %v", err))
 		}
 		if !healthy {
-			return r.updateStatus(ctx, &atlasApp, "Unhealthy", false, "Health check failed")
+			if healthCheckFailed(&atlasApp) {
+				if canRollback(&atlasApp) {
+					return r.rollback(ctx, &atlasApp, "Health check failed")
+				}
+				return r.updateStatus(ctx, &atlasApp, atlasv1.PhaseUnhealthy, false, "Health check failed")
+			}
+			if result, err := r.updateStatus(ctx, &atlasApp, atlasv1.PhaseVerifying, false, "Waiting for health checks to pass"); err != nil {
+				return result, err
+			}
+			return ctrl.Result{RequeueAfter: healthCheckInterval(&atlasApp)}, nil
 		}
 	}
 
-	// 7. Update status to Ready
-	if result, err := r.updateStatus(ctx, &atlasApp, "Ready", true, "Application is healthy and ready"); err != nil {
+	// 9. Update status to Ready
+	recordKnownGood(&atlasApp)
+	if result, err := r.updateStatus(ctx, &atlasApp, atlasv1.PhaseReady, true, "Application is healthy and ready"); err != nil {
 		return result, err
 	}
 
-	// 8. Handle auto-promotion
-	if atlasApp.Spec.AutoPromote && atlasApp.Spec.NextEnvironment != "" {
-		return r.handleAutoPromotion(ctx, &atlasApp)
+	// 10. Handle auto-promotion
+	if atlasApp.Spec.AutoPromote {
+		if result, err := r.handleAutoPromotion(ctx, &atlasApp); err != nil || !result.IsZero() {
+			return result, err
+		}
+	}
+
+	// Keep probing healthy apps so regressions are noticed
+	requeueAfter := time.Minute * 5
+	if atlasApp.Spec.HealthCheckPath != "" {
+		requeueAfter = healthCheckInterval(&atlasApp)
+	}
+
+	// Come back in time to scale down the previous blue/green color
+	if bg := atlasApp.Status.BlueGreen; bg != nil && bg.ScaleDownAt != nil {
+		if remaining := time.Until(bg.ScaleDownAt.Time); remaining < requeueAfter {
+			requeueAfter = remaining
+		}
 	}
 
-	return ctrl.Result{RequeueAfter: time.Minute * 5}, nil
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// release identifies what a Deployment of an AtlasApp runs
+type release struct {
+	version     string
+	image       string
+	migrationId int
+}
+
+// specRelease returns the release the AtlasApp spec asks for
+func specRelease(atlasApp *atlasv1.AtlasApp) release {
+	return release{
+		version:     atlasApp.Spec.Version,
+		image:       imageReference(&atlasApp.Spec),
+		migrationId: atlasApp.Spec.MigrationId,
+	}
+}
+
+// deploymentRelease returns the release a Deployment built by desiredDeployment runs
+func deploymentRelease(deployment *appsv1.Deployment) (release, bool) {
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		if container.Name != "atlas" {
+			continue
+		}
+		rel := release{
+			version: deployment.Spec.Template.Labels["atlas.io/version"],
+			image:   container.Image,
+		}
+		for _, env := range container.Env {
+			if env.Name == "MIGRATION_ID" {
+				rel.migrationId, _ = strconv.Atoi(env.Value)
+			}
+		}
+		return rel, true
+	}
+	return release{}, false
 }
 
 // reconcileDeployment creates or updates the deployment
 func (r *AtlasAppReconciler) reconcileDeployment(ctx context.Context, atlasApp *atlasv1.AtlasApp) error {
-	log := log.FromContext(ctx)
+	deployment := desiredDeployment(atlasApp, atlasApp.Name, specRelease(atlasApp), activeReplicas(atlasApp), nil)
+	return r.applyDeployment(ctx, atlasApp, deployment, true)
+}
 
-	deployment := &appsv1.Deployment{
+// desiredDeployment returns a Deployment of the AtlasApp running rel. The
+// extra labels are added to the selector and pods, to tell several Deployments
+// of the same AtlasApp apart. Nil replicas leave the count to the HPA.
+func desiredDeployment(atlasApp *atlasv1.AtlasApp, name string, rel release, replicas *int32, extraLabels map[string]string) *appsv1.Deployment {
+	selector := selectorLabels(atlasApp)
+	podLabels := map[string]string{
+		"app":                  "atlas",
+		appNameLabel:           atlasApp.Name,
+		"atlas.io/environment": atlasApp.Spec.Environment,
+		"atlas.io/version":     rel.version,
+	}
+	for k, v := range extraLabels {
+		selector[k] = v
+		podLabels[k] = v
+	}
+
+	return &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "atlas",
+			Name:      name,
 			Namespace: atlasApp.Namespace,
 			Labels: map[string]string{
-				"app":                          "atlas",
-				"atlas.io/environment":         atlasApp.Spec.Environment,
-				"atlas.io/version":             atlasApp.Spec.Version,
-				"atlas.io/managed-by":          "atlas-controller",
+				"app":                  "atlas",
+				appNameLabel:           atlasApp.Name,
+				"atlas.io/environment": atlasApp.Spec.Environment,
+				"atlas.io/version":     rel.version,
+				"atlas.io/managed-by":  "atlas-controller",
 			},
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: &atlasApp.Spec.Replicas,
+			Replicas: replicas,
 			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"app": "atlas",
-				},
+				MatchLabels: selector,
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app":                  "atlas",
-						"atlas.io/environment": atlasApp.Spec.Environment,
-						"atlas.io/version":     atlasApp.Spec.Version,
-					},
+					Labels: podLabels,
 				},
 				Spec: corev1.PodSpec{
+					ImagePullSecrets: atlasApp.Spec.ImagePullSecrets,
+					Volumes:          atlasApp.Spec.Volumes,
+					Affinity:         atlasApp.Spec.Affinity,
+
+					TopologySpreadConstraints: topologySpreadConstraints(atlasApp),
 					Containers: []corev1.Container{
 						{
-							Name:  "atlas",
-							Image: fmt.Sprintf("nginx:%s", atlasApp.Spec.Version),
-							Ports: []corev1.ContainerPort{
-								{
-									ContainerPort: 80,
-									Protocol:      corev1.ProtocolTCP,
-								},
-							},
-							Env: []corev1.EnvVar{
-								{
-									Name:  "MIGRATION_ID",
-									Value: fmt.Sprintf("%d", atlasApp.Spec.MigrationId),
-								},
-								{
-									Name:  "ENVIRONMENT",
-									Value: atlasApp.Spec.Environment,
-								},
-							},
-							LivenessProbe: &corev1.Probe{
-								ProbeHandler: corev1.ProbeHandler{
-									HTTPGet: &corev1.HTTPGetAction{
-										Path: "/",
-										Port: intstr.FromInt(80),
-									},
-								},
-								InitialDelaySeconds: 30,
-								PeriodSeconds:       10,
-							},
-							ReadinessProbe: &corev1.Probe{
-								ProbeHandler: corev1.ProbeHandler{
-									HTTPGet: &corev1.HTTPGetAction{
-										Path: "/",
-										Port: intstr.FromInt(80),
-									},
-								},
-								InitialDelaySeconds: 5,
-								PeriodSeconds:       5,
-							},
+							Name:           "atlas",
+							Image:          rel.image,
+							Ports:          containerPorts(atlasApp),
+							Env:            containerEnv(atlasApp, rel),
+							EnvFrom:        atlasApp.Spec.EnvFrom,
+							Resources:      atlasApp.Spec.Resources,
+							VolumeMounts:   atlasApp.Spec.VolumeMounts,
+							LivenessProbe:  containerProbe(atlasApp, atlasApp.Spec.LivenessProbe, livenessProbeDefaults, true),
+							ReadinessProbe: containerProbe(atlasApp, atlasApp.Spec.ReadinessProbe, readinessProbeDefaults, true),
+							StartupProbe:   containerProbe(atlasApp, atlasApp.Spec.StartupProbe, startupProbeDefaults, false),
 						},
 					},
 				},
 			},
 		},
 	}
+}
 
-	// Set AtlasApp as the owner of the Deployment
-	if err := ctrl.SetControllerReference(atlasApp, deployment, r.Scheme); err != nil {
-		return err
+// containerEnv returns the environment of the application container, the
+// variables set by the controller take precedence over the spec
+func containerEnv(atlasApp *atlasv1.AtlasApp, rel release) []corev1.EnvVar {
+	env := []corev1.EnvVar{
+		{
+			Name:  "MIGRATION_ID",
+			Value: fmt.Sprintf("%d", rel.migrationId),
+		},
+		{
+			Name:  "ENVIRONMENT",
+			Value: atlasApp.Spec.Environment,
+		},
 	}
+	for _, v := range atlasApp.Spec.Env {
+		if v.Name != "MIGRATION_ID" && v.Name != "ENVIRONMENT" {
+			env = append(env, v)
+		}
+	}
+	return env
+}
 
-	// Check if deployment already exists
+// applyDeployment applies a Deployment of the AtlasApp. Changes are recorded as
+// drift only if detectDrift is set. A Deployment without replicas starts with
+// the minimum of the HPA, afterwards the replicas are left to the HPA.
+func (r *AtlasAppReconciler) applyDeployment(ctx context.Context, atlasApp *atlasv1.AtlasApp, deployment *appsv1.Deployment, detectDrift bool) error {
+	log := log.FromContext(ctx)
+
+	// The selector is immutable, so Deployments created before selectors were
+	// derived from the AtlasApp name have to be recreated
 	found := &appsv1.Deployment{}
 	err := r.Get(ctx, types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, found)
-	if err != nil && errors.IsNotFound(err) {
-		log.Info("Creating a new Deployment", "Deployment.Namespace", deployment.Namespace, "Deployment.Name", deployment.Name)
-		err = r.Create(ctx, deployment)
-		if err != nil {
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	if err == nil && metav1.IsControlledBy(found, atlasApp) && !equality.Semantic.DeepEqual(found.Spec.Selector, deployment.Spec.Selector) {
+		log.Info("Recreating Deployment with legacy selector", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
+		if deployment.Spec.Replicas == nil {
+			deployment.Spec.Replicas = found.Spec.Replicas
+		}
+		if err := r.Delete(ctx, found, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
 			return err
 		}
-		return nil
-	} else if err != nil {
-		return err
+		if err := ctrl.SetControllerReference(atlasApp, deployment, r.Scheme); err != nil {
+			return err
+		}
+		deployment.SetGroupVersionKind(appsv1.SchemeGroupVersion.WithKind("Deployment"))
+		return r.Patch(ctx, deployment, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
 	}
 
-	// Update existing deployment if needed
-	if found.Spec.Template.Spec.Containers[0].Image != deployment.Spec.Template.Spec.Containers[0].Image ||
-		found.Spec.Template.Spec.Containers[0].Env[0].Value != deployment.Spec.Template.Spec.Containers[0].Env[0].Value {
-		log.Info("Updating Deployment", "Deployment.Namespace", deployment.Namespace, "Deployment.Name", deployment.Name)
-		found.Spec = deployment.Spec
-		err = r.Update(ctx, found)
+	// The HPA owns the replica count. A new Deployment starts with its minimum,
+	// an existing one is handed over before the field is left out of the apply.
+	if deployment.Spec.Replicas == nil {
 		if err != nil {
+			replicas := rolloutReplicas(atlasApp)
+			deployment.Spec.Replicas = &replicas
+		} else if err := r.handOverReplicas(ctx, found); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return r.applyOwned(ctx, atlasApp, deployment, &appsv1.Deployment{}, detectDrift)
 }
 
-// reconcileService creates or updates the service
-func (r *AtlasAppReconciler) reconcileService(ctx context.Context, atlasApp *atlasv1.AtlasApp) error {
-	log := log.FromContext(ctx)
-
+// reconcileService creates or updates the service in place, so its cluster IP
+// and allocated node ports are kept. Changes are recorded as drift only if
+// detectDrift is set.
+func (r *AtlasAppReconciler) reconcileService(ctx context.Context, atlasApp *atlasv1.AtlasApp, detectDrift bool) error {
 	service := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "atlas",
-			Namespace: atlasApp.Namespace,
+			Name:        atlasApp.Name,
+			Namespace:   atlasApp.Namespace,
+			Annotations: serviceAnnotations(atlasApp),
 			Labels: map[string]string{
 				"app":                  "atlas",
+				appNameLabel:           atlasApp.Name,
 				"atlas.io/environment": atlasApp.Spec.Environment,
 				"atlas.io/managed-by":  "atlas-controller",
 			},
 		},
-		Spec: corev1.ServiceSpec{
-			Selector: map[string]string{
-				"app": "atlas",
-			},
-			Ports: []corev1.ServicePort{
-				{
-					Port:       80,
-					TargetPort: intstr.FromInt(80),
-					Protocol:   corev1.ProtocolTCP,
-				},
-			},
-		},
+		Spec: desiredServiceSpec(atlasApp),
 	}
 
-	// Set AtlasApp as the owner of the Service
-	if err := ctrl.SetControllerReference(atlasApp, service, r.Scheme); err != nil {
-		return err
+	return r.applyOwned(ctx, atlasApp, service, &corev1.Service{}, detectDrift)
+}
+
+// cleanupLegacyResources removes the Deployment and Service that older controller
+// versions created under the fixed name "atlas". It only runs once the renamed
+// Deployment is ready, and only touches objects controlled by this AtlasApp.
+func (r *AtlasAppReconciler) cleanupLegacyResources(ctx context.Context, atlasApp *atlasv1.AtlasApp) error {
+	log := log.FromContext(ctx)
+
+	if atlasApp.Name == legacyResourceName {
+		return nil
 	}
 
-	// Check if service already exists
-	found := &corev1.Service{}
-	err := r.Get(ctx, types.NamespacedName{Name: service.Name, Namespace: service.Namespace}, found)
-	if err != nil && errors.IsNotFound(err) {
-		log.Info("Creating a new Service", "Service.Namespace", service.Namespace, "Service.Name", service.Name)
-		err = r.Create(ctx, service)
-		return err
-	} else if err != nil {
-		return err
+	key := types.NamespacedName{Name: legacyResourceName, Namespace: atlasApp.Namespace}
+	for _, obj := range []client.Object{&appsv1.Deployment{}, &corev1.Service{}} {
+		if err := r.Get(ctx, key, obj); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if !metav1.IsControlledBy(obj, atlasApp) {
+			continue
+		}
+
+		log.Info("Deleting legacy resource", "kind", fmt.Sprintf("%T", obj), "namespace", key.Namespace, "name", key.Name)
+		if err := r.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// checkDeploymentStatus checks if the deployment is ready
-func (r *AtlasAppReconciler) checkDeploymentStatus(ctx context.Context, atlasApp *atlasv1.AtlasApp) (bool, error) {
+// checkDeploymentStatus checks if the deployment is ready, and if it stopped
+// making progress
+func (r *AtlasAppReconciler) checkDeploymentStatus(ctx context.Context, atlasApp *atlasv1.AtlasApp) (bool, bool, error) {
 	deployment := &appsv1.Deployment{}
-	err := r.Get(ctx, types.NamespacedName{Name: "atlas", Namespace: atlasApp.Namespace}, deployment)
+	err := r.Get(ctx, types.NamespacedName{Name: activeDeploymentName(atlasApp), Namespace: atlasApp.Namespace}, deployment)
 	if err != nil {
-		return false, err
+		return false, false, err
 	}
 
 	// Update replica counts in status
 	atlasApp.Status.ReadyReplicas = deployment.Status.ReadyReplicas
 	atlasApp.Status.TotalReplicas = deployment.Status.Replicas
 
-	// Check if all replicas are ready
-	return deployment.Status.ReadyReplicas == *deployment.Spec.Replicas && deployment.Status.ReadyReplicas > 0, nil
+	// Check if all replicas run the current template and are ready. Replicas of
+	// the previous template being ready does not make the new version ready.
+	ready := deploymentRolledOut(deployment) && deployment.Status.ReadyReplicas > 0
+	return ready, !ready && progressDeadlineExceeded(deployment), nil
 }
 
-// performHealthCheck performs application health check
-func (r *AtlasAppReconciler) performHealthCheck(ctx context.Context, atlasApp *atlasv1.AtlasApp) (bool, error) {
-	// TODO: Implement actual HTTP health check to the service
-	// For now, we'll just simulate it
-	log := log.FromContext(ctx)
-	log.Info("Performing health check", "path", atlasApp.Spec.HealthCheckPath)
-	
-	// Simulate health check success
-	return true, nil
+// updateStatus updates the AtlasApp phase, conditions and observed generation
+func (r *AtlasAppReconciler) updateStatus(ctx context.Context, atlasApp *atlasv1.AtlasApp, phase string, ready bool, message string) (ctrl.Result, error) {
+	atlasApp.Status.ObservedGeneration = atlasApp.Generation
+	atlasApp.Status.Phase = phase
+	atlasApp.Status.Ready = ready
+	atlasApp.Status.Message = message
+	now := metav1.Now()
+	atlasApp.Status.LastUpdate = &now
+	setPhaseConditions(atlasApp, phase, message)
+	setRevisionOutcome(atlasApp, phase)
+
+	if err := r.writeStatus(ctx, atlasApp); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if !ready {
+		return ctrl.Result{RequeueAfter: time.Second * 30}, nil
+	}
+
+	return ctrl.Result{}, nil
 }
 
-// updateStatus updates the AtlasApp status with retry logic
-func (r *AtlasAppReconciler) updateStatus(ctx context.Context, atlasApp *atlasv1.AtlasApp, phase string, ready bool, message string) (ctrl.Result, error) {
+// writeStatus persists the in-memory status of the AtlasApp with retry logic.
+// The controller is the only writer of AtlasApp status, so the latest object
+// simply takes over the status computed during this reconcile.
+func (r *AtlasAppReconciler) writeStatus(ctx context.Context, atlasApp *atlasv1.AtlasApp) error {
 	// Use retry logic to handle conflicts
-	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		// Fetch the latest version
 		latest := &atlasv1.AtlasApp{}
 		if err := r.Get(ctx, client.ObjectKeyFromObject(atlasApp), latest); err != nil {
 			return err
 		}
-		
-		// Update status fields
-		latest.Status.Phase = phase
-		latest.Status.Ready = ready
-		latest.Status.Message = message
-		now := metav1.Now()
-		latest.Status.LastUpdate = &now
-		
-		// Update the status
-		return r.Status().Update(ctx, latest)
-	})
-	
-	if retryErr != nil {
-		return ctrl.Result{}, retryErr
-	}
 
-	if !ready {
-		return ctrl.Result{RequeueAfter: time.Second * 30}, nil
-	}
+		atlasApp.Status.DeepCopyInto(&latest.Status)
+		if err := r.Status().Update(ctx, latest); err != nil {
+			return err
+		}
 
-	return ctrl.Result{}, nil
+		// Keep the caller's copy current so later writes don't conflict
+		atlasApp.ResourceVersion = latest.ResourceVersion
+		return nil
+	})
 }
 
-// handleApprovalRequired sets the approval required status
+// handleApprovalRequired blocks the rollout and reports that approval is needed
 func (r *AtlasAppReconciler) handleApprovalRequired(ctx context.Context, atlasApp *atlasv1.AtlasApp) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
-	log.Info("Production deployment requires approval", "app", atlasApp.Name)
+	log.Info("Deployment requires approval", "app", atlasApp.Name, "version", atlasApp.Spec.Version, "migrationId", atlasApp.Spec.MigrationId)
 
 	atlasApp.Status.ApprovalRequired = true
-	atlasApp.Status.Phase = "PendingApproval"
-	atlasApp.Status.Message = "Production deployment requires manual approval"
-
-	if err := r.Status().Update(ctx, atlasApp); err != nil {
+	if _, err := r.updateStatus(ctx, atlasApp, atlasv1.PhasePendingApproval, false, approvalMessage(atlasApp)); err != nil {
 		return ctrl.Result{}, err
 	}
 
-	return ctrl.Result{RequeueAfter: time.Minute * 5}, nil
+	// Approval annotations trigger a reconcile, no need to poll
+	return ctrl.Result{}, nil
 }
 
-// handleAutoPromotion handles automatic promotion to next environment
+// handleAutoPromotion handles automatic promotion to the next stages of the pipeline
 func (r *AtlasAppReconciler) handleAutoPromotion(ctx context.Context, atlasApp *atlasv1.AtlasApp) (ctrl.Result, error) {
-	log := log.FromContext(ctx)
-	
-	// Check if promotion to prod requires approval
-	if atlasApp.Spec.NextEnvironment == "prod" {
-		log.Info("Promotion to prod requires approval", "current", atlasApp.Spec.Environment)
-		atlasApp.Status.PromotionPending = true
-		atlasApp.Status.Message = "Promotion to production requires manual approval"
-		if err := r.Status().Update(ctx, atlasApp); err != nil {
-			return ctrl.Result{}, err
+	pipeline, err := atlasv1.GetPipeline(ctx, r.Client, atlasApp.Spec.Pipeline)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return r.promotionBlocked(ctx, atlasApp, reasonPipelineInvalid, fmt.Sprintf("AtlasPipeline %s not found", atlasApp.Spec.Pipeline))
 		}
-		return ctrl.Result{}, nil
+		return ctrl.Result{}, err
 	}
 
-	// Create AtlasApp in next environment
-	nextApp := &atlasv1.AtlasApp{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("atlas-%s", atlasApp.Spec.NextEnvironment),
-			Namespace: atlasApp.Spec.NextEnvironment,
-		},
-		Spec: atlasv1.AtlasAppSpec{
-			Environment:     atlasApp.Spec.NextEnvironment,
-			Version:         atlasApp.Spec.Version,
-			MigrationId:     atlasApp.Spec.MigrationId,
-			Replicas:        atlasApp.Spec.Replicas,
-			AutoPromote:     atlasApp.Spec.Environment != "stage", // Only auto-promote from dev to stage
-			NextEnvironment: getNextEnvironment(atlasApp.Spec.NextEnvironment),
-			RequireApproval: atlasApp.Spec.NextEnvironment == "prod",
-			HealthCheckPath: atlasApp.Spec.HealthCheckPath,
-		},
+	stages, err := nextStages(atlasApp, pipeline)
+	if err != nil {
+		return r.promotionBlocked(ctx, atlasApp, reasonPipelineInvalid, err.Error())
+	}
+	if len(stages) == 0 {
+		// Last stage of the pipeline, nothing to promote to
+		return ctrl.Result{}, nil
 	}
 
-	// Check if the next environment AtlasApp already exists
-	existingApp := &atlasv1.AtlasApp{}
-	err := r.Get(ctx, types.NamespacedName{Name: nextApp.Name, Namespace: nextApp.Namespace}, existingApp)
-	if err != nil && errors.IsNotFound(err) {
-		log.Info("Creating AtlasApp in next environment", "environment", nextApp.Spec.Environment, "version", nextApp.Spec.Version)
-		if err := r.Create(ctx, nextApp); err != nil {
+	// Every next stage is promoted on its own, an approval pending for one
+	// doesn't hold back the others
+	var promotions []stagePromotion
+	for _, stage := range stages {
+		promotion, err := r.promoteToStage(ctx, atlasApp, pipeline, stage)
+		if err != nil {
 			return ctrl.Result{}, err
 		}
-	} else if err != nil {
+		promotions = append(promotions, promotion)
+	}
+
+	setPromotedCondition(atlasApp, promotions)
+	if err := r.writeStatus(ctx, atlasApp); err != nil {
 		return ctrl.Result{}, err
-	} else {
-		// Update existing app if version or migration changed
-		if existingApp.Spec.Version != nextApp.Spec.Version || existingApp.Spec.MigrationId != nextApp.Spec.MigrationId {
-			log.Info("Updating AtlasApp in next environment", "environment", nextApp.Spec.Environment, "version", nextApp.Spec.Version)
-			existingApp.Spec.Version = nextApp.Spec.Version
-			existingApp.Spec.MigrationId = nextApp.Spec.MigrationId
-			if err := r.Update(ctx, existingApp); err != nil {
-				return ctrl.Result{}, err
-			}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// promoteToStage promotes the AtlasApp to one of its next stages
+func (r *AtlasAppReconciler) promoteToStage(ctx context.Context, atlasApp *atlasv1.AtlasApp, pipeline *atlasv1.AtlasPipelineSpec, stage *atlasv1.PipelineStage) (stagePromotion, error) {
+	target := types.NamespacedName{
+		Name:      promotedAppName(atlasApp.Name, atlasApp.Spec.Environment, stage.Name),
+		Namespace: stage.StageNamespace(),
+	}
+
+	// Never push an older version or migration than the target already runs
+	if err := checkPromotionOrder(ctx, r.Client, target, atlasApp.Spec.Version, atlasApp.Spec.MigrationId); err != nil {
+		var downgrade *atlasv1.DowngradeError
+		if goerrors.As(err, &downgrade) {
+			message := fmt.Sprintf("Not promoting to %s/%s: %s", target.Namespace, target.Name, err.Error())
+			log.FromContext(ctx).Info("Skipping promotion", "reason", message)
+			return stagePromotion{status: metav1.ConditionFalse, reason: reasonDowngradeRejected, message: message}, nil
 		}
+		return stagePromotion{}, err
+	}
+
+	// Promotions into stages requiring approval go through an AtlasPromotion
+	if stage.RequireApproval {
+		return r.requestPromotion(ctx, atlasApp, stage.Name, target)
 	}
 
+	if _, err := promoteToApp(ctx, r.Client, target, promotedAppSpec(atlasApp, pipeline, stage), atlasApp); err != nil {
+		return stagePromotion{}, err
+	}
+	return stagePromotion{status: metav1.ConditionTrue, reason: reasonPromoted,
+		message: fmt.Sprintf("Version %s promoted to %s", atlasApp.Spec.Version, stage.Name)}, nil
+}
+
+// promotionBlocked reports that the AtlasApp cannot be promoted, e.g. because of its
+// pipeline. Pipeline and target changes trigger a reconcile, no need to poll.
+func (r *AtlasAppReconciler) promotionBlocked(ctx context.Context, atlasApp *atlasv1.AtlasApp, reason, message string) (ctrl.Result, error) {
+	log.FromContext(ctx).Info("Skipping promotion", "reason", message)
+	atlasApp.Status.PromotionPending = false
+	setCondition(atlasApp, atlasv1.ConditionPromoted, metav1.ConditionFalse, reason, message)
+	if err := r.writeStatus(ctx, atlasApp); err != nil {
+		return ctrl.Result{}, err
+	}
 	return ctrl.Result{}, nil
 }
 
-// getNextEnvironment returns the next environment in the promotion chain
-func getNextEnvironment(currentEnv string) string {
-	switch currentEnv {
-	case "dev":
-		return "stage"
-	case "stage":
-		return "prod"
-	default:
-		return ""
+// selectorLabels returns the labels selecting the pods of an AtlasApp
+func selectorLabels(atlasApp *atlasv1.AtlasApp) map[string]string {
+	return map[string]string{
+		appNameLabel: atlasApp.Name,
+	}
+}
+
+// promotedAppName returns the AtlasApp name used in the next environment,
+// replacing an environment suffix ("atlas-dev" -> "atlas-stage") or appending one
+func promotedAppName(name, currentEnv, nextEnv string) string {
+	return fmt.Sprintf("%s-%s", strings.TrimSuffix(name, "-"+currentEnv), nextEnv)
+}
+
+// imageReference returns the container image for the spec as repository:version[@digest]
+func imageReference(spec *atlasv1.AtlasAppSpec) string {
+	repository := spec.Image
+	if repository == "" {
+		repository = defaultImageRepository
+	}
+
+	image := fmt.Sprintf("%s:%s", repository, spec.Version)
+	if spec.ImageDigest != "" {
+		image = fmt.Sprintf("%s@%s", image, spec.ImageDigest)
+	}
+	return image
+}
+
+// splitImageReference returns the repository and digest of an image built by
+// imageReference for the given version
+func splitImageReference(image, version string) (string, string, bool) {
+	var digest string
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		image, digest = image[:i], image[i+1:]
 	}
+	repository, ok := strings.CutSuffix(image, ":"+version)
+	return repository, digest, ok
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *AtlasAppReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&atlasv1.AtlasApp{}).
+		// Status writes must not retrigger reconciles, otherwise health checks
+		// would run in a tight loop instead of every IntervalSeconds
+		For(&atlasv1.AtlasApp{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{},
+			predicate.AnnotationChangedPredicate{},
+			predicate.LabelChangedPredicate{},
+			deletionRequested,
+		))).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
+		Owns(&batchv1.Job{}).
+		Owns(&networkingv1.Ingress{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		// HPA and PDB status changes with every metrics sync or pod change, only
+		// spec changes are drift
+		Owns(&autoscalingv2.HorizontalPodAutoscaler{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&policyv1.PodDisruptionBudget{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&atlasv1.AtlasPromotion{}, handler.EnqueueRequestsFromMapFunc(promotionToApps)).
+		Watches(&atlasv1.AtlasPipeline{}, handler.EnqueueRequestsFromMapFunc(r.appsForPipeline)).
 		Complete(r)
 }
@@ -0,0 +1,138 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+// imageDigest is a digest passing the AtlasApp validation
+const imageDigest = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+var _ = Describe("Application image", func() {
+	It("builds the image reference from the repository, version and digest", func() {
+		for _, ref := range []struct{ repository, digest, image string }{
+			{"", "", "nginx:1.0.0"},
+			{"registry.example.com/payments", "", "registry.example.com/payments:1.0.0"},
+			{"registry.example.com:5000/payments", "sha256:abc", "registry.example.com:5000/payments:1.0.0@sha256:abc"},
+		} {
+			spec := &atlasv1.AtlasAppSpec{Version: "1.0.0", Image: ref.repository, ImageDigest: ref.digest}
+			Expect(imageReference(spec)).To(Equal(ref.image))
+		}
+
+		repository, digest, ok := splitImageReference("registry.example.com:5000/payments:1.0.0@sha256:abc", "1.0.0")
+		Expect(ok).To(BeTrue())
+		Expect(repository).To(Equal("registry.example.com:5000/payments"))
+		Expect(digest).To(Equal("sha256:abc"))
+		_, _, ok = splitImageReference("nginx:1.0.0", "1.1.0")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("runs the configured image with its pull secrets", func() {
+		r := newAppReconciler()
+		atlasApp := newApp(newNamespace("image"), "dev", "1.0.0")
+		atlasApp.Spec.HealthCheckPath = ""
+		atlasApp.Spec.Image = "registry.example.com/payments"
+		atlasApp.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		key := client.ObjectKeyFromObject(atlasApp)
+
+		reconcileApp(r, atlasApp)
+		pod := getDeployment(key).Spec.Template.Spec
+		Expect(pod.Containers[0].Image).To(Equal("registry.example.com/payments:1.0.0"))
+		Expect(pod.ImagePullSecrets).To(Equal([]corev1.LocalObjectReference{{Name: "registry"}}))
+
+		// Pinning a digest rolls out the exact image
+		latest := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, key, latest)).To(Succeed())
+		latest.Spec.ImageDigest = imageDigest
+		Expect(k8sClient.Update(ctx, latest)).To(Succeed())
+
+		reconcileApp(r, atlasApp)
+		Expect(getDeployment(key).Spec.Template.Spec.Containers[0].Image).
+			To(Equal("registry.example.com/payments:1.0.0@" + imageDigest))
+	})
+})
+
+var _ = Describe("Several AtlasApps in a namespace", func() {
+	var (
+		r         *AtlasAppReconciler
+		namespace string
+	)
+
+	BeforeEach(func() {
+		r = newAppReconciler()
+		namespace = newNamespace("apps")
+	})
+
+	It("gives every AtlasApp its own Deployment and Service", func() {
+		atlasApp := newApp(namespace, "dev", "1.0.0")
+		atlasApp.Spec.HealthCheckPath = ""
+		billing := newApp(namespace, "dev", "2.0.0")
+		billing.Name = "billing"
+		billing.Spec.HealthCheckPath = ""
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		Expect(k8sClient.Create(ctx, billing)).To(Succeed())
+
+		reconcileApp(r, atlasApp)
+		reconcileApp(r, billing)
+
+		for _, app := range []*atlasv1.AtlasApp{atlasApp, billing} {
+			key := client.ObjectKeyFromObject(app)
+			deployment := getDeployment(key)
+			Expect(deployment.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:" + app.Spec.Version))
+			Expect(deployment.Spec.Selector.MatchLabels).To(Equal(selectorLabels(app)))
+
+			service := &corev1.Service{}
+			Expect(k8sClient.Get(ctx, key, service)).To(Succeed())
+			Expect(service.Spec.Selector).To(Equal(selectorLabels(app)))
+		}
+		Expect(selectorLabels(atlasApp)).NotTo(Equal(selectorLabels(billing)))
+	})
+
+	It("fails instead of taking over a Deployment it does not own", func() {
+		atlasApp := newApp(namespace, "dev", "1.0.0")
+		atlasApp.Spec.HealthCheckPath = ""
+		replicas := int32(1)
+		labels := map[string]string{"app": "legacy"}
+		Expect(k8sClient.Create(ctx, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: atlasApp.Name, Namespace: namespace},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: labels},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "legacy", Image: "legacy:1"}}},
+				},
+			},
+		})).To(Succeed())
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseFailed))
+		Expect(updated.Status.Message).To(ContainSubstring("not owned by AtlasApp atlas"))
+		Expect(getDeployment(client.ObjectKeyFromObject(atlasApp)).Spec.Template.Spec.Containers[0].Image).To(Equal("legacy:1"))
+	})
+})
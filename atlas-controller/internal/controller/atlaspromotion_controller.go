@@ -0,0 +1,271 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+// AtlasPromotionReconciler reconciles a AtlasPromotion object
+type AtlasPromotionReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Recorder emits events on the AtlasPromotion
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=atlas.io,resources=atlaspromotions,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=atlas.io,resources=atlaspromotions/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=atlas.io,resources=atlaspipelines,verbs=get;list;watch
+
+// Reconcile applies approved AtlasPromotions to their target AtlasApp
+func (r *AtlasPromotionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var promotion atlasv1.AtlasPromotion
+	if err := r.Get(ctx, req.NamespacedName, &promotion); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get AtlasPromotion")
+		return ctrl.Result{}, err
+	}
+
+	// Decided promotions are an audit record and are never revisited
+	switch promotion.Status.Phase {
+	case atlasv1.PromotionPhaseApplied, atlasv1.PromotionPhaseRejected, atlasv1.PromotionPhaseSuperseded:
+		return ctrl.Result{}, nil
+	}
+
+	switch promotion.Spec.Decision {
+	case atlasv1.PromotionDecisionRejected:
+		log.Info("Promotion rejected", "decidedBy", promotion.Spec.DecidedBy, "reason", promotion.Spec.Reason)
+		r.Recorder.Eventf(&promotion, corev1.EventTypeNormal, "Rejected", "Promotion rejected by %s", promotion.Spec.DecidedBy)
+		return r.updateStatus(ctx, &promotion, atlasv1.PromotionPhaseRejected,
+			fmt.Sprintf("Rejected by %s: %s", promotion.Spec.DecidedBy, promotion.Spec.Reason))
+
+	case atlasv1.PromotionDecisionApproved:
+		if promotion.Spec.DecidedBy == "" {
+			return r.updateStatus(ctx, &promotion, atlasv1.PromotionPhasePending, "spec.decidedBy must name the approver")
+		}
+
+		generation, err := r.applyPromotion(ctx, &promotion)
+		if err != nil {
+			var invalid *invalidPromotionError
+			var downgrade *atlasv1.DowngradeError
+			if errors.IsNotFound(err) || goerrors.As(err, &invalid) || goerrors.As(err, &downgrade) {
+				return r.updateStatus(ctx, &promotion, atlasv1.PromotionPhaseFailed, err.Error())
+			}
+			return ctrl.Result{}, err
+		}
+
+		log.Info("Promotion applied", "target", promotion.Spec.Target, "version", promotion.Spec.Version)
+		r.Recorder.Eventf(&promotion, corev1.EventTypeNormal, "Applied", "Promotion approved by %s applied to %s/%s",
+			promotion.Spec.DecidedBy, promotion.Spec.Target.Namespace, promotion.Spec.Target.Name)
+		now := metav1.Now()
+		promotion.Status.AppliedAt = &now
+		promotion.Status.TargetGeneration = generation
+		return r.updateStatus(ctx, &promotion, atlasv1.PromotionPhaseApplied,
+			fmt.Sprintf("Approved by %s and applied to %s/%s", promotion.Spec.DecidedBy, promotion.Spec.Target.Namespace, promotion.Spec.Target.Name))
+	}
+
+	superseded, err := r.isSuperseded(ctx, &promotion)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if superseded {
+		return r.updateStatus(ctx, &promotion, atlasv1.PromotionPhaseSuperseded, "A newer promotion from the same source exists")
+	}
+
+	return r.updateStatus(ctx, &promotion, atlasv1.PromotionPhasePending,
+		fmt.Sprintf("Waiting for spec.decision to be set to %s or %s", atlasv1.PromotionDecisionApproved, atlasv1.PromotionDecisionRejected))
+}
+
+// invalidPromotionError reports an AtlasPromotion that does not match its source AtlasApp
+type invalidPromotionError struct {
+	message string
+}
+
+func (e *invalidPromotionError) Error() string {
+	return e.message
+}
+
+// applyPromotion moves the target AtlasApp to the last known-good release of
+// the source, which has to be the version and migration that were approved.
+// The target has to be the AtlasApp the source promotes to, in the namespace of
+// the AtlasPromotion, and is created from the source if it does not exist yet.
+// It returns the generation of the target that was written, or 0 if the target
+// already ran the release. That generation is approved by the promotion, see
+// promotionApproval.
+func (r *AtlasPromotionReconciler) applyPromotion(ctx context.Context, promotion *atlasv1.AtlasPromotion) (int64, error) {
+	if promotion.Spec.Target.Namespace != promotion.Namespace {
+		return 0, &invalidPromotionError{message: fmt.Sprintf("target namespace %s is not the namespace of the AtlasPromotion", promotion.Spec.Target.Namespace)}
+	}
+
+	sourceKey := types.NamespacedName{Name: promotion.Spec.Source.Name, Namespace: promotion.Spec.Source.Namespace}
+	source := &atlasv1.AtlasApp{}
+	if err := r.Get(ctx, sourceKey, source); err != nil {
+		return 0, err
+	}
+	pipeline, err := atlasv1.GetPipeline(ctx, r.Client, source.Spec.Pipeline)
+	if err != nil {
+		return 0, err
+	}
+	stages, err := nextStages(source, pipeline)
+	if err != nil {
+		return 0, &invalidPromotionError{message: err.Error()}
+	}
+	var stage *atlasv1.PipelineStage
+	for _, next := range stages {
+		if next.Name == promotion.Spec.TargetEnvironment {
+			stage = next
+		}
+	}
+	if stage == nil {
+		return 0, &invalidPromotionError{message: fmt.Sprintf("%s is not a next environment of AtlasApp %s", promotion.Spec.TargetEnvironment, sourceKey)}
+	}
+
+	targetKey := types.NamespacedName{
+		Name:      promotedAppName(source.Name, source.Spec.Environment, stage.Name),
+		Namespace: stage.StageNamespace(),
+	}
+	if promotion.Spec.Target.Name != targetKey.Name || promotion.Spec.Target.Namespace != targetKey.Namespace {
+		return 0, &invalidPromotionError{message: fmt.Sprintf("AtlasApp %s promotes to %s, not to %s/%s",
+			sourceKey, targetKey, promotion.Spec.Target.Namespace, promotion.Spec.Target.Name)}
+	}
+
+	// The promoted release is taken from the source, the spec only selects it
+	known := source.Status.LastKnownGood
+	if known == nil || known.Version != promotion.Spec.Version || known.MigrationId != promotion.Spec.MigrationId {
+		return 0, &invalidPromotionError{message: fmt.Sprintf("version %s with migration %d is not the last known-good release of AtlasApp %s",
+			promotion.Spec.Version, promotion.Spec.MigrationId, sourceKey)}
+	}
+	repository, digest, ok := splitImageReference(known.Image, known.Version)
+	if !ok {
+		return 0, &invalidPromotionError{message: fmt.Sprintf("image %s of AtlasApp %s does not run version %s", known.Image, sourceKey, known.Version)}
+	}
+
+	var spec atlasv1.AtlasAppSpec
+	target := &atlasv1.AtlasApp{}
+	err = r.Get(ctx, targetKey, target)
+	if err != nil && errors.IsNotFound(err) {
+		spec = promotedAppSpec(source, pipeline, stage)
+	} else if err != nil {
+		return 0, err
+	} else {
+		spec = *target.Spec.DeepCopy()
+	}
+
+	spec.Version = known.Version
+	spec.Image = repository
+	spec.ImageDigest = digest
+	spec.MigrationId = known.MigrationId
+
+	return promoteToApp(ctx, r.Client, targetKey, spec, source)
+}
+
+// isSuperseded reports if a newer promotion from the same source to the same target exists
+func (r *AtlasPromotionReconciler) isSuperseded(ctx context.Context, promotion *atlasv1.AtlasPromotion) (bool, error) {
+	var promotions atlasv1.AtlasPromotionList
+	if err := r.List(ctx, &promotions, client.InNamespace(promotion.Namespace), client.MatchingLabels{
+		atlasv1.SourceNamespaceLabel: promotion.Spec.Source.Namespace,
+		atlasv1.SourceNameLabel:      promotion.Spec.Source.Name,
+	}); err != nil {
+		return false, err
+	}
+
+	for _, other := range promotions.Items {
+		if other.Name != promotion.Name &&
+			other.Spec.Target == promotion.Spec.Target &&
+			promotion.CreationTimestamp.Before(&other.CreationTimestamp) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// updateStatus updates the AtlasPromotion phase and message
+func (r *AtlasPromotionReconciler) updateStatus(ctx context.Context, promotion *atlasv1.AtlasPromotion, phase, message string) (ctrl.Result, error) {
+	if phase != atlasv1.PromotionPhasePending && promotion.Status.DecidedAt == nil {
+		now := metav1.Now()
+		promotion.Status.DecidedAt = &now
+	}
+	promotion.Status.ObservedGeneration = promotion.Generation
+	promotion.Status.Phase = phase
+	promotion.Status.Message = message
+
+	if err := r.Status().Update(ctx, promotion); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// siblingPromotions maps an AtlasPromotion to all promotions from the same
+// source, so pending ones notice when a newer promotion supersedes them
+func (r *AtlasPromotionReconciler) siblingPromotions(ctx context.Context, obj client.Object) []reconcile.Request {
+	labels := obj.GetLabels()
+	if labels[atlasv1.SourceNameLabel] == "" {
+		return nil
+	}
+
+	var promotions atlasv1.AtlasPromotionList
+	if err := r.List(ctx, &promotions, client.InNamespace(obj.GetNamespace()), client.MatchingLabels{
+		atlasv1.SourceNamespaceLabel: labels[atlasv1.SourceNamespaceLabel],
+		atlasv1.SourceNameLabel:      labels[atlasv1.SourceNameLabel],
+	}); err != nil {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, promotion := range promotions.Items {
+		if promotion.Name != obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&promotion)})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *AtlasPromotionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&atlasv1.AtlasPromotion{}).
+		Watches(&atlasv1.AtlasPromotion{}, handler.EnqueueRequestsFromMapFunc(r.siblingPromotions),
+			builder.WithPredicates(predicate.Funcs{
+				UpdateFunc: func(event.UpdateEvent) bool { return false },
+			})).
+		Complete(r)
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+// setKnownGood records the spec release of the stored AtlasApp as its last known-good release
+func setKnownGood(atlasApp *atlasv1.AtlasApp) {
+	latest := &atlasv1.AtlasApp{}
+	Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(atlasApp), latest)).To(Succeed())
+	now := metav1.Now()
+	latest.Status.LastKnownGood = &atlasv1.Revision{
+		Version:     latest.Spec.Version,
+		Image:       imageReference(&latest.Spec),
+		MigrationId: latest.Spec.MigrationId,
+		ReadyAt:     &now,
+	}
+	Expect(k8sClient.Status().Update(ctx, latest)).To(Succeed())
+}
+
+var _ = Describe("AtlasPromotion controller", func() {
+	var (
+		r         *AtlasPromotionReconciler
+		source    *atlasv1.AtlasApp
+		target    types.NamespacedName
+		promotion *atlasv1.AtlasPromotion
+	)
+
+	BeforeEach(func() {
+		r = &AtlasPromotionReconciler{
+			Client:   k8sClient,
+			Scheme:   k8sClient.Scheme(),
+			Recorder: record.NewFakeRecorder(100),
+		}
+
+		stageNamespace := newNamespace("stage")
+		prodNamespace := newNamespace("prod")
+		pipeline := newPipeline(
+			atlasv1.PipelineStage{Name: "stage", Namespace: stageNamespace},
+			atlasv1.PipelineStage{Name: "prod", Namespace: prodNamespace, RequireApproval: true},
+		)
+
+		source = newApp(stageNamespace, "stage", "1.22.0")
+		source.Name = "atlas-stage"
+		source.Spec.Image = "ghcr.io/example/atlas"
+		source.Spec.MigrationId = 6
+		source.Spec.Pipeline = pipeline
+		Expect(k8sClient.Create(ctx, source)).To(Succeed())
+		setKnownGood(source)
+
+		target = types.NamespacedName{Name: "atlas-prod", Namespace: prodNamespace}
+		promotion = &atlasv1.AtlasPromotion{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "atlas-stage-to-prod-1.22.0-m6",
+				Namespace: prodNamespace,
+				Labels: map[string]string{
+					atlasv1.SourceNamespaceLabel: source.Namespace,
+					atlasv1.SourceNameLabel:      source.Name,
+				},
+			},
+			Spec: atlasv1.AtlasPromotionSpec{
+				Source:            atlasv1.AppReference{Name: source.Name, Namespace: source.Namespace},
+				Target:            atlasv1.AppReference{Name: target.Name, Namespace: target.Namespace},
+				TargetEnvironment: "prod",
+				Version:           "1.22.0",
+				MigrationId:       6,
+				Decision:          atlasv1.PromotionDecisionApproved,
+				DecidedBy:         "jane.doe",
+			},
+		}
+	})
+
+	// reconcilePromotion creates the AtlasPromotion, reconciles it and returns it as stored afterwards
+	reconcilePromotion := func() *atlasv1.AtlasPromotion {
+		Expect(k8sClient.Create(ctx, promotion)).To(Succeed())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(promotion)})
+		Expect(err).NotTo(HaveOccurred())
+
+		updated := &atlasv1.AtlasPromotion{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(promotion), updated)).To(Succeed())
+		return updated
+	}
+
+	targetExists := func() bool {
+		err := k8sClient.Get(ctx, target, &atlasv1.AtlasApp{})
+		if errors.IsNotFound(err) {
+			return false
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return true
+	}
+
+	It("creates the target with the known-good release of the source", func() {
+		// The spec only selects the release, the image comes from the source
+		promotion.Spec.Image = "registry.example.com/other"
+
+		updated := reconcilePromotion()
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PromotionPhaseApplied))
+
+		app := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, target, app)).To(Succeed())
+		Expect(app.Spec.Environment).To(Equal("prod"))
+		Expect(app.Spec.Version).To(Equal("1.22.0"))
+		Expect(app.Spec.Image).To(Equal("ghcr.io/example/atlas"))
+		Expect(app.Spec.MigrationId).To(Equal(6))
+		Expect(app.Spec.RequireApproval).To(BeTrue())
+		Expect(app.Annotations).To(HaveKeyWithValue(atlasv1.PromotedFromAnnotation, source.Namespace+"/"+source.Name))
+		Expect(app.Annotations).NotTo(HaveKey(atlasv1.ApprovedByAnnotation))
+		Expect(updated.Status.TargetGeneration).To(Equal(app.Generation))
+	})
+
+	It("fails a version that is not the known-good release of the source", func() {
+		promotion.Spec.Version = "1.23.0"
+
+		updated := reconcilePromotion()
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PromotionPhaseFailed))
+		Expect(updated.Status.Message).To(ContainSubstring("not the last known-good release"))
+		Expect(targetExists()).To(BeFalse())
+	})
+
+	It("fails a target outside the namespace of the promotion", func() {
+		target = types.NamespacedName{Name: "atlas-prod", Namespace: source.Namespace}
+		promotion.Spec.Target.Namespace = source.Namespace
+
+		updated := reconcilePromotion()
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PromotionPhaseFailed))
+		Expect(updated.Status.Message).To(ContainSubstring("is not the namespace of the AtlasPromotion"))
+		Expect(targetExists()).To(BeFalse())
+	})
+
+	It("fails a target the source does not promote to", func() {
+		target.Name = "payments-prod"
+		promotion.Spec.Target.Name = target.Name
+
+		updated := reconcilePromotion()
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PromotionPhaseFailed))
+		Expect(targetExists()).To(BeFalse())
+	})
+
+	It("approves the target generation it wrote only", func() {
+		updated := reconcilePromotion()
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PromotionPhaseApplied))
+
+		appReconciler := newAppReconciler()
+		app := &atlasv1.AtlasApp{ObjectMeta: metav1.ObjectMeta{Name: target.Name, Namespace: target.Namespace}}
+		_, app = reconcileApp(appReconciler, app)
+		Expect(app.Status.Phase).NotTo(Equal(atlasv1.PhasePendingApproval))
+		Expect(app.Status.Approval).NotTo(BeNil())
+		Expect(app.Status.Approval.ApprovedBy).To(Equal("jane.doe"))
+		Expect(app.Status.Approval.Promotion).To(Equal(promotion.Namespace + "/" + promotion.Name))
+		Expect(k8sClient.Get(ctx, target, &appsv1.Deployment{})).To(Succeed())
+
+		app.Spec.Replicas = 5
+		Expect(k8sClient.Update(ctx, app)).To(Succeed())
+		_, app = reconcileApp(appReconciler, app)
+		Expect(app.Status.Phase).To(Equal(atlasv1.PhasePendingApproval))
+		Expect(app.Status.Approval).To(BeNil())
+	})
+
+	It("applies to one of several next stages", func() {
+		euNamespace := newNamespace("prod-eu")
+		usNamespace := newNamespace("prod-us")
+		latest := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(source), latest)).To(Succeed())
+		latest.Spec.Pipeline = newPipeline(
+			atlasv1.PipelineStage{Name: "stage", Namespace: source.Namespace, Next: []string{"prod-eu", "prod-us"}},
+			atlasv1.PipelineStage{Name: "prod-eu", Namespace: euNamespace, RequireApproval: true},
+			atlasv1.PipelineStage{Name: "prod-us", Namespace: usNamespace, RequireApproval: true},
+		)
+		Expect(k8sClient.Update(ctx, latest)).To(Succeed())
+		setKnownGood(source)
+
+		target = types.NamespacedName{Name: "atlas-prod-us", Namespace: usNamespace}
+		promotion.Namespace = usNamespace
+		promotion.Spec.Target = atlasv1.AppReference{Name: target.Name, Namespace: target.Namespace}
+		promotion.Spec.TargetEnvironment = "prod-us"
+
+		updated := reconcilePromotion()
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PromotionPhaseApplied))
+		app := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, target, app)).To(Succeed())
+		Expect(app.Spec.Environment).To(Equal("prod-us"))
+	})
+
+	It("leaves the target alone when the promotion is rejected", func() {
+		promotion.Spec.Decision = atlasv1.PromotionDecisionRejected
+		promotion.Spec.Reason = "Failed QA"
+
+		updated := reconcilePromotion()
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PromotionPhaseRejected))
+		Expect(targetExists()).To(BeFalse())
+	})
+})
@@ -0,0 +1,190 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+// replicasFieldManager is the server-side apply field manager keeping
+// spec.replicas of a Deployment the HPA scales, see handOverReplicas
+const replicasFieldManager = "atlas-controller-replicas"
+
+// autoscalingEnabled reports if an HPA scales the AtlasApp instead of spec.replicas
+func autoscalingEnabled(atlasApp *atlasv1.AtlasApp) bool {
+	return atlasApp.Spec.Autoscaling != nil
+}
+
+// minReplicas returns the lower replica limit of the HPA
+func minReplicas(atlasApp *atlasv1.AtlasApp) int32 {
+	if replicas := atlasApp.Spec.Autoscaling.MinReplicas; replicas != nil {
+		return *replicas
+	}
+	return 1
+}
+
+// activeReplicas returns the replicas to assert on the Deployment serving the
+// AtlasApp. It is nil while an HPA scales it, see applyDeployment.
+func activeReplicas(atlasApp *atlasv1.AtlasApp) *int32 {
+	if autoscalingEnabled(atlasApp) {
+		return nil
+	}
+	return &atlasApp.Spec.Replicas
+}
+
+// rolloutReplicas returns the replicas a Deployment that is not yet serving
+// starts with, e.g. the preview color
+func rolloutReplicas(atlasApp *atlasv1.AtlasApp) int32 {
+	if autoscalingEnabled(atlasApp) {
+		return minReplicas(atlasApp)
+	}
+	return atlasApp.Spec.Replicas
+}
+
+// handOverReplicas makes replicasFieldManager a co-owner of spec.replicas of a
+// Deployment this controller still manages it on, at its current value. The
+// apply config can then leave the field out without resetting it to one
+// replica, which leaves the count to the HPA.
+func (r *AtlasAppReconciler) handOverReplicas(ctx context.Context, deployment *appsv1.Deployment) error {
+	if deployment.Spec.Replicas == nil || !managesReplicas(deployment, legacyFieldManagers) {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Handing Deployment replicas over to the HPA", "Deployment.Name", deployment.Name, "replicas", *deployment.Spec.Replicas)
+	handover := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": appsv1.SchemeGroupVersion.String(),
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":      deployment.Name,
+			"namespace": deployment.Namespace,
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(*deployment.Spec.Replicas),
+		},
+	}}
+	return r.Patch(ctx, handover, client.Apply, client.FieldOwner(replicasFieldManager), client.ForceOwnership)
+}
+
+// managesReplicas reports if one of the field managers owns spec.replicas of the Deployment
+func managesReplicas(deployment *appsv1.Deployment, managers sets.Set[string]) bool {
+	for _, entry := range deployment.ManagedFields {
+		if !managers.Has(entry.Manager) || entry.FieldsV1 == nil {
+			continue
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		if spec, ok := fields["f:spec"].(map[string]interface{}); ok {
+			if _, ok := spec["f:replicas"]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// desiredAutoscaler returns the HPA scaling the active Deployment of the AtlasApp
+func desiredAutoscaler(atlasApp *atlasv1.AtlasApp) *autoscalingv2.HorizontalPodAutoscaler {
+	spec := atlasApp.Spec.Autoscaling
+	lower := minReplicas(atlasApp)
+
+	var metrics []autoscalingv2.MetricSpec
+	for _, target := range []struct {
+		resource corev1.ResourceName
+		percent  *int32
+	}{
+		{corev1.ResourceCPU, spec.TargetCPUUtilizationPercentage},
+		{corev1.ResourceMemory, spec.TargetMemoryUtilizationPercentage},
+	} {
+		if target.percent == nil {
+			continue
+		}
+		metrics = append(metrics, autoscalingv2.MetricSpec{
+			Type: autoscalingv2.ResourceMetricSourceType,
+			Resource: &autoscalingv2.ResourceMetricSource{
+				Name: target.resource,
+				Target: autoscalingv2.MetricTarget{
+					Type:               autoscalingv2.UtilizationMetricType,
+					AverageUtilization: target.percent,
+				},
+			},
+		})
+	}
+
+	return &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      atlasApp.Name,
+			Namespace: atlasApp.Namespace,
+			Labels: map[string]string{
+				"app":                  "atlas",
+				appNameLabel:           atlasApp.Name,
+				"atlas.io/environment": atlasApp.Spec.Environment,
+				"atlas.io/managed-by":  "atlas-controller",
+			},
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       activeDeploymentName(atlasApp),
+			},
+			MinReplicas: &lower,
+			MaxReplicas: spec.MaxReplicas,
+			Metrics:     metrics,
+			Behavior:    spec.Behavior,
+		},
+	}
+}
+
+// reconcileAutoscaler applies the HPA of the AtlasApp, or removes it once
+// autoscaling is turned off. Changes are recorded as drift only if detectDrift
+// is set.
+func (r *AtlasAppReconciler) reconcileAutoscaler(ctx context.Context, atlasApp *atlasv1.AtlasApp, detectDrift bool) error {
+	if autoscalingEnabled(atlasApp) {
+		return r.applyOwned(ctx, atlasApp, desiredAutoscaler(atlasApp), &autoscalingv2.HorizontalPodAutoscaler{}, detectDrift)
+	}
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+	err := r.Get(ctx, types.NamespacedName{Name: atlasApp.Name, Namespace: atlasApp.Namespace}, hpa)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(hpa, atlasApp) {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Deleting HorizontalPodAutoscaler", "HorizontalPodAutoscaler.Name", hpa.Name)
+	if err := r.Delete(ctx, hpa); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+// scaleDeployment changes the replicas of a Deployment the way the HPA does
+func scaleDeployment(key types.NamespacedName, replicas int32) {
+	deployment := getDeployment(key)
+	deployment.Spec.Replicas = &replicas
+	Expect(k8sClient.Update(ctx, deployment, client.FieldOwner("horizontal-pod-autoscaler"))).To(Succeed())
+}
+
+var _ = Describe("Autoscaling", func() {
+	var (
+		r        *AtlasAppReconciler
+		atlasApp *atlasv1.AtlasApp
+		key      types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		r = newAppReconciler()
+		atlasApp = newApp(newNamespace("autoscaling"), "dev", "1.0.0")
+		atlasApp.Spec.Replicas = 2
+		atlasApp.Spec.HealthCheckPath = ""
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		key = client.ObjectKeyFromObject(atlasApp)
+
+		reconcileApp(r, atlasApp)
+		Expect(*getDeployment(key).Spec.Replicas).To(Equal(int32(2)))
+	})
+
+	setAutoscaling := func(autoscaling *atlasv1.AutoscalingSpec) {
+		latest := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, key, latest)).To(Succeed())
+		latest.Spec.Autoscaling = autoscaling
+		Expect(k8sClient.Update(ctx, latest)).To(Succeed())
+	}
+
+	enableAutoscaling := func() {
+		minReplicas := int32(2)
+		targetCPU := int32(70)
+		setAutoscaling(&atlasv1.AutoscalingSpec{
+			MinReplicas:                    &minReplicas,
+			MaxReplicas:                    10,
+			TargetCPUUtilizationPercentage: &targetCPU,
+		})
+		reconcileApp(r, atlasApp)
+	}
+
+	It("creates an HPA for the Deployment", func() {
+		enableAutoscaling()
+
+		hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+		Expect(k8sClient.Get(ctx, key, hpa)).To(Succeed())
+		Expect(hpa.Spec.ScaleTargetRef.Kind).To(Equal("Deployment"))
+		Expect(hpa.Spec.ScaleTargetRef.Name).To(Equal("atlas"))
+		Expect(*hpa.Spec.MinReplicas).To(Equal(int32(2)))
+		Expect(hpa.Spec.MaxReplicas).To(Equal(int32(10)))
+		Expect(hpa.Spec.Metrics).To(HaveLen(1))
+		Expect(hpa.Spec.Metrics[0].Resource.Name).To(Equal(corev1.ResourceCPU))
+		Expect(*hpa.Spec.Metrics[0].Resource.Target.AverageUtilization).To(Equal(int32(70)))
+	})
+
+	It("leaves the replicas of the Deployment to the HPA", func() {
+		enableAutoscaling()
+
+		deployment := getDeployment(key)
+		Expect(*deployment.Spec.Replicas).To(Equal(int32(2)))
+		Expect(managesReplicas(deployment, sets.New(fieldManager))).To(BeFalse())
+		Expect(managesReplicas(deployment, sets.New(replicasFieldManager))).To(BeTrue())
+
+		scaleDeployment(key, 7)
+		reconcileApp(r, atlasApp)
+		Expect(*getDeployment(key).Spec.Replicas).To(Equal(int32(7)))
+	})
+
+	It("takes the replicas back and removes the HPA once autoscaling is turned off", func() {
+		enableAutoscaling()
+		scaleDeployment(key, 7)
+
+		setAutoscaling(nil)
+		reconcileApp(r, atlasApp)
+		Expect(*getDeployment(key).Spec.Replicas).To(Equal(int32(2)))
+		Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &autoscalingv2.HorizontalPodAutoscaler{}))).To(BeTrue())
+	})
+})
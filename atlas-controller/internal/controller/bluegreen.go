@@ -0,0 +1,342 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+const (
+	// colorLabel tells the blue and green pods of an AtlasApp apart
+	colorLabel = "atlas.io/color"
+
+	defaultScaleDownDelaySeconds = 600
+)
+
+// blueGreenEnabled reports if the AtlasApp uses the blue/green strategy
+func blueGreenEnabled(atlasApp *atlasv1.AtlasApp) bool {
+	return atlasApp.Spec.Strategy != nil && atlasApp.Spec.Strategy.BlueGreen != nil
+}
+
+// colorName returns the name of the Deployment of a color
+func colorName(atlasApp *atlasv1.AtlasApp, color atlasv1.DeploymentColor) string {
+	return fmt.Sprintf("%s-%s", atlasApp.Name, color)
+}
+
+// otherColor returns the color that is not active, blue if none is
+func otherColor(color atlasv1.DeploymentColor) atlasv1.DeploymentColor {
+	if color == atlasv1.ColorBlue {
+		return atlasv1.ColorGreen
+	}
+	return atlasv1.ColorBlue
+}
+
+// activeColor returns the color the Service selects, or "" before the first switch
+func activeColor(atlasApp *atlasv1.AtlasApp) atlasv1.DeploymentColor {
+	if !blueGreenEnabled(atlasApp) || atlasApp.Status.BlueGreen == nil {
+		return ""
+	}
+	return atlasApp.Status.BlueGreen.ActiveColor
+}
+
+// activeDeploymentName returns the name of the Deployment serving the AtlasApp
+func activeDeploymentName(atlasApp *atlasv1.AtlasApp) string {
+	if color := activeColor(atlasApp); color != "" {
+		return colorName(atlasApp, color)
+	}
+	return atlasApp.Name
+}
+
+// activeColorLabels returns the extra labels of the Deployment serving the AtlasApp
+func activeColorLabels(atlasApp *atlasv1.AtlasApp) map[string]string {
+	if color := activeColor(atlasApp); color != "" {
+		return map[string]string{colorLabel: string(color)}
+	}
+	return nil
+}
+
+// serviceSelector returns the Service selector, limited to the active color for blue/green
+func serviceSelector(atlasApp *atlasv1.AtlasApp) map[string]string {
+	selector := selectorLabels(atlasApp)
+	if color := activeColor(atlasApp); color != "" {
+		selector[colorLabel] = string(color)
+	}
+	return selector
+}
+
+// scaleDownDelay returns how long the previous color keeps running after a switch
+func scaleDownDelay(atlasApp *atlasv1.AtlasApp) time.Duration {
+	seconds := int32(defaultScaleDownDelaySeconds)
+	if delay := atlasApp.Spec.Strategy.BlueGreen.ScaleDownDelaySeconds; delay != nil {
+		seconds = *delay
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// reconcileBlueGreen rolls the spec release out to the idle color, and switches
+// the Service over once it is healthy. It reports done once the active color
+// runs the spec release and the regular readiness and health checks take over.
+func (r *AtlasAppReconciler) reconcileBlueGreen(ctx context.Context, atlasApp *atlasv1.AtlasApp) (ctrl.Result, bool, error) {
+	desired := specRelease(atlasApp)
+	if atlasApp.Status.BlueGreen == nil {
+		atlasApp.Status.BlueGreen = &atlasv1.BlueGreenStatus{}
+	}
+
+	if err := r.reconcileService(ctx, atlasApp, true); err != nil {
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+		return result, false, err
+	}
+
+	// Before the first switch the Deployment of the regular rollout serves
+	serving := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Name: activeDeploymentName(atlasApp), Namespace: atlasApp.Namespace}, serving)
+	if err != nil && !errors.IsNotFound(err) {
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+		return result, false, err
+	}
+
+	var current *release
+	if err == nil {
+		if rel, ok := deploymentRelease(serving); ok {
+			current = &rel
+		}
+	}
+
+	if current != nil && *current == desired && activeColor(atlasApp) != "" {
+		return r.reconcileActiveColor(ctx, atlasApp, desired)
+	}
+
+	// Pods of the regular rollout carry no color, so the Service could not tell
+	// them from the preview. Move the serving release to a color first.
+	if current != nil && *current != desired && activeColor(atlasApp) == "" {
+		if result, done, err := r.reconcilePreviewColor(ctx, atlasApp, *current, current); err != nil || !done {
+			return result, false, err
+		}
+		return ctrl.Result{Requeue: true}, false, nil
+	}
+	return r.reconcilePreviewColor(ctx, atlasApp, desired, current)
+}
+
+// reconcileActiveColor keeps the active color at full scale, and scales the
+// previous color down once its rollback window has passed
+func (r *AtlasAppReconciler) reconcileActiveColor(ctx context.Context, atlasApp *atlasv1.AtlasApp, desired release) (ctrl.Result, bool, error) {
+	status := atlasApp.Status.BlueGreen
+	status.ActiveVersion = desired.version
+	status.PreviewColor = ""
+	status.PreviewVersion = ""
+	status.PreviewImage = ""
+	status.Aborted = false
+
+	deployment := desiredDeployment(atlasApp, colorName(atlasApp, status.ActiveColor), desired, activeReplicas(atlasApp),
+		map[string]string{colorLabel: string(status.ActiveColor)})
+	if err := r.applyDeployment(ctx, atlasApp, deployment, true); err != nil {
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+		return result, false, err
+	}
+
+	if status.ScaleDownAt == nil || !time.Now().Before(status.ScaleDownAt.Time) {
+		if err := r.scaleDownColor(ctx, atlasApp, otherColor(status.ActiveColor)); err != nil {
+			result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+			return result, false, err
+		}
+		// The Deployment of the regular rollout is the previous color of the first switch
+		if err := r.deleteOwnedDeployment(ctx, atlasApp, atlasApp.Name); err != nil {
+			result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+			return result, false, err
+		}
+		status.ScaleDownAt = nil
+	}
+
+	return ctrl.Result{}, true, nil
+}
+
+// reconcilePreviewColor rolls the spec release out to the idle color, verifies
+// it and switches the Service over. current is the release serving right now.
+func (r *AtlasAppReconciler) reconcilePreviewColor(ctx context.Context, atlasApp *atlasv1.AtlasApp, desired release, current *release) (ctrl.Result, bool, error) {
+	log := log.FromContext(ctx)
+	status := atlasApp.Status.BlueGreen
+	preview := otherColor(status.ActiveColor)
+
+	restart := status.PreviewColor != preview || status.PreviewVersion != desired.version || status.PreviewImage != desired.image ||
+		(status.Aborted && status.Generation != atlasApp.Generation)
+	if restart {
+		log.Info("Rolling out preview", "color", preview, "version", desired.version)
+		r.Recorder.Eventf(atlasApp, corev1.EventTypeNormal, "PreviewStarted", "Rolling out version %s to %s", desired.version, preview)
+		status.PreviewColor = preview
+		status.PreviewVersion = desired.version
+		status.PreviewImage = desired.image
+		status.Aborted = false
+		atlasApp.Status.HealthCheck = nil
+	}
+
+	if status.Aborted {
+		// Keep the active color until the spec changes
+		if err := r.scaleDownColor(ctx, atlasApp, preview); err != nil {
+			result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+			return result, false, err
+		}
+		restoreDeployedRelease(atlasApp, current)
+		_, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseRolloutAborted, false, status.Message)
+		return ctrl.Result{}, false, err
+	}
+	status.Generation = atlasApp.Generation
+
+	replicas := rolloutReplicas(atlasApp)
+	deployment := desiredDeployment(atlasApp, colorName(atlasApp, preview), desired, &replicas,
+		map[string]string{colorLabel: string(preview)})
+	if err := r.applyDeployment(ctx, atlasApp, deployment, !restart); err != nil {
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+		return result, false, err
+	}
+
+	if progressDeadlineExceeded(deployment) {
+		return r.abortPreview(ctx, atlasApp, current, fmt.Sprintf("%s Deployment exceeded its progress deadline", preview))
+	}
+	if !deploymentRolledOut(deployment) {
+		status.Message = fmt.Sprintf("Rolling out version %s to %s", desired.version, preview)
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseDeploying, false, status.Message)
+		return result, false, err
+	}
+
+	if atlasApp.Spec.HealthCheckPath != "" {
+		healthy, err := r.performHealthCheck(ctx, atlasApp, deployment.Name)
+		if err != nil {
+			result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, fmt.Sprintf("Health check failed: %v", err))
+			return result, false, err
+		}
+		if !healthy {
+			if healthCheckFailed(atlasApp) {
+				return r.abortPreview(ctx, atlasApp, current, fmt.Sprintf("%s failed its health checks", preview))
+			}
+			status.Message = fmt.Sprintf("Waiting for version %s on %s to pass health checks", desired.version, preview)
+			if _, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseVerifying, false, status.Message); err != nil {
+				return ctrl.Result{}, false, err
+			}
+			return ctrl.Result{RequeueAfter: healthCheckInterval(atlasApp)}, false, nil
+		}
+	}
+
+	return r.switchColor(ctx, atlasApp, preview, desired)
+}
+
+// switchColor points the Service at the given color, and schedules the scale
+// down of the previous one
+func (r *AtlasAppReconciler) switchColor(ctx context.Context, atlasApp *atlasv1.AtlasApp, color atlasv1.DeploymentColor, desired release) (ctrl.Result, bool, error) {
+	status := atlasApp.Status.BlueGreen
+	previous := status.ActiveColor
+
+	now := metav1.Now()
+	scaleDownAt := metav1.NewTime(now.Add(scaleDownDelay(atlasApp)))
+	status.ActiveColor = color
+	status.ActiveVersion = desired.version
+	status.PreviewColor = ""
+	status.PreviewVersion = ""
+	status.PreviewImage = ""
+	status.SwitchedAt = &now
+	status.ScaleDownAt = &scaleDownAt
+	status.Message = fmt.Sprintf("Switched to %s running version %s", color, desired.version)
+
+	log.FromContext(ctx).Info("Switching Service", "from", previous, "to", color, "version", desired.version)
+	r.Recorder.Event(atlasApp, corev1.EventTypeNormal, "Switched", status.Message)
+
+	if err := r.reconcileService(ctx, atlasApp, false); err != nil {
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+		return result, false, err
+	}
+	if err := r.writeStatus(ctx, atlasApp); err != nil {
+		return ctrl.Result{}, false, err
+	}
+	return ctrl.Result{}, true, nil
+}
+
+// abortPreview scales the preview color down and keeps the active one serving
+func (r *AtlasAppReconciler) abortPreview(ctx context.Context, atlasApp *atlasv1.AtlasApp, current *release, reason string) (ctrl.Result, bool, error) {
+	status := atlasApp.Status.BlueGreen
+	status.Aborted = true
+	status.Message = fmt.Sprintf("Version %s aborted: %s", status.PreviewVersion, reason)
+
+	log.FromContext(ctx).Info("Aborting preview", "color", status.PreviewColor, "version", status.PreviewVersion, "reason", reason)
+	r.Recorder.Event(atlasApp, corev1.EventTypeWarning, "PreviewAborted", status.Message)
+
+	if err := r.scaleDownColor(ctx, atlasApp, status.PreviewColor); err != nil {
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+		return result, false, err
+	}
+	restoreDeployedRelease(atlasApp, current)
+
+	// Spec changes retry the rollout, no need to poll
+	_, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseRolloutAborted, false, status.Message)
+	return ctrl.Result{}, false, err
+}
+
+// restoreDeployedRelease records the serving release as deployed, so going back to it is no downgrade
+func restoreDeployedRelease(atlasApp *atlasv1.AtlasApp, current *release) {
+	if current == nil {
+		return
+	}
+	atlasApp.Status.DeployedVersion = current.version
+	atlasApp.Status.DeployedMigrationId = current.migrationId
+}
+
+// scaleDownColor scales the Deployment of a color to zero, keeping its release
+// so switching back only needs a scale up
+func (r *AtlasAppReconciler) scaleDownColor(ctx context.Context, atlasApp *atlasv1.AtlasApp, color atlasv1.DeploymentColor) error {
+	existing := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: colorName(atlasApp, color), Namespace: atlasApp.Namespace}, existing); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	rel, ok := deploymentRelease(existing)
+	if !ok || (existing.Spec.Replicas != nil && *existing.Spec.Replicas == 0) {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Scaling down previous color", "color", color, "version", rel.version)
+	replicas := int32(0)
+	deployment := desiredDeployment(atlasApp, existing.Name, rel, &replicas, map[string]string{colorLabel: string(color)})
+	return r.applyDeployment(ctx, atlasApp, deployment, false)
+}
+
+// cleanupBlueGreen removes the blue and green Deployments once the AtlasApp no
+// longer uses the blue/green strategy
+func (r *AtlasAppReconciler) cleanupBlueGreen(ctx context.Context, atlasApp *atlasv1.AtlasApp) error {
+	if blueGreenEnabled(atlasApp) {
+		return nil
+	}
+	for _, color := range []atlasv1.DeploymentColor{atlasv1.ColorBlue, atlasv1.ColorGreen} {
+		if err := r.deleteOwnedDeployment(ctx, atlasApp, colorName(atlasApp, color)); err != nil {
+			return err
+		}
+	}
+	atlasApp.Status.BlueGreen = nil
+	return nil
+}
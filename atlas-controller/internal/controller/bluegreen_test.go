@@ -0,0 +1,129 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+var _ = Describe("Blue/green rollouts", func() {
+	var (
+		r        *AtlasAppReconciler
+		atlasApp *atlasv1.AtlasApp
+		key      types.NamespacedName
+		blueKey  types.NamespacedName
+		greenKey types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		r = newAppReconciler()
+		atlasApp = newApp(newNamespace("bluegreen"), "dev", "1.0.0")
+		atlasApp.Spec.Replicas = 2
+		atlasApp.Spec.HealthCheckPath = ""
+		scaleDownDelay := int32(0)
+		atlasApp.Spec.Strategy = &atlasv1.RolloutStrategy{BlueGreen: &atlasv1.BlueGreenStrategy{
+			ScaleDownDelaySeconds: &scaleDownDelay,
+		}}
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		key = client.ObjectKeyFromObject(atlasApp)
+		blueKey = types.NamespacedName{Name: "atlas-blue", Namespace: atlasApp.Namespace}
+		greenKey = types.NamespacedName{Name: "atlas-green", Namespace: atlasApp.Namespace}
+
+		// The first release goes to blue
+		reconcileApp(r, atlasApp)
+		markDeploymentReady(blueKey)
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseReady), updated.Status.Message)
+		Expect(updated.Status.BlueGreen.ActiveColor).To(Equal(atlasv1.ColorBlue))
+	})
+
+	setVersion := func(version string) {
+		latest := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, key, latest)).To(Succeed())
+		latest.Spec.Version = version
+		Expect(k8sClient.Update(ctx, latest)).To(Succeed())
+	}
+
+	selectedColor := func() string {
+		service := &corev1.Service{}
+		Expect(k8sClient.Get(ctx, key, service)).To(Succeed())
+		return service.Spec.Selector[colorLabel]
+	}
+
+	It("switches the Service once the idle color is ready", func() {
+		Expect(selectedColor()).To(Equal("blue"))
+
+		setVersion("1.1.0")
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseDeploying))
+		Expect(updated.Status.BlueGreen.PreviewColor).To(Equal(atlasv1.ColorGreen))
+		green := getDeployment(greenKey)
+		Expect(*green.Spec.Replicas).To(Equal(int32(2)))
+		Expect(green.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.1.0"))
+		Expect(green.Spec.Template.Labels).To(HaveKeyWithValue(colorLabel, "green"))
+		Expect(selectedColor()).To(Equal("blue"))
+
+		markDeploymentReady(greenKey)
+		_, updated = reconcileApp(r, atlasApp)
+		Expect(updated.Status.BlueGreen.ActiveColor).To(Equal(atlasv1.ColorGreen))
+		Expect(updated.Status.BlueGreen.ActiveVersion).To(Equal("1.1.0"))
+		Expect(selectedColor()).To(Equal("green"))
+
+		// The previous color is kept at its release, scaled to zero after the delay
+		reconcileApp(r, atlasApp)
+		blue := getDeployment(blueKey)
+		Expect(*blue.Spec.Replicas).To(BeZero())
+		Expect(blue.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.0.0"))
+	})
+
+	It("keeps the active color when the preview stalls", func() {
+		setVersion("1.1.0")
+		reconcileApp(r, atlasApp)
+
+		green := getDeployment(greenKey)
+		green.Status.Conditions = []appsv1.DeploymentCondition{{
+			Type:               appsv1.DeploymentProgressing,
+			Status:             corev1.ConditionFalse,
+			Reason:             "ProgressDeadlineExceeded",
+			LastUpdateTime:     metav1.Now(),
+			LastTransitionTime: metav1.Now(),
+		}}
+		Expect(k8sClient.Status().Update(ctx, green)).To(Succeed())
+
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseRolloutAborted))
+		Expect(updated.Status.BlueGreen.Aborted).To(BeTrue())
+		Expect(updated.Status.BlueGreen.ActiveColor).To(Equal(atlasv1.ColorBlue))
+		Expect(updated.Status.DeployedVersion).To(Equal("1.0.0"))
+		Expect(*getDeployment(greenKey).Spec.Replicas).To(BeZero())
+		Expect(selectedColor()).To(Equal("blue"))
+
+		// The aborted preview stays down until the spec changes
+		_, updated = reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseRolloutAborted))
+		Expect(*getDeployment(greenKey).Spec.Replicas).To(BeZero())
+	})
+})
@@ -0,0 +1,318 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+const (
+	// trackLabel tells the pods of the Deployments of an AtlasApp apart
+	trackLabel = "atlas.io/track"
+
+	// canaryTrack is the trackLabel value of canary pods
+	canaryTrack = "canary"
+)
+
+// canaryName returns the name of the canary Deployment of an AtlasApp
+func canaryName(atlasApp *atlasv1.AtlasApp) string {
+	return atlasApp.Name + "-canary"
+}
+
+// canaryReplicas splits replicas between the canary and the stable Deployment
+// for a step weight. The canary gets at least one replica.
+func canaryReplicas(replicas, weight int32) (int32, int32) {
+	canary := (replicas*weight + 99) / 100
+	if canary < 1 {
+		canary = 1
+	}
+	if canary > replicas {
+		canary = replicas
+	}
+	return canary, replicas - canary
+}
+
+// deploymentRolledOut reports if all replicas of a Deployment run its current template and are ready
+func deploymentRolledOut(deployment *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	return deployment.Status.ObservedGeneration >= deployment.Generation &&
+		deployment.Status.UpdatedReplicas == replicas &&
+		deployment.Status.ReadyReplicas == replicas &&
+		deployment.Status.Replicas == replicas
+}
+
+// progressDeadlineExceeded reports if a Deployment stopped making progress
+func progressDeadlineExceeded(deployment *appsv1.Deployment) bool {
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing {
+			return condition.Status == corev1.ConditionFalse && condition.Reason == "ProgressDeadlineExceeded"
+		}
+	}
+	return false
+}
+
+// reconcileCanary steps a new version through spec.strategy.canary next to the
+// stable Deployment. Both run behind the same Service, so traffic follows the
+// replica split. It reports done when there is nothing to canary, i.e. on the
+// first rollout or once the stable Deployment runs the spec release, and the
+// regular rollout takes over.
+func (r *AtlasAppReconciler) reconcileCanary(ctx context.Context, atlasApp *atlasv1.AtlasApp) (ctrl.Result, bool, error) {
+	log := log.FromContext(ctx)
+	steps := atlasApp.Spec.Strategy.Canary.Steps
+	desired := specRelease(atlasApp)
+
+	stable := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: atlasApp.Name, Namespace: atlasApp.Namespace}, stable); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, true, nil
+		}
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+		return result, false, err
+	}
+
+	current, ok := deploymentRelease(stable)
+	if !ok || current == desired || (!autoscalingEnabled(atlasApp) && atlasApp.Spec.Replicas == 0) {
+		return ctrl.Result{}, true, nil
+	}
+
+	status := atlasApp.Status.Canary
+	restart := status == nil || status.Version != desired.version || status.Image != desired.image ||
+		(status.Phase == atlasv1.CanaryPhaseAborted && status.Generation != atlasApp.Generation)
+	if restart {
+		log.Info("Starting canary", "version", desired.version, "stableVersion", current.version)
+		r.Recorder.Eventf(atlasApp, corev1.EventTypeNormal, "CanaryStarted",
+			"Rolling out version %s as canary next to version %s", desired.version, current.version)
+		status = &atlasv1.CanaryStatus{
+			Phase:         atlasv1.CanaryPhaseProgressing,
+			Version:       desired.version,
+			Image:         desired.image,
+			StableVersion: current.version,
+			StableImage:   current.image,
+		}
+		atlasApp.Status.Canary = status
+		atlasApp.Status.HealthCheck = nil
+	}
+
+	switch status.Phase {
+	case atlasv1.CanaryPhaseAborted:
+		// Keep the stable version until the spec changes
+		if err := r.restoreStable(ctx, atlasApp, current, true); err != nil {
+			result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+			return result, false, err
+		}
+		_, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseRolloutAborted, false, status.Message)
+		return ctrl.Result{}, false, err
+
+	case atlasv1.CanaryPhasePromoted:
+		return ctrl.Result{}, true, nil
+	}
+
+	status.Generation = atlasApp.Generation
+	if int(status.Step) >= len(steps) {
+		return r.promoteCanary(ctx, atlasApp, desired)
+	}
+
+	// Replica changes between steps are intended, not drift
+	step := steps[status.Step]
+	stepChanged := restart || status.Weight != step.Weight
+	status.Weight = step.Weight
+	replicas := atlasApp.Spec.Replicas
+	if autoscalingEnabled(atlasApp) && stable.Spec.Replicas != nil {
+		replicas = *stable.Spec.Replicas
+	}
+	canaryCount, stableCount := canaryReplicas(replicas, step.Weight)
+	stableReplicas := &stableCount
+	if autoscalingEnabled(atlasApp) {
+		// The HPA keeps scaling the stable Deployment, the canary runs next to it
+		stableReplicas = nil
+	}
+
+	stableDeployment := desiredDeployment(atlasApp, atlasApp.Name, current, stableReplicas, nil)
+	if err := r.applyDeployment(ctx, atlasApp, stableDeployment, !stepChanged); err != nil {
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+		return result, false, err
+	}
+	canaryDeployment := desiredDeployment(atlasApp, canaryName(atlasApp), desired, &canaryCount, map[string]string{trackLabel: canaryTrack})
+	if err := r.applyDeployment(ctx, atlasApp, canaryDeployment, !stepChanged); err != nil {
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+		return result, false, err
+	}
+	if err := r.reconcileService(ctx, atlasApp, true); err != nil {
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+		return result, false, err
+	}
+
+	atlasApp.Status.ReadyReplicas = stableDeployment.Status.ReadyReplicas + canaryDeployment.Status.ReadyReplicas
+	atlasApp.Status.TotalReplicas = stableDeployment.Status.Replicas + canaryDeployment.Status.Replicas
+
+	stepName := fmt.Sprintf("Canary step %d/%d (%d%%)", status.Step+1, len(steps), step.Weight)
+	if progressDeadlineExceeded(canaryDeployment) {
+		return r.abortCanary(ctx, atlasApp, current, fmt.Sprintf("%s: canary Deployment exceeded its progress deadline", stepName))
+	}
+	if !deploymentRolledOut(canaryDeployment) {
+		status.Message = fmt.Sprintf("%s: waiting for %d canary replicas", stepName, canaryCount)
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseDeploying, false, status.Message)
+		return result, false, err
+	}
+
+	if atlasApp.Spec.HealthCheckPath != "" {
+		healthy, err := r.performHealthCheck(ctx, atlasApp, canaryDeployment.Name)
+		if err != nil {
+			result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, fmt.Sprintf("Health check failed: %v", err))
+			return result, false, err
+		}
+		if !healthy {
+			if healthCheckFailed(atlasApp) {
+				return r.abortCanary(ctx, atlasApp, current, fmt.Sprintf("%s: canary failed its health checks", stepName))
+			}
+			status.Message = fmt.Sprintf("%s: waiting for canary health checks to pass", stepName)
+			if _, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseVerifying, false, status.Message); err != nil {
+				return ctrl.Result{}, false, err
+			}
+			return ctrl.Result{RequeueAfter: healthCheckInterval(atlasApp)}, false, nil
+		}
+	}
+
+	now := metav1.Now()
+	if status.StepHealthyAt == nil {
+		status.StepHealthyAt = &now
+	}
+	if remaining := time.Duration(step.PauseSeconds)*time.Second - now.Sub(status.StepHealthyAt.Time); remaining > 0 {
+		status.Message = fmt.Sprintf("%s: healthy, paused for %s", stepName, remaining.Round(time.Second))
+		if _, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseDeploying, false, status.Message); err != nil {
+			return ctrl.Result{}, false, err
+		}
+
+		// Keep checking the canary while it is paused
+		if atlasApp.Spec.HealthCheckPath != "" && healthCheckInterval(atlasApp) < remaining {
+			remaining = healthCheckInterval(atlasApp)
+		}
+		return ctrl.Result{RequeueAfter: remaining}, false, nil
+	}
+
+	log.Info("Canary step passed", "step", status.Step+1, "weight", step.Weight, "version", desired.version)
+	status.Step++
+	status.StepHealthyAt = nil
+	if int(status.Step) >= len(steps) {
+		return r.promoteCanary(ctx, atlasApp, desired)
+	}
+
+	// Every step is verified on its own
+	atlasApp.Status.HealthCheck = nil
+	status.Message = fmt.Sprintf("%s passed", stepName)
+	if _, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseDeploying, false, status.Message); err != nil {
+		return ctrl.Result{}, false, err
+	}
+	return ctrl.Result{Requeue: true}, false, nil
+}
+
+// promoteCanary rolls the canary release out to the stable Deployment. The
+// canary Deployment is removed once the stable Deployment is ready.
+func (r *AtlasAppReconciler) promoteCanary(ctx context.Context, atlasApp *atlasv1.AtlasApp, desired release) (ctrl.Result, bool, error) {
+	status := atlasApp.Status.Canary
+	status.Phase = atlasv1.CanaryPhasePromoted
+	status.Weight = 100
+	status.Message = fmt.Sprintf("Version %s promoted after %d canary steps", desired.version, status.Step)
+
+	log.FromContext(ctx).Info("Promoting canary", "version", desired.version)
+	r.Recorder.Event(atlasApp, corev1.EventTypeNormal, "CanaryPromoted", status.Message)
+
+	deployment := desiredDeployment(atlasApp, atlasApp.Name, desired, activeReplicas(atlasApp), nil)
+	if err := r.applyDeployment(ctx, atlasApp, deployment, false); err != nil {
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+		return result, false, err
+	}
+
+	result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseDeploying, false, status.Message)
+	return result, false, err
+}
+
+// abortCanary restores the stable Deployment and removes the canary
+func (r *AtlasAppReconciler) abortCanary(ctx context.Context, atlasApp *atlasv1.AtlasApp, stable release, reason string) (ctrl.Result, bool, error) {
+	status := atlasApp.Status.Canary
+	status.Phase = atlasv1.CanaryPhaseAborted
+	status.Weight = 0
+	status.StepHealthyAt = nil
+	status.Message = fmt.Sprintf("Version %s aborted, kept version %s. %s", status.Version, stable.version, reason)
+
+	log.FromContext(ctx).Info("Aborting canary", "version", status.Version, "reason", reason)
+	r.Recorder.Event(atlasApp, corev1.EventTypeWarning, "CanaryAborted", status.Message)
+
+	if err := r.restoreStable(ctx, atlasApp, stable, false); err != nil {
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+		return result, false, err
+	}
+
+	// Spec changes retry the rollout, no need to poll
+	_, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseRolloutAborted, false, status.Message)
+	return ctrl.Result{}, false, err
+}
+
+// restoreStable scales the stable Deployment back up and removes the canary
+func (r *AtlasAppReconciler) restoreStable(ctx context.Context, atlasApp *atlasv1.AtlasApp, stable release, detectDrift bool) error {
+	deployment := desiredDeployment(atlasApp, atlasApp.Name, stable, activeReplicas(atlasApp), nil)
+	if err := r.applyDeployment(ctx, atlasApp, deployment, detectDrift); err != nil {
+		return err
+	}
+
+	// The stable release is what is rolled out, so going back to it is no downgrade
+	atlasApp.Status.DeployedVersion = stable.version
+	atlasApp.Status.DeployedMigrationId = stable.migrationId
+	atlasApp.Status.ReadyReplicas = deployment.Status.ReadyReplicas
+	atlasApp.Status.TotalReplicas = deployment.Status.Replicas
+
+	return r.cleanupCanary(ctx, atlasApp)
+}
+
+// cleanupCanary removes the canary Deployment of the AtlasApp, if any
+func (r *AtlasAppReconciler) cleanupCanary(ctx context.Context, atlasApp *atlasv1.AtlasApp) error {
+	return r.deleteOwnedDeployment(ctx, atlasApp, canaryName(atlasApp))
+}
+
+// deleteOwnedDeployment removes a Deployment controlled by the AtlasApp, if it exists
+func (r *AtlasAppReconciler) deleteOwnedDeployment(ctx context.Context, atlasApp *atlasv1.AtlasApp, name string) error {
+	deployment := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: atlasApp.Namespace}, deployment)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(deployment, atlasApp) {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Deleting Deployment", "Deployment.Name", deployment.Name)
+	if err := r.Delete(ctx, deployment, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
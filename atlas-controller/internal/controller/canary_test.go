@@ -0,0 +1,131 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+var _ = Describe("Canary rollouts", func() {
+	var (
+		r         *AtlasAppReconciler
+		atlasApp  *atlasv1.AtlasApp
+		stableKey types.NamespacedName
+		canaryKey types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		r = newAppReconciler()
+		atlasApp = newApp(newNamespace("canary"), "dev", "1.0.0")
+		atlasApp.Spec.Replicas = 4
+		atlasApp.Spec.HealthCheckPath = ""
+		atlasApp.Spec.Strategy = &atlasv1.RolloutStrategy{Canary: &atlasv1.CanaryStrategy{
+			Steps: []atlasv1.CanaryStep{{Weight: 25}, {Weight: 50}},
+		}}
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		stableKey = client.ObjectKeyFromObject(atlasApp)
+		canaryKey = types.NamespacedName{Name: "atlas-canary", Namespace: atlasApp.Namespace}
+
+		// The first rollout has nothing to canary against
+		reconcileApp(r, atlasApp)
+		Expect(k8sClient.Get(ctx, canaryKey, &appsv1.Deployment{})).NotTo(Succeed())
+		markDeploymentReady(stableKey)
+
+		latest := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, stableKey, latest)).To(Succeed())
+		latest.Spec.Version = "1.1.0"
+		Expect(k8sClient.Update(ctx, latest)).To(Succeed())
+	})
+
+	It("splits the replicas by the step weight", func() {
+		for _, split := range []struct{ replicas, weight, canary, stable int32 }{
+			{4, 25, 1, 3},
+			{4, 50, 2, 2},
+			{3, 10, 1, 2},
+			{1, 50, 1, 0},
+			{10, 100, 10, 0},
+		} {
+			canary, stable := canaryReplicas(split.replicas, split.weight)
+			Expect([]int32{canary, stable}).To(Equal([]int32{split.canary, split.stable}), "%d replicas at %d%%", split.replicas, split.weight)
+		}
+	})
+
+	It("runs the new version next to the stable one, step by step", func() {
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Canary).NotTo(BeNil())
+		Expect(updated.Status.Canary.Phase).To(Equal(atlasv1.CanaryPhaseProgressing))
+		Expect(updated.Status.Canary.Weight).To(Equal(int32(25)))
+
+		canary := getDeployment(canaryKey)
+		Expect(*canary.Spec.Replicas).To(Equal(int32(1)))
+		Expect(canary.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.1.0"))
+		stable := getDeployment(stableKey)
+		Expect(*stable.Spec.Replicas).To(Equal(int32(3)))
+		Expect(stable.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.0.0"))
+
+		markDeploymentReady(canaryKey)
+		_, updated = reconcileApp(r, atlasApp)
+		Expect(updated.Status.Canary.Step).To(Equal(int32(1)))
+
+		_, updated = reconcileApp(r, atlasApp)
+		Expect(updated.Status.Canary.Weight).To(Equal(int32(50)))
+		Expect(*getDeployment(canaryKey).Spec.Replicas).To(Equal(int32(2)))
+		Expect(*getDeployment(stableKey).Spec.Replicas).To(Equal(int32(2)))
+
+		markDeploymentReady(canaryKey)
+		reconcileApp(r, atlasApp)
+		_, updated = reconcileApp(r, atlasApp)
+		Expect(updated.Status.Canary.Phase).To(Equal(atlasv1.CanaryPhasePromoted))
+		stable = getDeployment(stableKey)
+		Expect(*stable.Spec.Replicas).To(Equal(int32(4)))
+		Expect(stable.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.1.0"))
+	})
+
+	It("aborts when the canary stops making progress", func() {
+		reconcileApp(r, atlasApp)
+
+		canary := getDeployment(canaryKey)
+		canary.Status.Conditions = []appsv1.DeploymentCondition{{
+			Type:               appsv1.DeploymentProgressing,
+			Status:             corev1.ConditionFalse,
+			Reason:             "ProgressDeadlineExceeded",
+			LastUpdateTime:     metav1.Now(),
+			LastTransitionTime: metav1.Now(),
+		}}
+		Expect(k8sClient.Status().Update(ctx, canary)).To(Succeed())
+
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseRolloutAborted))
+		Expect(updated.Status.Canary.Phase).To(Equal(atlasv1.CanaryPhaseAborted))
+		Expect(updated.Status.DeployedVersion).To(Equal("1.0.0"))
+		Expect(errors.IsNotFound(k8sClient.Get(ctx, canaryKey, &appsv1.Deployment{}))).To(BeTrue())
+
+		stable := getDeployment(stableKey)
+		Expect(*stable.Spec.Replicas).To(Equal(int32(4)))
+		Expect(stable.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.0.0"))
+	})
+})
@@ -0,0 +1,136 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+// Condition reasons used by the AtlasApp controller
+const (
+	reasonReady               = "Ready"
+	reasonRolloutComplete     = "RolloutComplete"
+	reasonRollingOut          = "RollingOut"
+	reasonReplicasAvailable   = "MinimumReplicasAvailable"
+	reasonReplicasUnavailable = "ReplicasUnavailable"
+	reasonHealthCheckPending  = "HealthCheckPending"
+	reasonHealthCheckFailed   = "HealthCheckFailed"
+	reasonReconcileError      = "ReconcileError"
+	reasonAsExpected          = "AsExpected"
+	reasonAwaitingApproval    = "AwaitingApproval"
+	reasonApproved            = "Approved"
+	reasonNotRequired         = "NotRequired"
+	reasonPromoted            = "PromotedToNextEnvironment"
+	reasonMigrationSucceeded  = "MigrationSucceeded"
+	reasonMigrationRunning    = "MigrationRunning"
+	reasonMigrationFailed     = "MigrationFailed"
+	reasonDowngradeRejected   = "DowngradeRejected"
+	reasonRolloutAborted      = "RolloutAborted"
+	reasonRolledBack          = "RolledBack"
+)
+
+// setCondition records a condition for the generation currently being reconciled
+func setCondition(atlasApp *atlasv1.AtlasApp, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&atlasApp.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: atlasApp.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// setPhaseConditions derives the Available, Progressing, Degraded and
+// PendingApproval conditions from the phase the reconciler ended up in
+func setPhaseConditions(atlasApp *atlasv1.AtlasApp, phase, message string) {
+	switch phase {
+	case atlasv1.PhaseReady:
+		setCondition(atlasApp, atlasv1.ConditionAvailable, metav1.ConditionTrue, reasonReady, message)
+		setCondition(atlasApp, atlasv1.ConditionProgressing, metav1.ConditionFalse, reasonRolloutComplete, message)
+		setCondition(atlasApp, atlasv1.ConditionDegraded, metav1.ConditionFalse, reasonAsExpected, message)
+
+	case atlasv1.PhaseMigrating:
+		setCondition(atlasApp, atlasv1.ConditionProgressing, metav1.ConditionTrue, reasonMigrationRunning, message)
+		setCondition(atlasApp, atlasv1.ConditionDegraded, metav1.ConditionFalse, reasonAsExpected, message)
+
+	case atlasv1.PhaseMigrationFailed:
+		// The previous version keeps serving, the new one is not rolled out
+		setCondition(atlasApp, atlasv1.ConditionProgressing, metav1.ConditionFalse, reasonMigrationFailed, message)
+		setCondition(atlasApp, atlasv1.ConditionDegraded, metav1.ConditionTrue, reasonMigrationFailed, message)
+
+	case atlasv1.PhaseDeploying:
+		if atlasApp.Status.ReadyReplicas > 0 {
+			setCondition(atlasApp, atlasv1.ConditionAvailable, metav1.ConditionTrue, reasonReplicasAvailable, message)
+		} else {
+			setCondition(atlasApp, atlasv1.ConditionAvailable, metav1.ConditionFalse, reasonReplicasUnavailable, message)
+		}
+		setCondition(atlasApp, atlasv1.ConditionProgressing, metav1.ConditionTrue, reasonRollingOut, message)
+		setCondition(atlasApp, atlasv1.ConditionDegraded, metav1.ConditionFalse, reasonAsExpected, message)
+
+	case atlasv1.PhaseVerifying:
+		setCondition(atlasApp, atlasv1.ConditionAvailable, metav1.ConditionFalse, reasonHealthCheckPending, message)
+		setCondition(atlasApp, atlasv1.ConditionProgressing, metav1.ConditionTrue, reasonHealthCheckPending, message)
+		setCondition(atlasApp, atlasv1.ConditionDegraded, metav1.ConditionFalse, reasonAsExpected, message)
+
+	case atlasv1.PhaseUnhealthy:
+		setCondition(atlasApp, atlasv1.ConditionAvailable, metav1.ConditionFalse, reasonHealthCheckFailed, message)
+		setCondition(atlasApp, atlasv1.ConditionProgressing, metav1.ConditionFalse, reasonHealthCheckFailed, message)
+		setCondition(atlasApp, atlasv1.ConditionDegraded, metav1.ConditionTrue, reasonHealthCheckFailed, message)
+
+	case atlasv1.PhaseRolloutAborted:
+		// The previous version was restored and keeps serving
+		setCondition(atlasApp, atlasv1.ConditionProgressing, metav1.ConditionFalse, reasonRolloutAborted, message)
+		setCondition(atlasApp, atlasv1.ConditionDegraded, metav1.ConditionTrue, reasonRolloutAborted, message)
+
+	case atlasv1.PhaseRolledBack:
+		// The last known-good version serves instead of the spec version
+		if atlasApp.Status.ReadyReplicas > 0 {
+			setCondition(atlasApp, atlasv1.ConditionAvailable, metav1.ConditionTrue, reasonRolledBack, message)
+		} else {
+			setCondition(atlasApp, atlasv1.ConditionAvailable, metav1.ConditionFalse, reasonRolledBack, message)
+		}
+		setCondition(atlasApp, atlasv1.ConditionProgressing, metav1.ConditionFalse, reasonRolledBack, message)
+		setCondition(atlasApp, atlasv1.ConditionDegraded, metav1.ConditionTrue, reasonRolledBack, message)
+
+	case atlasv1.PhaseDowngradeRejected:
+		// The previous version keeps serving, the downgrade is not rolled out
+		setCondition(atlasApp, atlasv1.ConditionProgressing, metav1.ConditionFalse, reasonDowngradeRejected, message)
+		setCondition(atlasApp, atlasv1.ConditionDegraded, metav1.ConditionTrue, reasonDowngradeRejected, message)
+
+	case atlasv1.PhaseFailed:
+		// Availability is left untouched, the previous rollout may still be serving
+		setCondition(atlasApp, atlasv1.ConditionProgressing, metav1.ConditionFalse, reasonReconcileError, message)
+		setCondition(atlasApp, atlasv1.ConditionDegraded, metav1.ConditionTrue, reasonReconcileError, message)
+
+	case atlasv1.PhasePendingApproval:
+		setCondition(atlasApp, atlasv1.ConditionPendingApproval, metav1.ConditionTrue, reasonAwaitingApproval, message)
+		setCondition(atlasApp, atlasv1.ConditionProgressing, metav1.ConditionFalse, reasonAwaitingApproval, message)
+		return
+	}
+
+	if approval := atlasApp.Status.Approval; approval != nil {
+		setCondition(atlasApp, atlasv1.ConditionPendingApproval, metav1.ConditionFalse, reasonApproved,
+			fmt.Sprintf("Version %s with migration %d approved by %s", approval.Version, approval.MigrationId, approval.ApprovedBy))
+	} else {
+		setCondition(atlasApp, atlasv1.ConditionPendingApproval, metav1.ConditionFalse, reasonNotRequired, "Deployment does not require approval")
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+var _ = Describe("Status conditions", func() {
+	var (
+		r        *AtlasAppReconciler
+		atlasApp *atlasv1.AtlasApp
+		key      types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		r = newAppReconciler()
+		atlasApp = newApp(newNamespace("conditions"), "dev", "1.0.0")
+		atlasApp.Spec.HealthCheckPath = ""
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		key = client.ObjectKeyFromObject(atlasApp)
+	})
+
+	expectCondition := func(app *atlasv1.AtlasApp, conditionType string, status metav1.ConditionStatus, reason string) {
+		condition := meta.FindStatusCondition(app.Status.Conditions, conditionType)
+		Expect(condition).NotTo(BeNil(), conditionType)
+		Expect(condition.Status).To(Equal(status), conditionType)
+		Expect(condition.Reason).To(Equal(reason), conditionType)
+		Expect(condition.ObservedGeneration).To(Equal(app.Generation), conditionType)
+	}
+
+	It("reports a rollout in progress and then an available app", func() {
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseDeploying))
+		Expect(updated.Status.ObservedGeneration).To(Equal(updated.Generation))
+		expectCondition(updated, atlasv1.ConditionAvailable, metav1.ConditionFalse, reasonReplicasUnavailable)
+		expectCondition(updated, atlasv1.ConditionProgressing, metav1.ConditionTrue, reasonRollingOut)
+		expectCondition(updated, atlasv1.ConditionDegraded, metav1.ConditionFalse, reasonAsExpected)
+		expectCondition(updated, atlasv1.ConditionPendingApproval, metav1.ConditionFalse, reasonNotRequired)
+
+		markDeploymentReady(key)
+		_, updated = reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseReady))
+		expectCondition(updated, atlasv1.ConditionAvailable, metav1.ConditionTrue, reasonReady)
+		expectCondition(updated, atlasv1.ConditionProgressing, metav1.ConditionFalse, reasonRolloutComplete)
+		expectCondition(updated, atlasv1.ConditionDegraded, metav1.ConditionFalse, reasonAsExpected)
+	})
+
+	It("follows the generation of the spec", func() {
+		reconcileApp(r, atlasApp)
+		markDeploymentReady(key)
+		reconcileApp(r, atlasApp)
+
+		latest := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, key, latest)).To(Succeed())
+		latest.Spec.Version = "1.1.0"
+		Expect(k8sClient.Update(ctx, latest)).To(Succeed())
+
+		// The pods of the new version are not ready yet
+		deployment := getDeployment(key)
+		deployment.Status.ReadyReplicas = 0
+		deployment.Status.AvailableReplicas = 0
+		Expect(k8sClient.Status().Update(ctx, deployment)).To(Succeed())
+
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Generation).To(Equal(int64(2)))
+		Expect(updated.Status.ObservedGeneration).To(Equal(int64(2)))
+		expectCondition(updated, atlasv1.ConditionAvailable, metav1.ConditionFalse, reasonReplicasUnavailable)
+		expectCondition(updated, atlasv1.ConditionProgressing, metav1.ConditionTrue, reasonRollingOut)
+	})
+
+	It("keeps availability untouched when reconciling fails", func() {
+		app := &atlasv1.AtlasApp{}
+		setPhaseConditions(app, atlasv1.PhaseReady, "")
+		setPhaseConditions(app, atlasv1.PhaseFailed, "boom")
+
+		expectCondition(app, atlasv1.ConditionAvailable, metav1.ConditionTrue, reasonReady)
+		expectCondition(app, atlasv1.ConditionProgressing, metav1.ConditionFalse, reasonReconcileError)
+		expectCondition(app, atlasv1.ConditionDegraded, metav1.ConditionTrue, reasonReconcileError)
+	})
+})
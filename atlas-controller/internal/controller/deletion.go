@@ -0,0 +1,199 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+const (
+	// atlasAppFinalizer holds AtlasApp deletion until its hooks ran and its downstream apps were handled
+	atlasAppFinalizer = "atlas.io/finalizer"
+
+	// hookForLabel carries the AtlasApp name on hook pods, see migrationForLabel
+	hookForLabel = "atlas.io/hook-for"
+
+	// hookNameLabel carries the hook name on hook Jobs
+	hookNameLabel = "atlas.io/hook"
+)
+
+// deletionRequested triggers a reconcile once an AtlasApp is marked for deletion
+var deletionRequested = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return e.ObjectOld.GetDeletionTimestamp().IsZero() && !e.ObjectNew.GetDeletionTimestamp().IsZero()
+	},
+}
+
+// ensureFinalizer adds the finalizer to an AtlasApp that has none yet
+func (r *AtlasAppReconciler) ensureFinalizer(ctx context.Context, atlasApp *atlasv1.AtlasApp) error {
+	if controllerutil.ContainsFinalizer(atlasApp, atlasAppFinalizer) {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Adding finalizer")
+	controllerutil.AddFinalizer(atlasApp, atlasAppFinalizer)
+	return r.Update(ctx, atlasApp)
+}
+
+// reconcileDelete runs the pre-delete hooks, applies the deletion policy and
+// releases the finalizer. The Deployment, Service and Jobs are removed by the
+// garbage collector once the AtlasApp is gone.
+func (r *AtlasAppReconciler) reconcileDelete(ctx context.Context, atlasApp *atlasv1.AtlasApp) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	if !controllerutil.ContainsFinalizer(atlasApp, atlasAppFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	for i := range atlasApp.Spec.PreDeleteHooks {
+		if result, done, err := r.runPreDeleteHook(ctx, atlasApp, &atlasApp.Spec.PreDeleteHooks[i]); err != nil || !done {
+			return result, err
+		}
+	}
+
+	if atlasApp.Spec.DeletionPolicy == atlasv1.DeletionPolicyCascade {
+		if err := r.deleteDownstreamApps(ctx, atlasApp); err != nil {
+			return r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+		}
+	}
+
+	log.Info("Removing finalizer")
+	controllerutil.RemoveFinalizer(atlasApp, atlasAppFinalizer)
+	if err := r.Update(ctx, atlasApp); err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// runPreDeleteHook runs the Job of a pre-delete hook and reports if the deletion may proceed
+func (r *AtlasAppReconciler) runPreDeleteHook(ctx context.Context, atlasApp *atlasv1.AtlasApp, hook *atlasv1.DeletionHook) (ctrl.Result, bool, error) {
+	log := log.FromContext(ctx)
+
+	job := &batchv1.Job{}
+	key := types.NamespacedName{Name: jobName(atlasApp, "-predelete-"+hook.Name), Namespace: atlasApp.Namespace}
+	err := r.Get(ctx, key, job)
+	if err != nil && errors.IsNotFound(err) {
+		job = preDeleteJob(atlasApp, hook, key)
+		if err := ctrl.SetControllerReference(atlasApp, job, r.Scheme); err != nil {
+			result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+			return result, false, err
+		}
+
+		log.Info("Running pre-delete hook", "hook", hook.Name, "Job.Name", key.Name)
+		if err := r.Create(ctx, job); err != nil {
+			// Nothing can be created in a namespace that is being deleted
+			if errors.IsForbidden(err) {
+				log.Info("Skipping pre-delete hook", "hook", hook.Name, "reason", err.Error())
+				r.Recorder.Eventf(atlasApp, corev1.EventTypeWarning, "PreDeleteHookSkipped", "Pre-delete hook %s skipped: %v", hook.Name, err)
+				return ctrl.Result{}, true, nil
+			}
+			result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+			return result, false, err
+		}
+		r.Recorder.Eventf(atlasApp, corev1.EventTypeNormal, "PreDeleteHookStarted", "Started pre-delete hook %s as Job %s", hook.Name, key.Name)
+	} else if err != nil {
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+		return result, false, err
+	} else if !metav1.IsControlledBy(job, atlasApp) {
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseDeletionBlocked, false,
+			fmt.Sprintf("Job %s already exists and is not managed by this AtlasApp", key.Name))
+		return result, false, err
+	}
+
+	if jobCondition(job, batchv1.JobComplete) != nil {
+		return ctrl.Result{}, true, nil
+	}
+
+	if cond := jobCondition(job, batchv1.JobFailed); cond != nil {
+		message := fmt.Sprintf("Pre-delete hook %s failed, Job %s: %s", hook.Name, key.Name, cond.Message)
+		if hook.IgnoreFailure {
+			log.Info("Ignoring failed pre-delete hook", "hook", hook.Name, "reason", cond.Reason)
+			r.Recorder.Event(atlasApp, corev1.EventTypeWarning, "PreDeleteHookFailed", message)
+			return ctrl.Result{}, true, nil
+		}
+
+		r.Recorder.Event(atlasApp, corev1.EventTypeWarning, "PreDeleteHookFailed", message)
+		if _, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseDeletionBlocked, false,
+			fmt.Sprintf("%s, remove the hook from spec.preDeleteHooks to delete anyway", message)); err != nil {
+			return ctrl.Result{}, false, err
+		}
+
+		// Spec changes and deleting the Job trigger a reconcile, no need to poll
+		return ctrl.Result{}, false, nil
+	}
+
+	result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseTerminating, false,
+		fmt.Sprintf("Waiting for pre-delete hook %s, Job %s", hook.Name, key.Name))
+	return result, false, err
+}
+
+// preDeleteJob returns the Job running a pre-delete hook of an AtlasApp
+func preDeleteJob(atlasApp *atlasv1.AtlasApp, hook *atlasv1.DeletionHook, key types.NamespacedName) *batchv1.Job {
+	container := jobContainer(atlasApp, "hook", hook.Image, hook.Command, hook.Args, hook.SecretRef)
+
+	labels := map[string]string{
+		appNameLabel:          atlasApp.Name,
+		hookNameLabel:         hook.Name,
+		"atlas.io/managed-by": "atlas-controller",
+	}
+	podLabels := map[string]string{
+		hookForLabel:  atlasApp.Name,
+		hookNameLabel: hook.Name,
+	}
+	return newJob(atlasApp, key, labels, podLabels, hook.BackoffLimit, hook.ActiveDeadlineSeconds, container)
+}
+
+// deleteDownstreamApps deletes the AtlasApps this one promoted to, as recorded
+// in their promoted-from annotation
+func (r *AtlasAppReconciler) deleteDownstreamApps(ctx context.Context, atlasApp *atlasv1.AtlasApp) error {
+	log := log.FromContext(ctx)
+
+	var apps atlasv1.AtlasAppList
+	if err := r.List(ctx, &apps); err != nil {
+		return err
+	}
+
+	source := client.ObjectKeyFromObject(atlasApp).String()
+	for i := range apps.Items {
+		app := &apps.Items[i]
+		if app.Annotations[atlasv1.PromotedFromAnnotation] != source || !app.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		log.Info("Deleting downstream AtlasApp", "AtlasApp.Namespace", app.Namespace, "AtlasApp.Name", app.Name)
+		if err := r.Delete(ctx, app); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		r.Recorder.Eventf(atlasApp, corev1.EventTypeNormal, "DownstreamDeleted", "Deleted downstream AtlasApp %s/%s", app.Namespace, app.Name)
+	}
+	return nil
+}
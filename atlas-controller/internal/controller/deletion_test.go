@@ -0,0 +1,171 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+var _ = Describe("AtlasApp deletion", func() {
+	var (
+		r        *AtlasAppReconciler
+		atlasApp *atlasv1.AtlasApp
+		key      types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		r = newAppReconciler()
+		atlasApp = newApp(newNamespace("deletion"), "dev", "1.0.0")
+		atlasApp.Spec.HealthCheckPath = ""
+		key = client.ObjectKeyFromObject(atlasApp)
+	})
+
+	reconcileDeletion := func() {
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	// deleteApp reconciles the AtlasApp once so it carries the finalizer, deletes
+	// it and reconciles the deletion
+	deleteApp := func() {
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Finalizers).To(ContainElement(atlasAppFinalizer))
+
+		Expect(k8sClient.Delete(ctx, atlasApp)).To(Succeed())
+		reconcileDeletion()
+	}
+
+	appExists := func(key types.NamespacedName) bool {
+		err := k8sClient.Get(ctx, key, &atlasv1.AtlasApp{})
+		if errors.IsNotFound(err) {
+			return false
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return true
+	}
+
+	Context("with a pre-delete hook", func() {
+		var jobKey types.NamespacedName
+
+		BeforeEach(func() {
+			atlasApp.Spec.PreDeleteHooks = []atlasv1.DeletionHook{{
+				Name:    "drain",
+				Command: []string{"/app/drain"},
+			}}
+			jobKey = types.NamespacedName{Name: "atlas-predelete-drain", Namespace: atlasApp.Namespace}
+		})
+
+		finishJob := func(conditionType batchv1.JobConditionType) {
+			job := &batchv1.Job{}
+			Expect(k8sClient.Get(ctx, jobKey, job)).To(Succeed())
+			job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{
+				Type:               conditionType,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.Now(),
+				Message:            "Job finished",
+			})
+			Expect(k8sClient.Status().Update(ctx, job)).To(Succeed())
+		}
+
+		It("holds the deletion until the hook Job completed", func() {
+			deleteApp()
+
+			updated := &atlasv1.AtlasApp{}
+			Expect(k8sClient.Get(ctx, key, updated)).To(Succeed())
+			Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseTerminating))
+
+			job := &batchv1.Job{}
+			Expect(k8sClient.Get(ctx, jobKey, job)).To(Succeed())
+			Expect(metav1.IsControlledBy(job, updated)).To(BeTrue())
+			Expect(job.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.0.0"))
+			Expect(job.Spec.Template.Spec.Containers[0].Command).To(Equal([]string{"/app/drain"}))
+
+			finishJob(batchv1.JobComplete)
+			reconcileDeletion()
+			Expect(appExists(key)).To(BeFalse())
+		})
+
+		It("blocks the deletion when the hook Job failed", func() {
+			deleteApp()
+			finishJob(batchv1.JobFailed)
+			reconcileDeletion()
+
+			updated := &atlasv1.AtlasApp{}
+			Expect(k8sClient.Get(ctx, key, updated)).To(Succeed())
+			Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseDeletionBlocked))
+			Expect(updated.Status.Message).To(ContainSubstring("remove the hook from spec.preDeleteHooks"))
+		})
+
+		It("proceeds past a failed hook that ignores failures", func() {
+			atlasApp.Spec.PreDeleteHooks[0].IgnoreFailure = true
+			deleteApp()
+			finishJob(batchv1.JobFailed)
+			reconcileDeletion()
+			Expect(appExists(key)).To(BeFalse())
+		})
+	})
+
+	Context("with downstream AtlasApps", func() {
+		var child, grandchild *atlasv1.AtlasApp
+
+		BeforeEach(func() {
+			child = newApp(newNamespace("deletion-child"), "stage", "1.0.0")
+			child.Annotations = map[string]string{atlasv1.PromotedFromAnnotation: key.String()}
+			Expect(k8sClient.Create(ctx, child)).To(Succeed())
+
+			grandchild = newApp(newNamespace("deletion-grandchild"), "prod", "1.0.0")
+			grandchild.Annotations = map[string]string{atlasv1.PromotedFromAnnotation: client.ObjectKeyFromObject(child).String()}
+			Expect(k8sClient.Create(ctx, grandchild)).To(Succeed())
+		})
+
+		It("keeps them by default", func() {
+			deleteApp()
+			Expect(appExists(key)).To(BeFalse())
+			Expect(appExists(client.ObjectKeyFromObject(child))).To(BeTrue())
+		})
+
+		It("deletes the apps it promoted to directly with the Cascade policy", func() {
+			atlasApp.Spec.DeletionPolicy = atlasv1.DeletionPolicyCascade
+			deleteApp()
+			Expect(appExists(key)).To(BeFalse())
+			Expect(appExists(client.ObjectKeyFromObject(child))).To(BeFalse())
+			Expect(appExists(client.ObjectKeyFromObject(grandchild))).To(BeTrue())
+		})
+	})
+
+	It("does not hand the deletion settings on to promoted apps", func() {
+		atlasApp.Spec.DeletionPolicy = atlasv1.DeletionPolicyCascade
+		atlasApp.Spec.PreDeleteHooks = []atlasv1.DeletionHook{{Name: "drain", Command: []string{"/app/drain"}}}
+		pipeline := atlasv1.AtlasPipelineSpec{Stages: []atlasv1.PipelineStage{{Name: "dev"}, {Name: "prod"}}}
+
+		spec := promotedAppSpec(atlasApp, &pipeline, &pipeline.Stages[1])
+		Expect(spec.DeletionPolicy).To(BeEmpty())
+		Expect(spec.PreDeleteHooks).To(BeEmpty())
+	})
+})
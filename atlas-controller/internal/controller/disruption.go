@@ -0,0 +1,97 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+// topologySpreadConstraints returns the spread constraints of the application
+// pods. Constraints without a label selector select all pods of the AtlasApp,
+// including canary and blue/green pods.
+func topologySpreadConstraints(atlasApp *atlasv1.AtlasApp) []corev1.TopologySpreadConstraint {
+	var constraints []corev1.TopologySpreadConstraint
+	for _, constraint := range atlasApp.Spec.TopologySpreadConstraints {
+		if constraint.LabelSelector == nil {
+			constraint.LabelSelector = &metav1.LabelSelector{MatchLabels: selectorLabels(atlasApp)}
+		}
+		constraints = append(constraints, constraint)
+	}
+	return constraints
+}
+
+// desiredDisruptionBudget returns the PDB covering all pods of the AtlasApp
+func desiredDisruptionBudget(atlasApp *atlasv1.AtlasApp) *policyv1.PodDisruptionBudget {
+	spec := atlasApp.Spec.DisruptionBudget
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      atlasApp.Name,
+			Namespace: atlasApp.Namespace,
+			Labels: map[string]string{
+				"app":                  "atlas",
+				appNameLabel:           atlasApp.Name,
+				"atlas.io/environment": atlasApp.Spec.Environment,
+				"atlas.io/managed-by":  "atlas-controller",
+			},
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector:       &metav1.LabelSelector{MatchLabels: selectorLabels(atlasApp)},
+			MinAvailable:   spec.MinAvailable,
+			MaxUnavailable: spec.MaxUnavailable,
+		},
+	}
+	if pdb.Spec.MinAvailable == nil && pdb.Spec.MaxUnavailable == nil {
+		maxUnavailable := intstr.FromInt32(1)
+		pdb.Spec.MaxUnavailable = &maxUnavailable
+	}
+	return pdb
+}
+
+// reconcileDisruptionBudget applies the PDB of the AtlasApp, or removes it once
+// spec.disruptionBudget is unset. Changes are recorded as drift only if
+// detectDrift is set.
+func (r *AtlasAppReconciler) reconcileDisruptionBudget(ctx context.Context, atlasApp *atlasv1.AtlasApp, detectDrift bool) error {
+	if atlasApp.Spec.DisruptionBudget != nil {
+		return r.applyOwned(ctx, atlasApp, desiredDisruptionBudget(atlasApp), &policyv1.PodDisruptionBudget{}, detectDrift)
+	}
+
+	pdb := &policyv1.PodDisruptionBudget{}
+	err := r.Get(ctx, types.NamespacedName{Name: atlasApp.Name, Namespace: atlasApp.Namespace}, pdb)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(pdb, atlasApp) {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Deleting PodDisruptionBudget", "PodDisruptionBudget.Name", pdb.Name)
+	if err := r.Delete(ctx, pdb); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+var _ = Describe("Disruption budget and topology spread", func() {
+	var (
+		r        *AtlasAppReconciler
+		atlasApp *atlasv1.AtlasApp
+		key      types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		r = newAppReconciler()
+		atlasApp = newApp(newNamespace("disruption"), "prod", "1.0.0")
+		atlasApp.Spec.Replicas = 3
+		atlasApp.Spec.HealthCheckPath = ""
+		key = client.ObjectKeyFromObject(atlasApp)
+	})
+
+	getBudget := func() *policyv1.PodDisruptionBudget {
+		pdb := &policyv1.PodDisruptionBudget{}
+		Expect(k8sClient.Get(ctx, key, pdb)).To(Succeed())
+		return pdb
+	}
+
+	It("covers the pods of the Deployment with the PDB", func() {
+		minAvailable := intstr.FromString("50%")
+		atlasApp.Spec.DisruptionBudget = &atlasv1.DisruptionBudgetSpec{MinAvailable: &minAvailable}
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		reconcileApp(r, atlasApp)
+
+		pdb := getBudget()
+		Expect(pdb.Spec.MinAvailable).To(Equal(&minAvailable))
+		Expect(pdb.Spec.MaxUnavailable).To(BeNil())
+		Expect(pdb.Spec.Selector.MatchLabels).To(Equal(getDeployment(key).Spec.Selector.MatchLabels))
+	})
+
+	It("allows one unavailable pod when the budget sets no limit", func() {
+		atlasApp.Spec.DisruptionBudget = &atlasv1.DisruptionBudgetSpec{}
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		reconcileApp(r, atlasApp)
+
+		Expect(getBudget().Spec.MaxUnavailable).To(Equal(&intstr.IntOrString{Type: intstr.Int, IntVal: 1}))
+	})
+
+	It("removes the PDB once the budget is unset", func() {
+		atlasApp.Spec.DisruptionBudget = &atlasv1.DisruptionBudgetSpec{}
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		_, updated := reconcileApp(r, atlasApp)
+
+		updated.Spec.DisruptionBudget = nil
+		Expect(k8sClient.Update(ctx, updated)).To(Succeed())
+		reconcileApp(r, atlasApp)
+		Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &policyv1.PodDisruptionBudget{}))).To(BeTrue())
+	})
+
+	It("spreads all pods of the AtlasApp and passes the affinity on", func() {
+		atlasApp.Spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{{
+			MaxSkew:           1,
+			TopologyKey:       corev1.LabelTopologyZone,
+			WhenUnsatisfiable: corev1.ScheduleAnyway,
+		}}
+		atlasApp.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchExpressions: []corev1.NodeSelectorRequirement{{
+						Key:      "kubernetes.io/arch",
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   []string{"arm64"},
+					}},
+				}},
+			},
+		}}
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		reconcileApp(r, atlasApp)
+
+		podSpec := getDeployment(key).Spec.Template.Spec
+		Expect(podSpec.TopologySpreadConstraints).To(HaveLen(1))
+		constraint := podSpec.TopologySpreadConstraints[0]
+		Expect(constraint.TopologyKey).To(Equal(corev1.LabelTopologyZone))
+		Expect(constraint.LabelSelector).NotTo(BeNil())
+		Expect(constraint.LabelSelector.MatchLabels).To(Equal(selectorLabels(atlasApp)))
+		Expect(podSpec.Affinity).To(Equal(atlasApp.Spec.Affinity))
+	})
+})
@@ -0,0 +1,82 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+// downgradeAnnotations are removed from the AtlasApp once the downgrade they allow has been rolled out
+var downgradeAnnotations = []string{
+	atlasv1.AllowDowngradeAnnotation,
+	atlasv1.AllowDowngradeToAnnotation,
+}
+
+// checkDowngrade returns an error if the spec lowers the version or migration ID
+// last rolled out and the AtlasApp has no downgrade override for the spec
+// release. This backs up the validating webhook, which only sees changes made
+// while it is enabled. An override is one-shot, it is removed once its release
+// has been rolled out.
+func (r *AtlasAppReconciler) checkDowngrade(ctx context.Context, atlasApp *atlasv1.AtlasApp) error {
+	if atlasApp.Status.DeployedVersion == "" {
+		return nil
+	}
+
+	if version, migrationId, ok := atlasv1.DowngradeTarget(atlasApp); ok &&
+		version == atlasApp.Status.DeployedVersion && migrationId == atlasApp.Status.DeployedMigrationId {
+		log.FromContext(ctx).Info("Removing downgrade override", "version", version, "migrationId", migrationId)
+		if err := r.removeAnnotations(ctx, atlasApp, downgradeAnnotations); err != nil {
+			return err
+		}
+	}
+
+	err := atlasv1.CheckDowngrade(atlasApp.Status.DeployedVersion, atlasApp.Status.DeployedMigrationId,
+		atlasApp.Spec.Version, atlasApp.Spec.MigrationId)
+	if err == nil {
+		return nil
+	}
+
+	reason, ok := atlasv1.DowngradeOverride(atlasApp, atlasApp.Spec.Version, atlasApp.Spec.MigrationId)
+	if !ok {
+		return err
+	}
+
+	log.FromContext(ctx).Info("Allowing downgrade", "downgrade", err.Error(), "reason", reason)
+	r.Recorder.Eventf(atlasApp, corev1.EventTypeWarning, "DowngradeAllowed", "%s: %s", err.Error(), reason)
+	return nil
+}
+
+// handleDowngradeRejected keeps the current rollout and reports the rejected downgrade
+func (r *AtlasAppReconciler) handleDowngradeRejected(ctx context.Context, atlasApp *atlasv1.AtlasApp, err error) (ctrl.Result, error) {
+	message := fmt.Sprintf("%s, %s", err.Error(), atlasv1.DowngradeHint(atlasApp.Spec.Version, atlasApp.Spec.MigrationId))
+	log.FromContext(ctx).Info("Rejecting downgrade", "reason", err.Error())
+	r.Recorder.Event(atlasApp, corev1.EventTypeWarning, reasonDowngradeRejected, message)
+
+	if _, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseDowngradeRejected, false, message); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Spec and annotation changes trigger a reconcile, no need to poll
+	return ctrl.Result{}, nil
+}
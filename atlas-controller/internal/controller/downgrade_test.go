@@ -0,0 +1,117 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+var _ = Describe("Downgrade protection", func() {
+	var (
+		r        *AtlasAppReconciler
+		atlasApp *atlasv1.AtlasApp
+	)
+
+	BeforeEach(func() {
+		r = newAppReconciler()
+		atlasApp = newApp(newNamespace("downgrade"), "dev", "1.22.0")
+		atlasApp.Spec.MigrationId = 6
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.DeployedVersion).To(Equal("1.22.0"))
+	})
+
+	setVersion := func(version string) {
+		latest := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(atlasApp), latest)).To(Succeed())
+		latest.Spec.Version = version
+		Expect(k8sClient.Update(ctx, latest)).To(Succeed())
+	}
+
+	deployedImage := func() string {
+		deployment := &appsv1.Deployment{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(atlasApp), deployment)).To(Succeed())
+		return deployment.Spec.Template.Spec.Containers[0].Image
+	}
+
+	It("compares versions by semver and migration IDs as numbers", func() {
+		Expect(atlasv1.CheckDowngrade("1.10.0", 6, "1.9.0", 6)).To(HaveOccurred())
+		Expect(atlasv1.CheckDowngrade("1.9.0", 6, "1.10.0", 6)).To(Succeed())
+		Expect(atlasv1.CheckDowngrade("1.9.0", 6, "1.10.0", 5)).To(HaveOccurred())
+		Expect(atlasv1.CheckDowngrade("latest", 6, "stable", 6)).To(Succeed())
+	})
+
+	It("refuses a lower version without an override", func() {
+		setVersion("1.21.0")
+
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseDowngradeRejected))
+		Expect(updated.Status.Message).To(ContainSubstring("atlas.io/allow-downgrade-to to 1.21.0/6"))
+		Expect(deployedImage()).To(Equal("nginx:1.22.0"))
+	})
+
+	It("refuses a downgrade to another release than the override names", func() {
+		annotate(atlasApp, map[string]string{
+			atlasv1.AllowDowngradeAnnotation:   "INC-4711",
+			atlasv1.AllowDowngradeToAnnotation: "1.20.0/6",
+		})
+		setVersion("1.21.0")
+
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseDowngradeRejected))
+		Expect(deployedImage()).To(Equal("nginx:1.22.0"))
+	})
+
+	It("allows the downgrade the override names and removes it once rolled out", func() {
+		annotate(atlasApp, map[string]string{
+			atlasv1.AllowDowngradeAnnotation:   "INC-4711",
+			atlasv1.AllowDowngradeToAnnotation: "1.21.0/6",
+		})
+		setVersion("1.21.0")
+
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).NotTo(Equal(atlasv1.PhaseDowngradeRejected))
+		Expect(updated.Status.DeployedVersion).To(Equal("1.21.0"))
+		Expect(deployedImage()).To(Equal("nginx:1.21.0"))
+
+		_, updated = reconcileApp(r, atlasApp)
+		Expect(updated.Annotations).NotTo(HaveKey(atlasv1.AllowDowngradeAnnotation))
+		Expect(updated.Annotations).NotTo(HaveKey(atlasv1.AllowDowngradeToAnnotation))
+
+		// The override was used up, going further back needs a new one
+		setVersion("1.20.0")
+		_, updated = reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseDowngradeRejected))
+	})
+
+	It("requires a reason with the override", func() {
+		annotate(atlasApp, map[string]string{
+			atlasv1.AllowDowngradeToAnnotation: "1.21.0/6",
+		})
+		setVersion("1.21.0")
+
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseDowngradeRejected))
+	})
+})
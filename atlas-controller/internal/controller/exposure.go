@@ -0,0 +1,255 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+// httpRouteGVK identifies Gateway API HTTPRoutes, which are handled as
+// unstructured objects since their CRDs are optional
+var httpRouteGVK = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "HTTPRoute"}
+
+// exposureType returns the exposure type with its default applied
+func exposureType(exposure *atlasv1.ExposureSpec) atlasv1.ExposureType {
+	if exposure.Type == "" {
+		return atlasv1.ExposureTypeIngress
+	}
+	return exposure.Type
+}
+
+// exposurePaths returns the path prefixes routed to the application
+func exposurePaths(exposure *atlasv1.ExposureSpec) []string {
+	if len(exposure.Paths) == 0 {
+		return []string{"/"}
+	}
+	return exposure.Paths
+}
+
+// exposureURL returns the URL the application is reachable at
+func exposureURL(exposure *atlasv1.ExposureSpec, host string) string {
+	scheme := "http"
+	if exposure.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, host, exposurePaths(exposure)[0])
+}
+
+// exposureMeta returns the metadata of the Ingress or HTTPRoute of the AtlasApp
+func exposureMeta(atlasApp *atlasv1.AtlasApp) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:        atlasApp.Name,
+		Namespace:   atlasApp.Namespace,
+		Annotations: atlasApp.Spec.Exposure.Annotations,
+		Labels: map[string]string{
+			"app":                  "atlas",
+			appNameLabel:           atlasApp.Name,
+			"atlas.io/environment": atlasApp.Spec.Environment,
+			"atlas.io/managed-by":  "atlas-controller",
+		},
+	}
+}
+
+// desiredIngress returns the Ingress routing host to the Service of the AtlasApp
+func desiredIngress(atlasApp *atlasv1.AtlasApp, host string) *networkingv1.Ingress {
+	exposure := atlasApp.Spec.Exposure
+	pathType := networkingv1.PathTypePrefix
+
+	var paths []networkingv1.HTTPIngressPath
+	for _, path := range exposurePaths(exposure) {
+		paths = append(paths, networkingv1.HTTPIngressPath{
+			Path:     path,
+			PathType: &pathType,
+			Backend: networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{
+					Name: atlasApp.Name,
+					Port: networkingv1.ServiceBackendPort{Number: appPort(atlasApp, exposure.Port).ServicePort},
+				},
+			},
+		})
+	}
+
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: exposureMeta(atlasApp),
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{
+				Host: host,
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{Paths: paths},
+				},
+			}},
+		},
+	}
+	if exposure.IngressClassName != "" {
+		ingress.Spec.IngressClassName = &exposure.IngressClassName
+	}
+	if exposure.TLS != nil {
+		ingress.Spec.TLS = []networkingv1.IngressTLS{{
+			Hosts:      []string{host},
+			SecretName: exposure.TLS.SecretName,
+		}}
+	}
+	return ingress
+}
+
+// desiredHTTPRoute returns the HTTPRoute attaching host to the Gateway of spec.exposure
+func desiredHTTPRoute(atlasApp *atlasv1.AtlasApp, host string) *unstructured.Unstructured {
+	exposure := atlasApp.Spec.Exposure
+
+	parentRef := map[string]interface{}{"name": exposure.Gateway.Name}
+	if exposure.Gateway.Namespace != "" {
+		parentRef["namespace"] = exposure.Gateway.Namespace
+	}
+	if exposure.Gateway.SectionName != "" {
+		parentRef["sectionName"] = exposure.Gateway.SectionName
+	}
+
+	var matches []interface{}
+	for _, path := range exposurePaths(exposure) {
+		matches = append(matches, map[string]interface{}{
+			"path": map[string]interface{}{"type": "PathPrefix", "value": path},
+		})
+	}
+
+	route := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"parentRefs": []interface{}{parentRef},
+			"hostnames":  []interface{}{host},
+			"rules": []interface{}{map[string]interface{}{
+				"matches": matches,
+				"backendRefs": []interface{}{map[string]interface{}{
+					"name": atlasApp.Name,
+					"port": int64(appPort(atlasApp, exposure.Port).ServicePort),
+				}},
+			}},
+		},
+	}}
+	route.SetGroupVersionKind(httpRouteGVK)
+	objectMeta := exposureMeta(atlasApp)
+	route.SetName(objectMeta.Name)
+	route.SetNamespace(objectMeta.Namespace)
+	route.SetLabels(objectMeta.Labels)
+	route.SetAnnotations(objectMeta.Annotations)
+	return route
+}
+
+// reconcileExposure applies the Ingress or HTTPRoute of spec.exposure, removes
+// the one no longer used and publishes the URL in the status. Changes are
+// recorded as drift only if detectDrift is set.
+func (r *AtlasAppReconciler) reconcileExposure(ctx context.Context, atlasApp *atlasv1.AtlasApp, detectDrift bool) error {
+	exposure := atlasApp.Spec.Exposure
+	if exposure == nil {
+		atlasApp.Status.URL = ""
+		if err := r.deleteOwnedExposure(ctx, atlasApp, &networkingv1.Ingress{}); err != nil {
+			return err
+		}
+		return r.deleteOwnedExposure(ctx, atlasApp, newHTTPRoute())
+	}
+
+	host, err := atlasApp.ExposureHost()
+	if err != nil {
+		return fmt.Errorf("invalid spec.exposure.host: %w", err)
+	}
+
+	if exposureType(exposure) == atlasv1.ExposureTypeHTTPRoute {
+		if exposure.Gateway == nil {
+			return fmt.Errorf("spec.exposure.gateway is required for HTTPRoute")
+		}
+		if err := r.applyOwned(ctx, atlasApp, desiredHTTPRoute(atlasApp, host), newHTTPRoute(), detectDrift); err != nil {
+			return err
+		}
+		if err := r.deleteOwnedExposure(ctx, atlasApp, &networkingv1.Ingress{}); err != nil {
+			return err
+		}
+	} else {
+		if err := r.applyOwned(ctx, atlasApp, desiredIngress(atlasApp, host), &networkingv1.Ingress{}, detectDrift); err != nil {
+			return err
+		}
+		if err := r.deleteOwnedExposure(ctx, atlasApp, newHTTPRoute()); err != nil {
+			return err
+		}
+	}
+
+	atlasApp.Status.URL = exposureURL(exposure, host)
+	return nil
+}
+
+// newHTTPRoute returns an empty HTTPRoute to read into
+func newHTTPRoute() *unstructured.Unstructured {
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRouteGVK)
+	return route
+}
+
+// deleteOwnedExposure deletes the Ingress or HTTPRoute of the AtlasApp, if it
+// exists and is owned by it. Missing Gateway API CRDs mean there is nothing to delete.
+func (r *AtlasAppReconciler) deleteOwnedExposure(ctx context.Context, atlasApp *atlasv1.AtlasApp, obj client.Object) error {
+	err := r.Get(ctx, types.NamespacedName{Name: atlasApp.Name, Namespace: atlasApp.Namespace}, obj)
+	if err != nil {
+		if errors.IsNotFound(err) || kindNotInstalled(err) {
+			return nil
+		}
+		return err
+	}
+	if !metav1.IsControlledBy(obj, atlasApp) {
+		return nil
+	}
+
+	gvk, err := r.GroupVersionKindFor(obj)
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("Deleting "+gvk.Kind, "Name", obj.GetName())
+	if err := r.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// kindNotInstalled reports if err means the API of a kind is not served by the
+// cluster. The REST mapper reports a missing group version as a failed
+// discovery rather than a NoMatch error.
+func kindNotInstalled(err error) bool {
+	if meta.IsNoMatchError(err) {
+		return true
+	}
+	var discoveryErr *discovery.ErrGroupDiscoveryFailed
+	if !goerrors.As(err, &discoveryErr) {
+		return false
+	}
+	for _, groupErr := range discoveryErr.Groups {
+		if !errors.IsNotFound(groupErr) {
+			return false
+		}
+	}
+	return true
+}
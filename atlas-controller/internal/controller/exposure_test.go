@@ -0,0 +1,120 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+var _ = Describe("Exposure", func() {
+	var (
+		r        *AtlasAppReconciler
+		atlasApp *atlasv1.AtlasApp
+		key      types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		r = newAppReconciler()
+		atlasApp = newApp(newNamespace("exposure"), "dev", "1.0.0")
+		atlasApp.Spec.HealthCheckPath = ""
+		atlasApp.Spec.Ports = []atlasv1.PortSpec{
+			{Name: "http", ContainerPort: 8080, ServicePort: 80},
+			{Name: "metrics", ContainerPort: 9090},
+		}
+		atlasApp.Spec.Exposure = &atlasv1.ExposureSpec{
+			Host:             "{{.Name}}.{{.Environment}}.example.com",
+			IngressClassName: "nginx",
+			TLS:              &atlasv1.ExposureTLS{SecretName: "atlas-tls"},
+		}
+		key = client.ObjectKeyFromObject(atlasApp)
+	})
+
+	It("routes the host to the Service through an Ingress", func() {
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.URL).To(Equal("https://atlas.dev.example.com/"))
+
+		ingress := &networkingv1.Ingress{}
+		Expect(k8sClient.Get(ctx, key, ingress)).To(Succeed())
+		Expect(*ingress.Spec.IngressClassName).To(Equal("nginx"))
+		Expect(ingress.Spec.TLS).To(Equal([]networkingv1.IngressTLS{{Hosts: []string{"atlas.dev.example.com"}, SecretName: "atlas-tls"}}))
+		Expect(ingress.Spec.Rules).To(HaveLen(1))
+		Expect(ingress.Spec.Rules[0].Host).To(Equal("atlas.dev.example.com"))
+
+		paths := ingress.Spec.Rules[0].HTTP.Paths
+		Expect(paths).To(HaveLen(1))
+		Expect(paths[0].Path).To(Equal("/"))
+		Expect(paths[0].Backend.Service.Name).To(Equal("atlas"))
+		Expect(paths[0].Backend.Service.Port.Number).To(Equal(int32(80)))
+	})
+
+	It("removes the Ingress and the URL once the exposure is unset", func() {
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		_, updated := reconcileApp(r, atlasApp)
+
+		updated.Spec.Exposure = nil
+		Expect(k8sClient.Update(ctx, updated)).To(Succeed())
+		_, updated = reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).NotTo(Equal(atlasv1.PhaseFailed), updated.Status.Message)
+		Expect(updated.Status.URL).To(BeEmpty())
+		Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &networkingv1.Ingress{}))).To(BeTrue())
+	})
+
+	It("reports an HTTPRoute while the Gateway API is not installed", func() {
+		atlasApp.Spec.Exposure.Type = atlasv1.ExposureTypeHTTPRoute
+		atlasApp.Spec.Exposure.Gateway = &atlasv1.GatewayReference{Name: "public", Namespace: "gateways"}
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseFailed))
+		Expect(updated.Status.URL).To(BeEmpty())
+	})
+
+	It("attaches the HTTPRoute to the Gateway", func() {
+		atlasApp.Spec.Exposure.Type = atlasv1.ExposureTypeHTTPRoute
+		atlasApp.Spec.Exposure.Port = "metrics"
+		atlasApp.Spec.Exposure.Paths = []string{"/api", "/metrics"}
+		atlasApp.Spec.Exposure.Gateway = &atlasv1.GatewayReference{Name: "public", Namespace: "gateways", SectionName: "https"}
+
+		route := desiredHTTPRoute(atlasApp, "atlas.dev.example.com")
+		Expect(route.GroupVersionKind()).To(Equal(httpRouteGVK))
+
+		parentRefs, _, _ := unstructured.NestedSlice(route.Object, "spec", "parentRefs")
+		Expect(parentRefs).To(Equal([]interface{}{map[string]interface{}{
+			"name": "public", "namespace": "gateways", "sectionName": "https",
+		}}))
+		hostnames, _, _ := unstructured.NestedStringSlice(route.Object, "spec", "hostnames")
+		Expect(hostnames).To(Equal([]string{"atlas.dev.example.com"}))
+
+		rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+		Expect(rules).To(HaveLen(1))
+		rule := rules[0].(map[string]interface{})
+		Expect(rule["matches"]).To(HaveLen(2))
+		Expect(rule["backendRefs"]).To(Equal([]interface{}{map[string]interface{}{
+			"name": "atlas", "port": int64(9090),
+		}}))
+	})
+})
@@ -0,0 +1,251 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+const (
+	defaultHealthCheckTimeoutSeconds   = 5
+	defaultHealthCheckSuccessThreshold = 1
+	defaultHealthCheckFailureThreshold = 3
+	defaultHealthCheckIntervalSeconds  = 10
+)
+
+// healthCheckSettings returns the health check configuration with defaults
+// applied. An empty port is resolved from the app's ports by healthCheckURLs.
+func healthCheckSettings(atlasApp *atlasv1.AtlasApp) atlasv1.HealthCheckSpec {
+	settings := atlasv1.HealthCheckSpec{}
+	if atlasApp.Spec.HealthCheck != nil {
+		settings = *atlasApp.Spec.HealthCheck.DeepCopy()
+	}
+
+	if settings.Target == "" {
+		settings.Target = atlasv1.HealthCheckTargetService
+	}
+	if settings.TimeoutSeconds == 0 {
+		settings.TimeoutSeconds = defaultHealthCheckTimeoutSeconds
+	}
+	if settings.SuccessThreshold == 0 {
+		settings.SuccessThreshold = defaultHealthCheckSuccessThreshold
+	}
+	if settings.FailureThreshold == 0 {
+		settings.FailureThreshold = defaultHealthCheckFailureThreshold
+	}
+	if settings.IntervalSeconds == 0 {
+		settings.IntervalSeconds = defaultHealthCheckIntervalSeconds
+	}
+
+	return settings
+}
+
+// healthCheckInterval returns the delay between two health checks
+func healthCheckInterval(atlasApp *atlasv1.AtlasApp) time.Duration {
+	return time.Duration(healthCheckSettings(atlasApp).IntervalSeconds) * time.Second
+}
+
+// healthCheckFailed reports if the failure threshold has been reached for the spec release
+func healthCheckFailed(atlasApp *atlasv1.AtlasApp) bool {
+	if !healthCheckCurrent(atlasApp) {
+		return false
+	}
+	return atlasApp.Status.HealthCheck.ConsecutiveFailures >= healthCheckSettings(atlasApp).FailureThreshold
+}
+
+// healthCheckCurrent reports if the health check status refers to the spec
+// release, a new version, image or migration starts counting from zero
+func healthCheckCurrent(atlasApp *atlasv1.AtlasApp) bool {
+	status := atlasApp.Status.HealthCheck
+	if status == nil {
+		return false
+	}
+	rel := specRelease(atlasApp)
+	return status.Version == rel.version && status.Image == rel.image && status.MigrationId == rel.migrationId
+}
+
+// healthCheckURLs returns the URLs to probe for the configured target. If
+// deploymentName is set, the ready pods of that Deployment are probed instead.
+func (r *AtlasAppReconciler) healthCheckURLs(ctx context.Context, atlasApp *atlasv1.AtlasApp, settings atlasv1.HealthCheckSpec, deploymentName string) ([]string, error) {
+	if deploymentName == "" && settings.Target == atlasv1.HealthCheckTargetService {
+		port := settings.Port
+		if port == 0 {
+			port = appPort(atlasApp, "").ServicePort
+		}
+		return []string{
+			fmt.Sprintf("http://%s.%s.svc:%d%s", atlasApp.Name, atlasApp.Namespace, port, atlasApp.Spec.HealthCheckPath),
+		}, nil
+	}
+
+	if deploymentName == "" {
+		deploymentName = activeDeploymentName(atlasApp)
+	}
+
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: deploymentName, Namespace: atlasApp.Namespace}, deployment); err != nil {
+		return nil, err
+	}
+
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(atlasApp.Namespace), client.MatchingLabels(deployment.Spec.Selector.MatchLabels)); err != nil {
+		return nil, err
+	}
+
+	port := settings.Port
+	if port == 0 {
+		port = appPort(atlasApp, "").ContainerPort
+	}
+
+	var urls []string
+	for _, pod := range pods.Items {
+		if pod.Status.PodIP == "" || !isPodReady(&pod) {
+			continue
+		}
+		urls = append(urls, fmt.Sprintf("http://%s:%d%s", pod.Status.PodIP, port, atlasApp.Spec.HealthCheckPath))
+	}
+	return urls, nil
+}
+
+// isPodReady reports if the pod has passed its readiness probe
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// probeHealthEndpoint sends a single health check request and evaluates the response
+func probeHealthEndpoint(ctx context.Context, httpClient *http.Client, url string, settings atlasv1.HealthCheckSpec) atlasv1.HealthCheckResult {
+	result := atlasv1.HealthCheckResult{URL: url}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(settings.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	result.StatusCode = int32(resp.StatusCode)
+	result.Healthy = isExpectedStatusCode(resp.StatusCode, settings.ExpectedStatusCodes)
+	if !result.Healthy {
+		result.Message = fmt.Sprintf("unexpected status code %d", resp.StatusCode)
+	}
+	return result
+}
+
+// isExpectedStatusCode reports if code is accepted, treating any 2xx as healthy when expected is empty
+func isExpectedStatusCode(code int, expected []int32) bool {
+	if len(expected) == 0 {
+		return code >= 200 && code < 300
+	}
+	for _, c := range expected {
+		if int(c) == code {
+			return true
+		}
+	}
+	return false
+}
+
+// performHealthCheck probes the application and records the results in the status.
+// It returns true once SuccessThreshold consecutive checks have passed for the
+// current release, and keeps returning true until FailureThreshold consecutive
+// checks have failed. deploymentName selects the Deployment whose pods are
+// probed, or the configured target if empty.
+func (r *AtlasAppReconciler) performHealthCheck(ctx context.Context, atlasApp *atlasv1.AtlasApp, deploymentName string) (bool, error) {
+	log := log.FromContext(ctx)
+	settings := healthCheckSettings(atlasApp)
+
+	urls, err := r.healthCheckURLs(ctx, atlasApp, settings, deploymentName)
+	if err != nil {
+		return false, err
+	}
+	if len(urls) == 0 {
+		return false, fmt.Errorf("no ready pods to health check")
+	}
+
+	httpClient := r.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	healthy := true
+	results := make([]atlasv1.HealthCheckResult, 0, len(urls))
+	for _, url := range urls {
+		result := probeHealthEndpoint(ctx, httpClient, url, settings)
+		if !result.Healthy {
+			healthy = false
+		}
+		results = append(results, result)
+	}
+
+	// Counters only carry over while the same release is being checked
+	status := atlasApp.Status.HealthCheck
+	if !healthCheckCurrent(atlasApp) {
+		rel := specRelease(atlasApp)
+		status = &atlasv1.HealthCheckStatus{Version: rel.version, Image: rel.image, MigrationId: rel.migrationId}
+	}
+
+	if healthy {
+		status.ConsecutiveSuccesses++
+		status.ConsecutiveFailures = 0
+		if status.ConsecutiveSuccesses >= settings.SuccessThreshold {
+			status.Healthy = true
+		}
+	} else {
+		status.ConsecutiveFailures++
+		status.ConsecutiveSuccesses = 0
+		if status.ConsecutiveFailures >= settings.FailureThreshold {
+			status.Healthy = false
+		}
+	}
+
+	now := metav1.Now()
+	status.LastCheckTime = &now
+	status.Results = results
+	atlasApp.Status.HealthCheck = status
+
+	log.Info("Performed health check", "path", atlasApp.Spec.HealthCheckPath, "targets", len(urls),
+		"passed", healthy, "successes", status.ConsecutiveSuccesses, "failures", status.ConsecutiveFailures)
+
+	return status.Healthy, nil
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+var _ = Describe("Health checks", func() {
+	var (
+		server *httptest.Server
+		status atomic.Int32
+		delay  atomic.Int64
+		paths  chan string
+	)
+
+	BeforeEach(func() {
+		status.Store(http.StatusOK)
+		delay.Store(0)
+		paths = make(chan string, 10)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			paths <- req.URL.Path
+			select {
+			case <-time.After(time.Duration(delay.Load())):
+			case <-req.Context().Done():
+				return
+			}
+			w.WriteHeader(int(status.Load()))
+		}))
+		DeferCleanup(server.Close)
+	})
+
+	// serverClient sends every request to the test server, whatever the URL
+	serverClient := func() *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+			},
+		}}
+	}
+
+	Context("probing an endpoint", func() {
+		It("accepts any 2xx response by default", func() {
+			status.Store(http.StatusNoContent)
+			result := probeHealthEndpoint(ctx, server.Client(), server.URL+"/health", atlasv1.HealthCheckSpec{TimeoutSeconds: 1})
+			Expect(result.Healthy).To(BeTrue())
+			Expect(result.StatusCode).To(Equal(int32(http.StatusNoContent)))
+			Expect(result.Message).To(BeEmpty())
+		})
+
+		It("rejects a non-2xx response", func() {
+			status.Store(http.StatusServiceUnavailable)
+			result := probeHealthEndpoint(ctx, server.Client(), server.URL+"/health", atlasv1.HealthCheckSpec{TimeoutSeconds: 1})
+			Expect(result.Healthy).To(BeFalse())
+			Expect(result.StatusCode).To(Equal(int32(http.StatusServiceUnavailable)))
+			Expect(result.Message).To(Equal("unexpected status code 503"))
+		})
+
+		It("only accepts the expected status codes if they are set", func() {
+			settings := atlasv1.HealthCheckSpec{TimeoutSeconds: 1, ExpectedStatusCodes: []int32{http.StatusNoContent}}
+			result := probeHealthEndpoint(ctx, server.Client(), server.URL+"/health", settings)
+			Expect(result.Healthy).To(BeFalse())
+			Expect(result.StatusCode).To(Equal(int32(http.StatusOK)))
+		})
+
+		It("fails when the endpoint does not answer within the timeout", func() {
+			delay.Store(int64(3 * time.Second))
+			result := probeHealthEndpoint(ctx, server.Client(), server.URL+"/health", atlasv1.HealthCheckSpec{TimeoutSeconds: 1})
+			Expect(result.Healthy).To(BeFalse())
+			Expect(result.StatusCode).To(BeZero())
+			Expect(result.Message).To(ContainSubstring("deadline exceeded"))
+		})
+	})
+
+	Context("counting consecutive results", func() {
+		var (
+			r        *AtlasAppReconciler
+			atlasApp *atlasv1.AtlasApp
+		)
+
+		BeforeEach(func() {
+			r = &AtlasAppReconciler{HTTPClient: serverClient()}
+			atlasApp = newApp("default", "dev", "1.0.0")
+			atlasApp.Spec.HealthCheck = &atlasv1.HealthCheckSpec{
+				TimeoutSeconds:   1,
+				SuccessThreshold: 2,
+				FailureThreshold: 2,
+			}
+		})
+
+		check := func() bool {
+			healthy, err := r.performHealthCheck(ctx, atlasApp, "")
+			Expect(err).NotTo(HaveOccurred())
+			return healthy
+		}
+
+		It("probes the health check path through the Service", func() {
+			check()
+			Expect(paths).To(Receive(Equal("/health")))
+			Expect(atlasApp.Status.HealthCheck.Results).To(HaveLen(1))
+			Expect(atlasApp.Status.HealthCheck.Results[0].URL).To(Equal("http://atlas.default.svc:80/health"))
+		})
+
+		It("becomes healthy once the success threshold is reached", func() {
+			Expect(check()).To(BeFalse())
+			Expect(atlasApp.Status.HealthCheck.ConsecutiveSuccesses).To(Equal(int32(1)))
+			Expect(check()).To(BeTrue())
+			Expect(atlasApp.Status.HealthCheck.ConsecutiveSuccesses).To(Equal(int32(2)))
+			Expect(atlasApp.Status.HealthCheck.Healthy).To(BeTrue())
+		})
+
+		It("stays healthy until the failure threshold is reached", func() {
+			check()
+			Expect(check()).To(BeTrue())
+
+			status.Store(http.StatusInternalServerError)
+			Expect(check()).To(BeTrue())
+			Expect(atlasApp.Status.HealthCheck.ConsecutiveFailures).To(Equal(int32(1)))
+			Expect(atlasApp.Status.HealthCheck.ConsecutiveSuccesses).To(BeZero())
+			Expect(healthCheckFailed(atlasApp)).To(BeFalse())
+
+			Expect(check()).To(BeFalse())
+			Expect(healthCheckFailed(atlasApp)).To(BeTrue())
+		})
+
+		It("counts a timeout as a failure", func() {
+			delay.Store(int64(3 * time.Second))
+			Expect(check()).To(BeFalse())
+			Expect(atlasApp.Status.HealthCheck.ConsecutiveFailures).To(Equal(int32(1)))
+			Expect(atlasApp.Status.HealthCheck.Results[0].Message).To(ContainSubstring("deadline exceeded"))
+		})
+
+		It("starts counting from zero for a new image or migration", func() {
+			status.Store(http.StatusInternalServerError)
+			check()
+			check()
+			Expect(healthCheckFailed(atlasApp)).To(BeTrue())
+
+			atlasApp.Spec.Image = "ghcr.io/example/atlas"
+			Expect(healthCheckFailed(atlasApp)).To(BeFalse())
+			check()
+			Expect(atlasApp.Status.HealthCheck.ConsecutiveFailures).To(Equal(int32(1)))
+			Expect(atlasApp.Status.HealthCheck.Image).To(Equal("ghcr.io/example/atlas:1.0.0"))
+
+			check()
+			atlasApp.Spec.MigrationId = 2
+			Expect(healthCheckFailed(atlasApp)).To(BeFalse())
+			check()
+			Expect(atlasApp.Status.HealthCheck.ConsecutiveFailures).To(Equal(int32(1)))
+			Expect(atlasApp.Status.HealthCheck.MigrationId).To(Equal(2))
+		})
+	})
+
+	Context("reconciling an AtlasApp", func() {
+		var (
+			r        *AtlasAppReconciler
+			atlasApp *atlasv1.AtlasApp
+			key      types.NamespacedName
+		)
+
+		BeforeEach(func() {
+			r = newAppReconciler()
+			r.HTTPClient = serverClient()
+			atlasApp = newApp(newNamespace("health"), "dev", "1.0.0")
+			atlasApp.Spec.HealthCheck = &atlasv1.HealthCheckSpec{
+				TimeoutSeconds:   1,
+				SuccessThreshold: 2,
+				FailureThreshold: 2,
+				IntervalSeconds:  5,
+			}
+			Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+			key = client.ObjectKeyFromObject(atlasApp)
+
+			reconcileApp(r, atlasApp)
+			markDeploymentReady(key)
+		})
+
+		It("verifies the app until the success threshold is reached", func() {
+			result, updated := reconcileApp(r, atlasApp)
+			Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseVerifying))
+			Expect(updated.Status.Ready).To(BeFalse())
+			Expect(updated.Status.HealthCheck.ConsecutiveSuccesses).To(Equal(int32(1)))
+			Expect(result.RequeueAfter).To(Equal(5 * time.Second))
+
+			_, updated = reconcileApp(r, atlasApp)
+			Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseReady))
+			Expect(updated.Status.Ready).To(BeTrue())
+		})
+
+		It("reports the app unhealthy once the failure threshold is reached", func() {
+			status.Store(http.StatusServiceUnavailable)
+			_, updated := reconcileApp(r, atlasApp)
+			Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseVerifying))
+
+			_, updated = reconcileApp(r, atlasApp)
+			Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseUnhealthy))
+			Expect(updated.Status.HealthCheck.ConsecutiveFailures).To(Equal(int32(2)))
+			Expect(updated.Status.HealthCheck.Results[0].StatusCode).To(Equal(int32(http.StatusServiceUnavailable)))
+		})
+	})
+})
@@ -0,0 +1,116 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+// maxHistory bounds the revisions kept in the status
+const maxHistory = 10
+
+// startRevision records the spec release in the history, unless it is the latest revision already
+func startRevision(atlasApp *atlasv1.AtlasApp) {
+	rel := specRelease(atlasApp)
+	if history := atlasApp.Status.History; len(history) > 0 && historyMatches(history[0], rel) {
+		return
+	}
+
+	trigger, triggeredBy := revisionTrigger(atlasApp)
+	pushRevision(atlasApp, rel, trigger, triggeredBy)
+}
+
+// pushRevision adds a revision to the front of the history. A revision still
+// in progress is superseded by it.
+func pushRevision(atlasApp *atlasv1.AtlasApp, rel release, trigger atlasv1.RevisionTrigger, triggeredBy string) {
+	history := atlasApp.Status.History
+	if len(history) > 0 && history[0].Outcome == atlasv1.RevisionOutcomeProgressing {
+		history[0].Outcome = atlasv1.RevisionOutcomeSuperseded
+	}
+
+	revision := atlasv1.RevisionHistory{
+		Version:     rel.version,
+		MigrationId: rel.migrationId,
+		Image:       rel.image,
+		Trigger:     trigger,
+		TriggeredBy: triggeredBy,
+		StartedAt:   metav1.Now(),
+		Outcome:     atlasv1.RevisionOutcomeProgressing,
+	}
+	if rel == specRelease(atlasApp) {
+		revision.ImageDigest = atlasApp.Spec.ImageDigest
+	}
+
+	history = append([]atlasv1.RevisionHistory{revision}, history...)
+	if len(history) > maxHistory {
+		history = history[:maxHistory]
+	}
+	atlasApp.Status.History = history
+}
+
+// historyMatches reports if a revision in the history is the given release
+func historyMatches(revision atlasv1.RevisionHistory, rel release) bool {
+	return revision.Version == rel.version && revision.Image == rel.image && revision.MigrationId == rel.migrationId
+}
+
+// revisionTrigger tells promotions of the spec version apart from manual changes
+func revisionTrigger(atlasApp *atlasv1.AtlasApp) (atlasv1.RevisionTrigger, string) {
+	annotations := atlasApp.GetAnnotations()
+	if from := annotations[atlasv1.PromotedFromAnnotation]; from != "" && annotations[atlasv1.PromotedVersionAnnotation] == atlasApp.Spec.Version {
+		return atlasv1.RevisionTriggerPromotion, from
+	}
+	return atlasv1.RevisionTriggerManual, ""
+}
+
+// markRevisionReady records that the latest revision reached the Ready phase
+func markRevisionReady(atlasApp *atlasv1.AtlasApp) {
+	if len(atlasApp.Status.History) == 0 {
+		return
+	}
+	latest := &atlasApp.Status.History[0]
+	if latest.ReadyAt == nil {
+		now := metav1.Now()
+		latest.ReadyAt = &now
+	}
+	latest.Outcome = atlasv1.RevisionOutcomeSucceeded
+}
+
+// setRevisionOutcome derives the outcome of the latest revision from the phase
+// the reconciler ended up in. Revisions that were ready once keep their outcome.
+func setRevisionOutcome(atlasApp *atlasv1.AtlasApp, phase string) {
+	if len(atlasApp.Status.History) == 0 {
+		return
+	}
+	latest := &atlasApp.Status.History[0]
+
+	switch phase {
+	case atlasv1.PhaseReady:
+		markRevisionReady(atlasApp)
+
+	case atlasv1.PhaseUnhealthy, atlasv1.PhaseMigrationFailed:
+		if latest.ReadyAt == nil {
+			latest.Outcome = atlasv1.RevisionOutcomeFailed
+		}
+
+	case atlasv1.PhaseRolloutAborted:
+		if latest.ReadyAt == nil {
+			latest.Outcome = atlasv1.RevisionOutcomeAborted
+		}
+	}
+}
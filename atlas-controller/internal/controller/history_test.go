@@ -0,0 +1,131 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+var _ = Describe("Revision history", func() {
+	var (
+		r        *AtlasAppReconciler
+		atlasApp *atlasv1.AtlasApp
+		key      types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		r = newAppReconciler()
+		atlasApp = newApp(newNamespace("history"), "dev", "1.0.0")
+		atlasApp.Spec.HealthCheckPath = ""
+		key = client.ObjectKeyFromObject(atlasApp)
+	})
+
+	setVersion := func(version string) {
+		latest := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, key, latest)).To(Succeed())
+		latest.Spec.Version = version
+		Expect(k8sClient.Update(ctx, latest)).To(Succeed())
+	}
+
+	// rollOut resets the status of the Deployment to a rollout that has no ready pods yet
+	rollOut := func() {
+		deployment := getDeployment(key)
+		deployment.Status.ReadyReplicas = 0
+		deployment.Status.AvailableReplicas = 0
+		Expect(k8sClient.Status().Update(ctx, deployment)).To(Succeed())
+	}
+
+	It("records each release and how its rollout ended", func() {
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.History).To(HaveLen(1))
+		Expect(updated.Status.History[0].Version).To(Equal("1.0.0"))
+		Expect(updated.Status.History[0].Image).To(Equal("nginx:1.0.0"))
+		Expect(updated.Status.History[0].Trigger).To(Equal(atlasv1.RevisionTriggerManual))
+		Expect(updated.Status.History[0].Outcome).To(Equal(atlasv1.RevisionOutcomeProgressing))
+
+		markDeploymentReady(key)
+		_, updated = reconcileApp(r, atlasApp)
+		Expect(updated.Status.History).To(HaveLen(1))
+		Expect(updated.Status.History[0].Outcome).To(Equal(atlasv1.RevisionOutcomeSucceeded))
+		Expect(updated.Status.History[0].ReadyAt).NotTo(BeNil())
+
+		rollOut()
+		setVersion("1.1.0")
+		reconcileApp(r, atlasApp)
+		setVersion("1.2.0")
+		_, updated = reconcileApp(r, atlasApp)
+		Expect(updated.Status.History).To(HaveLen(3))
+		Expect(updated.Status.History[0].Version).To(Equal("1.2.0"))
+		Expect(updated.Status.History[1].Version).To(Equal("1.1.0"))
+		Expect(updated.Status.History[1].Outcome).To(Equal(atlasv1.RevisionOutcomeSuperseded))
+		Expect(updated.Status.History[2].Outcome).To(Equal(atlasv1.RevisionOutcomeSucceeded))
+	})
+
+	It("records promotions with the AtlasApp they came from", func() {
+		atlasApp.Annotations = map[string]string{
+			atlasv1.PromotedFromAnnotation:    "payments-dev/atlas",
+			atlasv1.PromotedVersionAnnotation: "1.0.0",
+		}
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.History[0].Trigger).To(Equal(atlasv1.RevisionTriggerPromotion))
+		Expect(updated.Status.History[0].TriggeredBy).To(Equal("payments-dev/atlas"))
+
+		// A version set by hand afterwards is no promotion
+		setVersion("1.0.1")
+		_, updated = reconcileApp(r, atlasApp)
+		Expect(updated.Status.History[0].Trigger).To(Equal(atlasv1.RevisionTriggerManual))
+	})
+
+	It("keeps the outcome of revisions that were ready once", func() {
+		app := newApp("default", "dev", "1.0.0")
+		startRevision(app)
+		setRevisionOutcome(app, atlasv1.PhaseReady)
+		setRevisionOutcome(app, atlasv1.PhaseUnhealthy)
+		Expect(app.Status.History[0].Outcome).To(Equal(atlasv1.RevisionOutcomeSucceeded))
+
+		app.Spec.Version = "1.1.0"
+		startRevision(app)
+		setRevisionOutcome(app, atlasv1.PhaseMigrationFailed)
+		Expect(app.Status.History[0].Outcome).To(Equal(atlasv1.RevisionOutcomeFailed))
+
+		app.Spec.Version = "1.2.0"
+		startRevision(app)
+		setRevisionOutcome(app, atlasv1.PhaseRolloutAborted)
+		Expect(app.Status.History[0].Outcome).To(Equal(atlasv1.RevisionOutcomeAborted))
+	})
+
+	It("keeps the latest revisions only", func() {
+		app := newApp("default", "dev", "1.0.0")
+		for i := 0; i < maxHistory+2; i++ {
+			app.Spec.Version = fmt.Sprintf("1.%d.0", i)
+			startRevision(app)
+			startRevision(app)
+		}
+		Expect(app.Status.History).To(HaveLen(maxHistory))
+		Expect(app.Status.History[0].Version).To(Equal(fmt.Sprintf("1.%d.0", maxHistory+1)))
+	})
+})
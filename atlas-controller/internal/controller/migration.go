@@ -0,0 +1,226 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+const (
+	// migrationForLabel carries the AtlasApp name on migration pods. appNameLabel is
+	// not used, it would make the Deployment and Service select the migration pods.
+	migrationForLabel = "atlas.io/migration-for"
+
+	// migrationIdLabel carries the migration ID on migration Jobs
+	migrationIdLabel = "atlas.io/migration-id"
+
+	// jobForLabel carries the AtlasApp name on the pods of all its Jobs, so
+	// one NetworkPolicy covers migrations and pre-delete hooks
+	jobForLabel = "atlas.io/job-for"
+
+	// maxJobNameLength keeps the job-name label set on the pods valid
+	maxJobNameLength = 63
+
+	// jobTTLSecondsAfterFinished removes finished Jobs and their pods after a day,
+	// the outcome of a migration is kept in the status by then
+	jobTTLSecondsAfterFinished = 24 * 60 * 60
+)
+
+// migrationJobName returns the name of the Job migrating an AtlasApp to its migration ID
+func migrationJobName(atlasApp *atlasv1.AtlasApp) string {
+	return jobName(atlasApp, fmt.Sprintf("-migrate-%d", atlasApp.Spec.MigrationId))
+}
+
+// jobName returns the name of a Job of an AtlasApp, shortening the AtlasApp name to fit the suffix
+func jobName(atlasApp *atlasv1.AtlasApp, suffix string) string {
+	name := atlasApp.Name
+	if len(name)+len(suffix) > maxJobNameLength {
+		name = strings.TrimRight(name[:maxJobNameLength-len(suffix)], "-.")
+	}
+	return name + suffix
+}
+
+// reconcileMigration runs the migration Job when spec.migrationId is higher
+// than the last applied migration, and reports if the rollout may proceed. The
+// phase is reported while the migration is running or after it failed.
+func (r *AtlasAppReconciler) reconcileMigration(ctx context.Context, atlasApp *atlasv1.AtlasApp) (ctrl.Result, bool, error) {
+	log := log.FromContext(ctx)
+
+	if atlasApp.Spec.Migration == nil {
+		meta.RemoveStatusCondition(&atlasApp.Status.Conditions, atlasv1.ConditionMigrationApplied)
+		return ctrl.Result{}, true, nil
+	}
+
+	if atlasApp.Spec.MigrationId <= atlasApp.Status.AppliedMigrationId {
+		setCondition(atlasApp, atlasv1.ConditionMigrationApplied, metav1.ConditionTrue, reasonMigrationSucceeded,
+			fmt.Sprintf("Migration %d applied", atlasApp.Status.AppliedMigrationId))
+		return ctrl.Result{}, true, nil
+	}
+
+	job := &batchv1.Job{}
+	key := types.NamespacedName{Name: migrationJobName(atlasApp), Namespace: atlasApp.Namespace}
+	err := r.Get(ctx, key, job)
+	if err != nil && errors.IsNotFound(err) {
+		job = migrationJob(atlasApp, key)
+		if err := ctrl.SetControllerReference(atlasApp, job, r.Scheme); err != nil {
+			result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+			return result, false, err
+		}
+
+		log.Info("Starting migration", "Job.Name", key.Name, "migrationId", atlasApp.Spec.MigrationId)
+		if err := r.Create(ctx, job); err != nil {
+			result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+			return result, false, err
+		}
+		r.Recorder.Eventf(atlasApp, corev1.EventTypeNormal, "MigrationStarted",
+			"Started migration %d as Job %s", atlasApp.Spec.MigrationId, key.Name)
+	} else if err != nil {
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+		return result, false, err
+	} else if !metav1.IsControlledBy(job, atlasApp) {
+		result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false,
+			fmt.Sprintf("Job %s already exists and is not managed by this AtlasApp", key.Name))
+		return result, false, err
+	}
+
+	if cond := jobCondition(job, batchv1.JobComplete); cond != nil {
+		log.Info("Migration applied", "Job.Name", key.Name, "migrationId", atlasApp.Spec.MigrationId)
+		r.Recorder.Eventf(atlasApp, corev1.EventTypeNormal, "MigrationApplied",
+			"Migration %d applied by Job %s", atlasApp.Spec.MigrationId, key.Name)
+		atlasApp.Status.AppliedMigrationId = atlasApp.Spec.MigrationId
+		setCondition(atlasApp, atlasv1.ConditionMigrationApplied, metav1.ConditionTrue, reasonMigrationSucceeded,
+			fmt.Sprintf("Migration %d applied by Job %s", atlasApp.Spec.MigrationId, key.Name))
+		return ctrl.Result{}, true, nil
+	}
+
+	if cond := jobCondition(job, batchv1.JobFailed); cond != nil {
+		message := fmt.Sprintf("Migration %d failed, Job %s: %s", atlasApp.Spec.MigrationId, key.Name, cond.Message)
+		log.Info("Migration failed", "Job.Name", key.Name, "reason", cond.Reason)
+		r.Recorder.Event(atlasApp, corev1.EventTypeWarning, reasonMigrationFailed, message)
+		setCondition(atlasApp, atlasv1.ConditionMigrationApplied, metav1.ConditionFalse, reasonMigrationFailed, message)
+		if _, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseMigrationFailed, false, message); err != nil {
+			return ctrl.Result{}, false, err
+		}
+
+		// Deleting the Job or changing spec.migrationId triggers a reconcile, no need to poll
+		return ctrl.Result{}, false, nil
+	}
+
+	message := fmt.Sprintf("Waiting for migration %d, Job %s", atlasApp.Spec.MigrationId, key.Name)
+	setCondition(atlasApp, atlasv1.ConditionMigrationApplied, metav1.ConditionFalse, reasonMigrationRunning, message)
+	result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseMigrating, false, message)
+	return result, false, err
+}
+
+// migrationJob returns the Job running the migration of an AtlasApp
+func migrationJob(atlasApp *atlasv1.AtlasApp, key types.NamespacedName) *batchv1.Job {
+	migration := atlasApp.Spec.Migration
+	container := jobContainer(atlasApp, "migrate", migration.Image, migration.Command, migration.Args, migration.SecretRef)
+
+	labels := map[string]string{
+		appNameLabel:          atlasApp.Name,
+		migrationIdLabel:      strconv.Itoa(atlasApp.Spec.MigrationId),
+		"atlas.io/managed-by": "atlas-controller",
+	}
+	podLabels := map[string]string{
+		migrationForLabel: atlasApp.Name,
+		migrationIdLabel:  strconv.Itoa(atlasApp.Spec.MigrationId),
+	}
+	return newJob(atlasApp, key, labels, podLabels, migration.BackoffLimit, migration.ActiveDeadlineSeconds, container)
+}
+
+// jobContainer returns the container of a migration or hook Job. It runs the
+// application image unless image is set, and gets the environment of the
+// application container plus the keys of secretRef.
+func jobContainer(atlasApp *atlasv1.AtlasApp, name, image string, command, args []string, secretRef *corev1.LocalObjectReference) corev1.Container {
+	if image == "" {
+		image = imageReference(&atlasApp.Spec)
+	}
+
+	container := corev1.Container{
+		Name:    name,
+		Image:   image,
+		Command: command,
+		Args:    args,
+		Env:     containerEnv(atlasApp, specRelease(atlasApp)),
+	}
+	container.EnvFrom = append(container.EnvFrom, atlasApp.Spec.EnvFrom...)
+	if secretRef != nil {
+		container.EnvFrom = append(container.EnvFrom,
+			corev1.EnvFromSource{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: *secretRef}})
+	}
+	return container
+}
+
+// newJob returns a Job running the container once, without retries unless
+// backoffLimit is set. The Job is removed a day after it finished.
+func newJob(atlasApp *atlasv1.AtlasApp, key types.NamespacedName, labels, podLabels map[string]string, backoffLimit *int32, activeDeadlineSeconds *int64, container corev1.Container) *batchv1.Job {
+	backoff := int32(0)
+	if backoffLimit != nil {
+		backoff = *backoffLimit
+	}
+	ttl := int32(jobTTLSecondsAfterFinished)
+
+	podLabels[jobForLabel] = atlasApp.Name
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoff,
+			ActiveDeadlineSeconds:   activeDeadlineSeconds,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: podLabels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:    corev1.RestartPolicyNever,
+					ImagePullSecrets: atlasApp.Spec.ImagePullSecrets,
+					Containers:       []corev1.Container{container},
+				},
+			},
+		},
+	}
+}
+
+// jobCondition returns the condition of the given type if it is True
+func jobCondition(job *batchv1.Job, conditionType batchv1.JobConditionType) *batchv1.JobCondition {
+	for i := range job.Status.Conditions {
+		if job.Status.Conditions[i].Type == conditionType && job.Status.Conditions[i].Status == corev1.ConditionTrue {
+			return &job.Status.Conditions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+var _ = Describe("Database migrations", func() {
+	var (
+		r        *AtlasAppReconciler
+		atlasApp *atlasv1.AtlasApp
+		jobKey   types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		r = newAppReconciler()
+		atlasApp = newApp(newNamespace("migration"), "dev", "1.22.0")
+		atlasApp.Spec.Image = "ghcr.io/example/atlas"
+		atlasApp.Spec.MigrationId = 6
+		atlasApp.Spec.Env = []corev1.EnvVar{
+			{Name: "DATABASE_HOST", Value: "db.example.com"},
+			{Name: "MIGRATION_ID", Value: "ignored"},
+		}
+		atlasApp.Spec.EnvFrom = []corev1.EnvFromSource{
+			{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "atlas-config"}}},
+		}
+		atlasApp.Spec.Migration = &atlasv1.MigrationSpec{
+			Command:   []string{"/app/migrate"},
+			SecretRef: &corev1.LocalObjectReference{Name: "atlas-db"},
+		}
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		jobKey = types.NamespacedName{Name: "atlas-migrate-6", Namespace: atlasApp.Namespace}
+	})
+
+	deploymentExists := func() bool {
+		err := k8sClient.Get(ctx, types.NamespacedName{Name: atlasApp.Name, Namespace: atlasApp.Namespace}, &appsv1.Deployment{})
+		if errors.IsNotFound(err) {
+			return false
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return true
+	}
+
+	// finishJob sets the condition the Job controller would set
+	finishJob := func(conditionType batchv1.JobConditionType) {
+		job := &batchv1.Job{}
+		Expect(k8sClient.Get(ctx, jobKey, job)).To(Succeed())
+		job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{
+			Type:               conditionType,
+			Status:             corev1.ConditionTrue,
+			LastTransitionTime: metav1.Now(),
+			Message:            "Job finished",
+		})
+		Expect(k8sClient.Status().Update(ctx, job)).To(Succeed())
+	}
+
+	It("runs the migration Job before the rollout", func() {
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseMigrating))
+		Expect(deploymentExists()).To(BeFalse())
+
+		job := &batchv1.Job{}
+		Expect(k8sClient.Get(ctx, jobKey, job)).To(Succeed())
+		Expect(*job.Spec.BackoffLimit).To(BeZero())
+		Expect(job.Spec.TTLSecondsAfterFinished).NotTo(BeNil())
+		Expect(*job.Spec.TTLSecondsAfterFinished).To(Equal(int32(24 * 60 * 60)))
+
+		container := job.Spec.Template.Spec.Containers[0]
+		Expect(container.Image).To(Equal("ghcr.io/example/atlas:1.22.0"))
+		Expect(container.Command).To(Equal([]string{"/app/migrate"}))
+		Expect(container.Env).To(ConsistOf(
+			corev1.EnvVar{Name: "MIGRATION_ID", Value: "6"},
+			corev1.EnvVar{Name: "ENVIRONMENT", Value: "dev"},
+			corev1.EnvVar{Name: "DATABASE_HOST", Value: "db.example.com"},
+		))
+		Expect(container.EnvFrom).To(HaveLen(2))
+		Expect(container.EnvFrom[0].ConfigMapRef.Name).To(Equal("atlas-config"))
+		Expect(container.EnvFrom[1].SecretRef.Name).To(Equal("atlas-db"))
+	})
+
+	It("rolls out once the Job succeeded", func() {
+		reconcileApp(r, atlasApp)
+		finishJob(batchv1.JobComplete)
+
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.AppliedMigrationId).To(Equal(6))
+		Expect(deploymentExists()).To(BeTrue())
+	})
+
+	It("keeps the previous version running when the Job failed", func() {
+		reconcileApp(r, atlasApp)
+		finishJob(batchv1.JobFailed)
+
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseMigrationFailed))
+		Expect(updated.Status.AppliedMigrationId).To(BeZero())
+		Expect(deploymentExists()).To(BeFalse())
+	})
+})
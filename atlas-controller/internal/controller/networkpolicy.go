@@ -0,0 +1,196 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+// dnsPort is the port DNS lookups are allowed to
+const dnsPort = 53
+
+// controllerPodLabels are the labels of the controller's pods, see
+// config/manager/manager.yaml
+var controllerPodLabels = map[string]string{"app": "atlas-controller"}
+
+// jobsNetworkPolicyName returns the name of the NetworkPolicy of the Job pods
+func jobsNetworkPolicyName(atlasApp *atlasv1.AtlasApp) string {
+	return atlasApp.Name + "-jobs"
+}
+
+// networkPolicyPeers returns the selectors of the given peers
+func networkPolicyPeers(peers ...atlasv1.NetworkPolicyPeer) []networkingv1.NetworkPolicyPeer {
+	var result []networkingv1.NetworkPolicyPeer
+	for _, peer := range peers {
+		np := networkingv1.NetworkPolicyPeer{}
+		if len(peer.Namespaces) > 0 {
+			np.NamespaceSelector = &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{
+					Key:      corev1.LabelMetadataName,
+					Operator: metav1.LabelSelectorOpIn,
+					Values:   peer.Namespaces,
+				}},
+			}
+		}
+		// An empty pod selector next to a namespace selector selects all pods
+		// of those namespaces, on its own all pods of the policy's namespace
+		np.PodSelector = &metav1.LabelSelector{MatchLabels: peer.PodLabels}
+		result = append(result, np)
+	}
+	return result
+}
+
+// networkPolicyEgress returns the egress rules shared by the application and
+// its Jobs: DNS lookups unless disabled, and the destinations of the spec
+func networkPolicyEgress(spec *atlasv1.NetworkPolicySpec) []networkingv1.NetworkPolicyEgressRule {
+	var rules []networkingv1.NetworkPolicyEgressRule
+	if !spec.DisableDNS {
+		udp, tcp := corev1.ProtocolUDP, corev1.ProtocolTCP
+		port := intstr.FromInt32(dnsPort)
+		rules = append(rules, networkingv1.NetworkPolicyEgressRule{
+			To: []networkingv1.NetworkPolicyPeer{{
+				NamespaceSelector: &metav1.LabelSelector{},
+				PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"k8s-app": "kube-dns"}},
+			}},
+			Ports: []networkingv1.NetworkPolicyPort{
+				{Protocol: &udp, Port: &port},
+				{Protocol: &tcp, Port: &port},
+			},
+		})
+	}
+
+	for _, destination := range spec.Egress {
+		rule := networkingv1.NetworkPolicyEgressRule{
+			To: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: destination.CIDR}}},
+		}
+		for _, p := range destination.Ports {
+			tcp := corev1.ProtocolTCP
+			port := intstr.FromInt32(p)
+			rule.Ports = append(rule.Ports, networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &port})
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// networkPolicyMeta returns the metadata of a NetworkPolicy of the AtlasApp
+func networkPolicyMeta(atlasApp *atlasv1.AtlasApp, name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      name,
+		Namespace: atlasApp.Namespace,
+		Labels: map[string]string{
+			"app":                  "atlas",
+			appNameLabel:           atlasApp.Name,
+			"atlas.io/environment": atlasApp.Spec.Environment,
+			"atlas.io/managed-by":  "atlas-controller",
+		},
+	}
+}
+
+// desiredNetworkPolicy returns the NetworkPolicy of the application pods,
+// including canary and blue/green pods. They accept connections to their
+// ports from each other, the controller's pods in controllerNamespace running
+// the health checks, and the peers of the spec.
+func desiredNetworkPolicy(atlasApp *atlasv1.AtlasApp, controllerNamespace string) *networkingv1.NetworkPolicy {
+	spec := atlasApp.Spec.NetworkPolicy
+	ownPods := atlasv1.NetworkPolicyPeer{PodLabels: selectorLabels(atlasApp)}
+	controller := atlasv1.NetworkPolicyPeer{PodLabels: controllerPodLabels}
+	if controllerNamespace != "" {
+		controller.Namespaces = []string{controllerNamespace}
+	}
+
+	peers := append([]atlasv1.NetworkPolicyPeer{ownPods, controller}, spec.Ingress...)
+	if spec.IngressController != nil {
+		peers = append(peers, *spec.IngressController)
+	}
+	ingress := networkingv1.NetworkPolicyIngressRule{From: networkPolicyPeers(peers...)}
+	for _, containerPort := range containerPorts(atlasApp) {
+		protocol := containerPort.Protocol
+		port := intstr.FromInt32(containerPort.ContainerPort)
+		ingress.Ports = append(ingress.Ports, networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &port})
+	}
+
+	egress := append([]networkingv1.NetworkPolicyEgressRule{{To: networkPolicyPeers(ownPods)}}, networkPolicyEgress(spec)...)
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: networkPolicyMeta(atlasApp, atlasApp.Name),
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: selectorLabels(atlasApp)},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Ingress:     []networkingv1.NetworkPolicyIngressRule{ingress},
+			Egress:      egress,
+		},
+	}
+}
+
+// desiredJobsNetworkPolicy returns the NetworkPolicy of the migration and
+// pre-delete Job pods, which accept no connections and reach the same
+// destinations as the application
+func desiredJobsNetworkPolicy(atlasApp *atlasv1.AtlasApp) *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: networkPolicyMeta(atlasApp, jobsNetworkPolicyName(atlasApp)),
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{jobForLabel: atlasApp.Name}},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Egress:      networkPolicyEgress(atlasApp.Spec.NetworkPolicy),
+		},
+	}
+}
+
+// reconcileNetworkPolicy applies the NetworkPolicies of the AtlasApp, or
+// removes them once spec.networkPolicy is unset. Changes are recorded as drift
+// only if detectDrift is set.
+func (r *AtlasAppReconciler) reconcileNetworkPolicy(ctx context.Context, atlasApp *atlasv1.AtlasApp, detectDrift bool) error {
+	if atlasApp.Spec.NetworkPolicy != nil {
+		for _, policy := range []*networkingv1.NetworkPolicy{desiredNetworkPolicy(atlasApp, r.ControllerNamespace), desiredJobsNetworkPolicy(atlasApp)} {
+			if err := r.applyOwned(ctx, atlasApp, policy, &networkingv1.NetworkPolicy{}, detectDrift); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, name := range []string{atlasApp.Name, jobsNetworkPolicyName(atlasApp)} {
+		policy := &networkingv1.NetworkPolicy{}
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: atlasApp.Namespace}, policy)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if !metav1.IsControlledBy(policy, atlasApp) {
+			continue
+		}
+
+		log.FromContext(ctx).Info("Deleting NetworkPolicy", "NetworkPolicy.Name", policy.Name)
+		if err := r.Delete(ctx, policy); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
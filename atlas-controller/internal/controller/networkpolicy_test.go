@@ -0,0 +1,168 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+var _ = Describe("NetworkPolicies", func() {
+	var (
+		r        *AtlasAppReconciler
+		atlasApp *atlasv1.AtlasApp
+		key      types.NamespacedName
+		jobsKey  types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		r = newAppReconciler()
+		r.ControllerNamespace = "atlas-system"
+		atlasApp = newApp(newNamespace("networkpolicy"), "dev", "1.0.0")
+		atlasApp.Spec.HealthCheckPath = ""
+		atlasApp.Spec.Ports = []atlasv1.PortSpec{{Name: "http", ContainerPort: 8080, ServicePort: 80}}
+		atlasApp.Spec.NetworkPolicy = &atlasv1.NetworkPolicySpec{
+			Ingress: []atlasv1.NetworkPolicyPeer{{
+				Namespaces: []string{"monitoring"},
+				PodLabels:  map[string]string{"app": "prometheus"},
+			}},
+			IngressController: &atlasv1.NetworkPolicyPeer{Namespaces: []string{"ingress-nginx"}},
+			Egress:            []atlasv1.NetworkPolicyDestination{{CIDR: "10.20.0.0/16", Ports: []int32{5432}}},
+		}
+		key = client.ObjectKeyFromObject(atlasApp)
+		jobsKey = types.NamespacedName{Name: "atlas-jobs", Namespace: atlasApp.Namespace}
+	})
+
+	getPolicy := func(key types.NamespacedName) *networkingv1.NetworkPolicy {
+		policy := &networkingv1.NetworkPolicy{}
+		Expect(k8sClient.Get(ctx, key, policy)).To(Succeed())
+		return policy
+	}
+
+	// selects reports if the pod selector of the policy matches the pod labels
+	selects := func(policy *networkingv1.NetworkPolicy, podLabels map[string]string) bool {
+		selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.PodSelector)
+		Expect(err).NotTo(HaveOccurred())
+		return selector.Matches(labels.Set(podLabels))
+	}
+
+	It("admits the app's own pods and the peers of the spec to the container ports", func() {
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		reconcileApp(r, atlasApp)
+
+		policy := getPolicy(key)
+		Expect(selects(policy, getDeployment(key).Spec.Template.Labels)).To(BeTrue())
+		Expect(policy.Spec.PolicyTypes).To(ConsistOf(networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress))
+
+		Expect(policy.Spec.Ingress).To(HaveLen(1))
+		from := policy.Spec.Ingress[0].From
+		Expect(from).To(HaveLen(4))
+		Expect(from[0].NamespaceSelector).To(BeNil())
+		Expect(from[0].PodSelector.MatchLabels).To(Equal(selectorLabels(atlasApp)))
+		Expect(from[1].NamespaceSelector.MatchExpressions[0].Values).To(Equal([]string{"atlas-system"}))
+		Expect(from[1].PodSelector.MatchLabels).To(Equal(controllerPodLabels))
+		Expect(from[2].NamespaceSelector.MatchExpressions[0].Values).To(Equal([]string{"monitoring"}))
+		Expect(from[2].PodSelector.MatchLabels).To(Equal(map[string]string{"app": "prometheus"}))
+		Expect(from[3].NamespaceSelector.MatchExpressions[0].Values).To(Equal([]string{"ingress-nginx"}))
+		Expect(from[3].PodSelector.MatchLabels).To(BeEmpty())
+
+		tcp := corev1.ProtocolTCP
+		port := intstr.FromInt32(8080)
+		Expect(policy.Spec.Ingress[0].Ports).To(Equal([]networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port}}))
+	})
+
+	It("admits the controller's pods for its health checks without peers in the spec", func() {
+		atlasApp.Spec.NetworkPolicy = &atlasv1.NetworkPolicySpec{}
+		from := desiredNetworkPolicy(atlasApp, "atlas-system").Spec.Ingress[0].From
+		Expect(from).To(HaveLen(2))
+		Expect(from[1].NamespaceSelector.MatchExpressions).To(Equal([]metav1.LabelSelectorRequirement{{
+			Key:      corev1.LabelMetadataName,
+			Operator: metav1.LabelSelectorOpIn,
+			Values:   []string{"atlas-system"},
+		}}))
+		Expect(from[1].PodSelector.MatchLabels).To(Equal(map[string]string{"app": "atlas-controller"}))
+
+		// Running outside a pod the namespace is unknown
+		from = desiredNetworkPolicy(atlasApp, "").Spec.Ingress[0].From
+		Expect(from[1].NamespaceSelector).To(BeNil())
+		Expect(from[1].PodSelector.MatchLabels).To(Equal(map[string]string{"app": "atlas-controller"}))
+	})
+
+	It("allows DNS and the egress destinations of the spec", func() {
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		reconcileApp(r, atlasApp)
+
+		egress := getPolicy(key).Spec.Egress
+		Expect(egress).To(HaveLen(3))
+		Expect(egress[0].To[0].PodSelector.MatchLabels).To(Equal(selectorLabels(atlasApp)))
+		Expect(egress[1].To[0].PodSelector.MatchLabels).To(HaveKeyWithValue("k8s-app", "kube-dns"))
+		Expect(egress[1].Ports).To(HaveLen(2))
+		Expect(egress[2].To[0].IPBlock.CIDR).To(Equal("10.20.0.0/16"))
+		Expect(egress[2].Ports[0].Port.IntVal).To(Equal(int32(5432)))
+	})
+
+	It("leaves DNS out when disabled", func() {
+		atlasApp.Spec.NetworkPolicy.DisableDNS = true
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		reconcileApp(r, atlasApp)
+
+		egress := getPolicy(key).Spec.Egress
+		Expect(egress).To(HaveLen(2))
+		Expect(egress[1].To[0].IPBlock.CIDR).To(Equal("10.20.0.0/16"))
+	})
+
+	It("lets the migration Job reach the egress destinations before the rollout", func() {
+		atlasApp.Spec.MigrationId = 3
+		atlasApp.Spec.Migration = &atlasv1.MigrationSpec{Command: []string{"/app/migrate"}}
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		reconcileApp(r, atlasApp)
+
+		job := &batchv1.Job{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "atlas-migrate-3", Namespace: atlasApp.Namespace}, job)).To(Succeed())
+
+		jobsPolicy := getPolicy(jobsKey)
+		Expect(selects(jobsPolicy, job.Spec.Template.Labels)).To(BeTrue())
+		Expect(selects(getPolicy(key), job.Spec.Template.Labels)).To(BeFalse())
+		Expect(jobsPolicy.Spec.Ingress).To(BeEmpty())
+		Expect(jobsPolicy.Spec.PolicyTypes).To(ContainElement(networkingv1.PolicyTypeIngress))
+		Expect(jobsPolicy.Spec.Egress).To(HaveLen(2))
+		Expect(jobsPolicy.Spec.Egress[1].To[0].IPBlock.CIDR).To(Equal("10.20.0.0/16"))
+	})
+
+	It("removes both policies once spec.networkPolicy is unset", func() {
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		_, updated := reconcileApp(r, atlasApp)
+
+		updated.Spec.NetworkPolicy = nil
+		Expect(k8sClient.Update(ctx, updated)).To(Succeed())
+		reconcileApp(r, atlasApp)
+		Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &networkingv1.NetworkPolicy{}))).To(BeTrue())
+		Expect(errors.IsNotFound(k8sClient.Get(ctx, jobsKey, &networkingv1.NetworkPolicy{}))).To(BeTrue())
+	})
+})
@@ -0,0 +1,69 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+const reasonPipelineInvalid = "PipelineInvalid"
+
+// unknownStageError reports an environment that is not a stage of the pipeline
+type unknownStageError struct {
+	pipeline    string
+	environment string
+}
+
+func (e *unknownStageError) Error() string {
+	return fmt.Sprintf("environment %q is not a stage of pipeline %s", e.environment, e.pipeline)
+}
+
+// nextStages returns the stages an AtlasApp promotes to: spec.nextEnvironment if
+// set, else all stages following its environment. It is empty for the last stage.
+func nextStages(atlasApp *atlasv1.AtlasApp, pipeline *atlasv1.AtlasPipelineSpec) ([]*atlasv1.PipelineStage, error) {
+	if atlasApp.Spec.NextEnvironment == "" {
+		return pipeline.NextStages(atlasApp.Spec.Environment)
+	}
+
+	stage, ok := pipeline.Stage(atlasApp.Spec.NextEnvironment)
+	if !ok {
+		return nil, &unknownStageError{pipeline: atlasv1.PipelineDisplayName(atlasApp.Spec.Pipeline), environment: atlasApp.Spec.NextEnvironment}
+	}
+	return []*atlasv1.PipelineStage{stage}, nil
+}
+
+// appsForPipeline maps an AtlasPipeline to the AtlasApps referencing it
+func (r *AtlasAppReconciler) appsForPipeline(ctx context.Context, obj client.Object) []reconcile.Request {
+	var apps atlasv1.AtlasAppList
+	if err := r.List(ctx, &apps); err != nil {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, app := range apps.Items {
+		if app.Spec.Pipeline == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&app)})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	goerrors "errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+// newPipeline creates an AtlasPipeline with a unique name
+func newPipeline(stages ...atlasv1.PipelineStage) string {
+	pipeline := &atlasv1.AtlasPipeline{
+		ObjectMeta: metav1.ObjectMeta{Name: "pipeline-" + rand.String(5)},
+		Spec:       atlasv1.AtlasPipelineSpec{Stages: stages},
+	}
+	Expect(k8sClient.Create(ctx, pipeline)).To(Succeed())
+	return pipeline.Name
+}
+
+// stageNames returns the names of the stages
+func stageNames(stages []*atlasv1.PipelineStage) []string {
+	var names []string
+	for _, stage := range stages {
+		names = append(names, stage.Name)
+	}
+	return names
+}
+
+var _ = Describe("Promotion pipelines", func() {
+	var pipeline string
+
+	BeforeEach(func() {
+		pipeline = newPipeline(
+			atlasv1.PipelineStage{Name: "dev", Namespace: "payments-dev", AutoPromote: true},
+			atlasv1.PipelineStage{Name: "qa", Namespace: "payments-qa", AutoPromote: true},
+			atlasv1.PipelineStage{Name: "prod", Namespace: "payments-prod", RequireApproval: true},
+		)
+	})
+
+	It("uses dev, stage and prod without a pipeline reference", func() {
+		spec, err := atlasv1.GetPipeline(ctx, k8sClient, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.Stages).To(HaveLen(3))
+		Expect(spec.Stages[0].Name).To(Equal("dev"))
+		Expect(spec.Stages[2].Name).To(Equal("prod"))
+		Expect(spec.Stages[2].RequireApproval).To(BeTrue())
+		Expect(atlasv1.PipelineDisplayName("")).To(Equal("default"))
+	})
+
+	It("reports a missing pipeline as not found", func() {
+		_, err := atlasv1.GetPipeline(ctx, k8sClient, "missing")
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("promotes to the stage following the environment", func() {
+		spec, err := atlasv1.GetPipeline(ctx, k8sClient, pipeline)
+		Expect(err).NotTo(HaveOccurred())
+
+		atlasApp := newApp("payments-dev", "dev", "1.0.0")
+		atlasApp.Spec.Pipeline = pipeline
+		stages, err := nextStages(atlasApp, spec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stageNames(stages)).To(Equal([]string{"qa"}))
+		Expect(stages[0].StageNamespace()).To(Equal("payments-qa"))
+
+		atlasApp.Spec.Environment = "prod"
+		stages, err = nextStages(atlasApp, spec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stages).To(BeEmpty())
+	})
+
+	It("reports a nextEnvironment that is not a stage", func() {
+		spec, err := atlasv1.GetPipeline(ctx, k8sClient, pipeline)
+		Expect(err).NotTo(HaveOccurred())
+
+		atlasApp := newApp("payments-dev", "dev", "1.0.0")
+		atlasApp.Spec.Pipeline = pipeline
+		atlasApp.Spec.NextEnvironment = "staging"
+		_, err = nextStages(atlasApp, spec)
+		var stageErr *unknownStageError
+		Expect(goerrors.As(err, &stageErr)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring(pipeline))
+	})
+
+	It("rejects stages with the same name", func() {
+		duplicate := &atlasv1.AtlasPipeline{
+			ObjectMeta: metav1.ObjectMeta{Name: pipeline + "-regions"},
+			Spec: atlasv1.AtlasPipelineSpec{Stages: []atlasv1.PipelineStage{
+				{Name: "stage"},
+				{Name: "prod", Namespace: "prod-eu"},
+				{Name: "prod", Namespace: "prod-us"},
+			}},
+		}
+		err := k8sClient.Create(ctx, duplicate)
+		Expect(errors.IsInvalid(err)).To(BeTrue())
+	})
+
+	It("maps a pipeline to the AtlasApps referencing it", func() {
+		namespace := newNamespace("pipeline")
+		atlasApp := newApp(namespace, "dev", "1.0.0")
+		atlasApp.Spec.Pipeline = pipeline
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		other := newApp(namespace, "dev", "1.0.0")
+		other.Name = "other"
+		Expect(k8sClient.Create(ctx, other)).To(Succeed())
+
+		requests := newAppReconciler().appsForPipeline(ctx, &atlasv1.AtlasPipeline{ObjectMeta: metav1.ObjectMeta{Name: pipeline}})
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].NamespacedName).To(Equal(client.ObjectKeyFromObject(atlasApp)))
+	})
+
+	Context("with stages listing next", func() {
+		var spec *atlasv1.AtlasPipelineSpec
+
+		BeforeEach(func() {
+			spec = &atlasv1.AtlasPipelineSpec{Stages: []atlasv1.PipelineStage{
+				{Name: "dev", Next: []string{"qa", "perf"}},
+				{Name: "qa", Next: []string{"prod-eu", "prod-us"}},
+				{Name: "perf"},
+				{Name: "prod-eu", RequireApproval: true},
+				{Name: "prod-us", RequireApproval: true},
+			}}
+		})
+
+		It("fans out to all stages listed in next", func() {
+			atlasApp := newApp("payments-qa", "qa", "1.0.0")
+			stages, err := nextStages(atlasApp, spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stageNames(stages)).To(Equal([]string{"prod-eu", "prod-us"}))
+
+			// nextEnvironment picks one of them
+			atlasApp.Spec.NextEnvironment = "prod-us"
+			stages, err = nextStages(atlasApp, spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stageNames(stages)).To(Equal([]string{"prod-us"}))
+		})
+
+		It("treats stages without next as final", func() {
+			for _, environment := range []string{"perf", "prod-eu", "prod-us"} {
+				stages, err := spec.NextStages(environment)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stages).To(BeEmpty(), environment)
+			}
+		})
+
+		It("rejects next naming an earlier or unknown stage", func() {
+			spec.Stages[2].Next = []string{"qa"}
+			_, err := spec.NextStages("perf")
+			Expect(err).To(MatchError(ContainSubstring("perf promotes to qa, which is not a later stage")))
+
+			spec.Stages[0].Next = []string{"staging"}
+			_, err = spec.NextStages("dev")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("only sets nextEnvironment on created apps with a single next stage", func() {
+			source := newApp("payments-dev", "dev", "1.0.0")
+			qa, _ := spec.Stage("qa")
+			Expect(promotedAppSpec(source, spec, qa).NextEnvironment).To(BeEmpty())
+
+			spec.Stages[1].Next = []string{"prod-eu"}
+			Expect(promotedAppSpec(source, spec, qa).NextEnvironment).To(Equal("prod-eu"))
+		})
+	})
+
+	It("promotes a ready AtlasApp to every next stage", func() {
+		qaNamespace := newNamespace("qa")
+		perfNamespace := newNamespace("perf")
+		prodNamespace := newNamespace("prod-eu")
+		pipeline := newPipeline(
+			atlasv1.PipelineStage{Name: "qa", Namespace: qaNamespace, Next: []string{"perf", "prod-eu"}},
+			atlasv1.PipelineStage{Name: "perf", Namespace: perfNamespace},
+			atlasv1.PipelineStage{Name: "prod-eu", Namespace: prodNamespace, RequireApproval: true},
+		)
+
+		r := newAppReconciler()
+		atlasApp := newApp(qaNamespace, "qa", "1.0.0")
+		atlasApp.Spec.HealthCheckPath = ""
+		atlasApp.Spec.Pipeline = pipeline
+		atlasApp.Spec.AutoPromote = true
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		reconcileApp(r, atlasApp)
+		markDeploymentReady(client.ObjectKeyFromObject(atlasApp))
+		_, updated := reconcileApp(r, atlasApp)
+
+		// perf is promoted right away, prod-eu waits for its approval
+		perf := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "atlas-perf", Namespace: perfNamespace}, perf)).To(Succeed())
+		Expect(perf.Spec.Version).To(Equal("1.0.0"))
+		Expect(perf.Spec.NextEnvironment).To(BeEmpty())
+
+		var promotions atlasv1.AtlasPromotionList
+		Expect(k8sClient.List(ctx, &promotions, client.InNamespace(prodNamespace))).To(Succeed())
+		Expect(promotions.Items).To(HaveLen(1))
+		Expect(promotions.Items[0].Spec.TargetEnvironment).To(Equal("prod-eu"))
+
+		Expect(updated.Status.PromotionPending).To(BeTrue())
+		condition := meta.FindStatusCondition(updated.Status.Conditions, atlasv1.ConditionPromoted)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal(reasonAwaitingApproval))
+		Expect(condition.Message).To(ContainSubstring("Version 1.0.0 promoted to perf"))
+		Expect(condition.Message).To(ContainSubstring("Promotion to prod-eu awaits approval"))
+	})
+})
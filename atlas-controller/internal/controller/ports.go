@@ -0,0 +1,157 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+const (
+	// defaultPortName names the port used when the spec lists none
+	defaultPortName = "http"
+
+	// defaultPort is the container and service port used when the spec lists none
+	defaultPort = 80
+)
+
+var (
+	// livenessProbeDefaults are the timings of liveness probes left empty in the spec
+	livenessProbeDefaults = atlasv1.ProbeSpec{InitialDelaySeconds: 30, PeriodSeconds: 10}
+
+	// readinessProbeDefaults are the timings of readiness probes left empty in the spec
+	readinessProbeDefaults = atlasv1.ProbeSpec{InitialDelaySeconds: 5, PeriodSeconds: 5}
+
+	// startupProbeDefaults give applications five minutes to start
+	startupProbeDefaults = atlasv1.ProbeSpec{PeriodSeconds: 10, FailureThreshold: 30}
+)
+
+// appPorts returns the ports of the AtlasApp with defaults applied
+func appPorts(atlasApp *atlasv1.AtlasApp) []atlasv1.PortSpec {
+	if len(atlasApp.Spec.Ports) == 0 {
+		return []atlasv1.PortSpec{{
+			Name:          defaultPortName,
+			ContainerPort: defaultPort,
+			ServicePort:   defaultPort,
+			Protocol:      corev1.ProtocolTCP,
+		}}
+	}
+
+	ports := make([]atlasv1.PortSpec, 0, len(atlasApp.Spec.Ports))
+	for _, port := range atlasApp.Spec.Ports {
+		if port.ServicePort == 0 {
+			port.ServicePort = port.ContainerPort
+		}
+		if port.Protocol == "" {
+			port.Protocol = corev1.ProtocolTCP
+		}
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// appPort returns the named port of the AtlasApp, or its first port if name is
+// empty or unknown
+func appPort(atlasApp *atlasv1.AtlasApp, name string) atlasv1.PortSpec {
+	ports := appPorts(atlasApp)
+	for _, port := range ports {
+		if port.Name == name {
+			return port
+		}
+	}
+	return ports[0]
+}
+
+// containerPorts returns the ports of the application container
+func containerPorts(atlasApp *atlasv1.AtlasApp) []corev1.ContainerPort {
+	var ports []corev1.ContainerPort
+	for _, port := range appPorts(atlasApp) {
+		ports = append(ports, corev1.ContainerPort{
+			Name:          port.Name,
+			ContainerPort: port.ContainerPort,
+			Protocol:      port.Protocol,
+		})
+	}
+	return ports
+}
+
+// servicePorts returns the ports of the managed Service, node ports are only
+// set on Services that have them
+func servicePorts(atlasApp *atlasv1.AtlasApp) []corev1.ServicePort {
+	var ports []corev1.ServicePort
+	for _, port := range appPorts(atlasApp) {
+		servicePort := corev1.ServicePort{
+			Name:       port.Name,
+			Port:       port.ServicePort,
+			TargetPort: intstr.FromInt32(port.ContainerPort),
+			Protocol:   port.Protocol,
+		}
+		if externalService(atlasApp) {
+			servicePort.NodePort = port.NodePort
+		}
+		ports = append(ports, servicePort)
+	}
+	return ports
+}
+
+// containerProbe returns the probe configured by spec, with the given timings
+// used for the fields it leaves empty. A nil spec yields a nil probe unless
+// the probe is always set, like liveness and readiness.
+func containerProbe(atlasApp *atlasv1.AtlasApp, spec *atlasv1.ProbeSpec, defaults atlasv1.ProbeSpec, always bool) *corev1.Probe {
+	if spec == nil {
+		if !always {
+			return nil
+		}
+		spec = &atlasv1.ProbeSpec{}
+	}
+
+	probe := &corev1.Probe{
+		InitialDelaySeconds: spec.InitialDelaySeconds,
+		PeriodSeconds:       spec.PeriodSeconds,
+		TimeoutSeconds:      spec.TimeoutSeconds,
+		FailureThreshold:    spec.FailureThreshold,
+	}
+	if probe.InitialDelaySeconds == 0 {
+		probe.InitialDelaySeconds = defaults.InitialDelaySeconds
+	}
+	if probe.PeriodSeconds == 0 {
+		probe.PeriodSeconds = defaults.PeriodSeconds
+	}
+	if probe.TimeoutSeconds == 0 {
+		probe.TimeoutSeconds = defaults.TimeoutSeconds
+	}
+	if probe.FailureThreshold == 0 {
+		probe.FailureThreshold = defaults.FailureThreshold
+	}
+
+	port := intstr.FromInt32(appPort(atlasApp, spec.Port).ContainerPort)
+	switch spec.Type {
+	case atlasv1.ProbeTypeTCPSocket:
+		probe.TCPSocket = &corev1.TCPSocketAction{Port: port}
+	case atlasv1.ProbeTypeExec:
+		probe.Exec = &corev1.ExecAction{Command: spec.Command}
+	default:
+		path := spec.Path
+		if path == "" {
+			path = "/"
+		}
+		probe.HTTPGet = &corev1.HTTPGetAction{Path: path, Port: port}
+	}
+	return probe
+}
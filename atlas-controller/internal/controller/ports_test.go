@@ -0,0 +1,106 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+var _ = Describe("Container ports and probes", func() {
+	var (
+		r        *AtlasAppReconciler
+		atlasApp *atlasv1.AtlasApp
+	)
+
+	BeforeEach(func() {
+		r = newAppReconciler()
+		atlasApp = newApp(newNamespace("ports"), "dev", "1.0.0")
+		atlasApp.Spec.HealthCheckPath = ""
+	})
+
+	container := func() corev1.Container {
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		reconcileApp(r, atlasApp)
+		return getDeployment(client.ObjectKeyFromObject(atlasApp)).Spec.Template.Spec.Containers[0]
+	}
+
+	It("defaults to an HTTP port and probes on it", func() {
+		app := container()
+		Expect(app.Ports).To(HaveLen(1))
+		Expect(app.Ports[0].Name).To(Equal("http"))
+		Expect(app.Ports[0].ContainerPort).To(Equal(int32(80)))
+		Expect(app.Ports[0].Protocol).To(Equal(corev1.ProtocolTCP))
+
+		Expect(app.LivenessProbe.HTTPGet.Path).To(Equal("/"))
+		Expect(app.LivenessProbe.HTTPGet.Port).To(Equal(intstr.FromInt32(80)))
+		Expect(app.LivenessProbe.InitialDelaySeconds).To(Equal(int32(30)))
+		Expect(app.LivenessProbe.PeriodSeconds).To(Equal(int32(10)))
+		Expect(app.ReadinessProbe.HTTPGet).NotTo(BeNil())
+		Expect(app.ReadinessProbe.InitialDelaySeconds).To(Equal(int32(5)))
+		Expect(app.ReadinessProbe.PeriodSeconds).To(Equal(int32(5)))
+		Expect(app.StartupProbe).To(BeNil())
+	})
+
+	It("sets the listed ports and probes on the container", func() {
+		atlasApp.Spec.Ports = []atlasv1.PortSpec{
+			{Name: "http", ContainerPort: 8080, ServicePort: 80},
+			{Name: "metrics", ContainerPort: 9090},
+			{Name: "dns", ContainerPort: 5353, Protocol: corev1.ProtocolUDP},
+		}
+		atlasApp.Spec.LivenessProbe = &atlasv1.ProbeSpec{Type: atlasv1.ProbeTypeHTTPGet, Path: "/live", Port: "metrics", PeriodSeconds: 20}
+		atlasApp.Spec.ReadinessProbe = &atlasv1.ProbeSpec{Type: atlasv1.ProbeTypeTCPSocket, TimeoutSeconds: 3}
+		atlasApp.Spec.StartupProbe = &atlasv1.ProbeSpec{Type: atlasv1.ProbeTypeExec, Command: []string{"cat", "/tmp/started"}}
+
+		app := container()
+		Expect(app.Ports).To(HaveLen(3))
+		Expect(app.Ports[0].ContainerPort).To(Equal(int32(8080)))
+		Expect(app.Ports[1].Name).To(Equal("metrics"))
+		Expect(app.Ports[1].Protocol).To(Equal(corev1.ProtocolTCP))
+		Expect(app.Ports[2].Protocol).To(Equal(corev1.ProtocolUDP))
+
+		Expect(app.LivenessProbe.HTTPGet.Path).To(Equal("/live"))
+		Expect(app.LivenessProbe.HTTPGet.Port).To(Equal(intstr.FromInt32(9090)))
+		Expect(app.LivenessProbe.PeriodSeconds).To(Equal(int32(20)))
+		Expect(app.LivenessProbe.InitialDelaySeconds).To(Equal(int32(30)))
+
+		// Probes without a port use the first one
+		Expect(app.ReadinessProbe.HTTPGet).To(BeNil())
+		Expect(app.ReadinessProbe.TCPSocket.Port).To(Equal(intstr.FromInt32(8080)))
+		Expect(app.ReadinessProbe.TimeoutSeconds).To(Equal(int32(3)))
+
+		Expect(app.StartupProbe.Exec.Command).To(Equal([]string{"cat", "/tmp/started"}))
+		Expect(app.StartupProbe.PeriodSeconds).To(Equal(int32(10)))
+		Expect(app.StartupProbe.FailureThreshold).To(Equal(int32(30)))
+	})
+
+	It("defaults the service port to the container port", func() {
+		atlasApp.Spec.Ports = []atlasv1.PortSpec{{Name: "http", ContainerPort: 8080, ServicePort: 80}, {Name: "metrics", ContainerPort: 9090}}
+		ports := servicePorts(atlasApp)
+		Expect(ports).To(HaveLen(2))
+		Expect(ports[0].Port).To(Equal(int32(80)))
+		Expect(ports[0].TargetPort).To(Equal(intstr.FromInt32(8080)))
+		Expect(ports[1].Port).To(Equal(int32(9090)))
+		Expect(appPort(atlasApp, "unknown").Name).To(Equal("http"))
+	})
+})
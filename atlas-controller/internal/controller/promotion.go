@@ -0,0 +1,325 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+const reasonPromotionRejected = "PromotionRejected"
+
+// invalidNameChars matches characters that are not allowed in object names
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// promotedAppSpec returns the spec of a newly created AtlasApp receiving a
+// promotion from source into the given pipeline stage, with the source's
+// overrides for the stage applied. The deletion policy and pre-delete hooks are
+// not promoted, deleting a dev app must not cascade down to prod.
+func promotedAppSpec(source *atlasv1.AtlasApp, pipeline *atlasv1.AtlasPipelineSpec, stage *atlasv1.PipelineStage) atlasv1.AtlasAppSpec {
+	// Apps in a stage fanning out to several stages promote to all of them
+	var nextEnvironment string
+	if next, _ := pipeline.NextStages(stage.Name); len(next) == 1 {
+		nextEnvironment = next[0].Name
+	}
+
+	spec := atlasv1.AtlasAppSpec{
+		Environment:      stage.Name,
+		Version:          source.Spec.Version,
+		Image:            source.Spec.Image,
+		ImageDigest:      source.Spec.ImageDigest,
+		ImagePullSecrets: source.Spec.ImagePullSecrets,
+		MigrationId:      source.Spec.MigrationId,
+		Migration:        source.Spec.Migration,
+		Replicas:         source.Spec.Replicas,
+		Autoscaling:      source.Spec.Autoscaling,
+		Resources:        source.Spec.Resources,
+		Env:              source.Spec.Env,
+		EnvFrom:          source.Spec.EnvFrom,
+		Volumes:          source.Spec.Volumes,
+		VolumeMounts:     source.Spec.VolumeMounts,
+		DisruptionBudget: source.Spec.DisruptionBudget,
+		Affinity:         source.Spec.Affinity,
+		Ports:            promotedPorts(source.Spec.Ports),
+		LivenessProbe:    source.Spec.LivenessProbe,
+		ReadinessProbe:   source.Spec.ReadinessProbe,
+		StartupProbe:     source.Spec.StartupProbe,
+		Service:          source.Spec.Service,
+		Exposure:         source.Spec.Exposure,
+		NetworkPolicy:    source.Spec.NetworkPolicy,
+		Pipeline:         source.Spec.Pipeline,
+		AutoPromote:      stage.AutoPromote,
+		NextEnvironment:  nextEnvironment,
+		RequireApproval:  stage.RequireApproval,
+		Overrides:        source.Spec.Overrides,
+		HealthCheckPath:  source.Spec.HealthCheckPath,
+		HealthCheck:      source.Spec.HealthCheck,
+		Strategy:         source.Spec.Strategy,
+		AutoRollback:     source.Spec.AutoRollback,
+
+		TopologySpreadConstraints: source.Spec.TopologySpreadConstraints,
+	}
+	applyOverride(&spec, source.Spec.Overrides)
+	return spec
+}
+
+// promotedPorts returns a copy of ports without node ports, which are unique
+// in the cluster and cannot be shared with the source app
+func promotedPorts(ports []atlasv1.PortSpec) []atlasv1.PortSpec {
+	if ports == nil {
+		return nil
+	}
+	promoted := make([]atlasv1.PortSpec, len(ports))
+	for i, port := range ports {
+		port.NodePort = 0
+		promoted[i] = port
+	}
+	return promoted
+}
+
+// applyOverride replaces the promoted settings with the override for the spec's environment
+func applyOverride(spec *atlasv1.AtlasAppSpec, overrides []atlasv1.EnvironmentOverride) {
+	for _, override := range overrides {
+		if override.Environment != spec.Environment {
+			continue
+		}
+
+		if override.Replicas != nil {
+			spec.Replicas = *override.Replicas
+		}
+		if override.Autoscaling != nil {
+			spec.Autoscaling = override.Autoscaling
+		}
+		if override.Resources != nil {
+			spec.Resources = *override.Resources
+		}
+		if override.Service != nil {
+			spec.Service = override.Service
+		}
+		if len(override.Env) > 0 {
+			env := make([]corev1.EnvVar, 0, len(spec.Env)+len(override.Env))
+			for _, v := range spec.Env {
+				if !slices.ContainsFunc(override.Env, func(o corev1.EnvVar) bool { return o.Name == v.Name }) {
+					env = append(env, v)
+				}
+			}
+			spec.Env = append(env, override.Env...)
+		}
+		return
+	}
+}
+
+// promoteToApp creates the target AtlasApp from spec, or moves an existing one
+// to the promoted image, version and migration and re-applies the source's
+// overrides for its environment. The source the version came from is recorded
+// in annotations on the target. It returns the generation of the target that
+// was written, or 0 if nothing changed.
+func promoteToApp(ctx context.Context, c client.Client, key types.NamespacedName, spec atlasv1.AtlasAppSpec, source *atlasv1.AtlasApp) (int64, error) {
+	log := log.FromContext(ctx)
+
+	annotations := map[string]string{
+		atlasv1.PromotedFromAnnotation:    client.ObjectKeyFromObject(source).String(),
+		atlasv1.PromotedVersionAnnotation: spec.Version,
+	}
+
+	existingApp := &atlasv1.AtlasApp{}
+	err := c.Get(ctx, key, existingApp)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating AtlasApp in next environment", "environment", spec.Environment, "version", spec.Version)
+		app := &atlasv1.AtlasApp{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        key.Name,
+				Namespace:   key.Namespace,
+				Annotations: annotations,
+			},
+			Spec: spec,
+		}
+		if err := c.Create(ctx, app); err != nil {
+			return 0, err
+		}
+		return app.Generation, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	if err := atlasv1.CheckDowngrade(existingApp.Spec.Version, existingApp.Spec.MigrationId, spec.Version, spec.MigrationId); err != nil {
+		return 0, err
+	}
+
+	// Update existing app if the release or the overrides for it changed, its
+	// other settings are its own
+	updated := existingApp.Spec.DeepCopy()
+	updated.Version = spec.Version
+	updated.Image = spec.Image
+	updated.ImageDigest = spec.ImageDigest
+	updated.ImagePullSecrets = spec.ImagePullSecrets
+	updated.MigrationId = spec.MigrationId
+	updated.Overrides = source.Spec.Overrides
+	applyOverride(updated, source.Spec.Overrides)
+	if equality.Semantic.DeepEqual(&existingApp.Spec, updated) {
+		return 0, nil
+	}
+
+	log.Info("Updating AtlasApp in next environment", "environment", spec.Environment, "version", spec.Version)
+	existingApp.Spec = *updated
+	for k, v := range annotations {
+		metav1.SetMetaDataAnnotation(&existingApp.ObjectMeta, k, v)
+	}
+	if err := c.Update(ctx, existingApp); err != nil {
+		return 0, err
+	}
+	return existingApp.Generation, nil
+}
+
+// checkPromotionOrder returns a DowngradeError if the target AtlasApp already
+// runs a higher version or migration ID than the one being promoted
+func checkPromotionOrder(ctx context.Context, c client.Reader, key types.NamespacedName, version string, migrationId int) error {
+	target := &atlasv1.AtlasApp{}
+	if err := c.Get(ctx, key, target); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return atlasv1.CheckDowngrade(target.Spec.Version, target.Spec.MigrationId, version, migrationId)
+}
+
+// promotionName returns a stable AtlasPromotion name for promoting the current
+// version and migration of an AtlasApp to the next environment
+func promotionName(atlasApp *atlasv1.AtlasApp, nextEnv string) string {
+	name := fmt.Sprintf("%s-to-%s-%s-m%d", atlasApp.Name, nextEnv, atlasApp.Spec.Version, atlasApp.Spec.MigrationId)
+	name = strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(name), "-"), "-.")
+	if len(name) > 253 {
+		name = name[:253]
+	}
+	return name
+}
+
+// stagePromotion is the outcome of promoting an AtlasApp to one of its next stages
+type stagePromotion struct {
+	// pending is set while an AtlasPromotion awaits a decision
+	pending bool
+	status  metav1.ConditionStatus
+	reason  string
+	message string
+}
+
+// setPromotedCondition sums up the promotions to all next stages in the
+// Promoted condition. The first stage that was not promoted gives the reason.
+func setPromotedCondition(atlasApp *atlasv1.AtlasApp, promotions []stagePromotion) {
+	status, reason := metav1.ConditionTrue, reasonPromoted
+	var messages, pending []string
+	for _, promotion := range promotions {
+		messages = append(messages, promotion.message)
+		if promotion.pending {
+			pending = append(pending, promotion.message)
+		}
+		if status == metav1.ConditionTrue && promotion.status != metav1.ConditionTrue {
+			status, reason = promotion.status, promotion.reason
+		}
+	}
+
+	atlasApp.Status.PromotionPending = len(pending) > 0
+	if atlasApp.Status.PromotionPending {
+		atlasApp.Status.Message = strings.Join(pending, "; ")
+	}
+	setCondition(atlasApp, atlasv1.ConditionPromoted, status, reason, strings.Join(messages, "; "))
+}
+
+// requestPromotion makes sure an AtlasPromotion exists for the current version
+// and returns its outcome
+func (r *AtlasAppReconciler) requestPromotion(ctx context.Context, atlasApp *atlasv1.AtlasApp, nextEnv string, target types.NamespacedName) (stagePromotion, error) {
+	log := log.FromContext(ctx)
+
+	promotion := &atlasv1.AtlasPromotion{}
+	key := types.NamespacedName{Name: promotionName(atlasApp, nextEnv), Namespace: target.Namespace}
+	err := r.Get(ctx, key, promotion)
+	if err != nil && errors.IsNotFound(err) {
+		promotion = &atlasv1.AtlasPromotion{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: key.Namespace,
+				Labels: map[string]string{
+					atlasv1.SourceNamespaceLabel: atlasApp.Namespace,
+					atlasv1.SourceNameLabel:      atlasApp.Name,
+				},
+			},
+			Spec: atlasv1.AtlasPromotionSpec{
+				Source:            atlasv1.AppReference{Name: atlasApp.Name, Namespace: atlasApp.Namespace},
+				Target:            atlasv1.AppReference{Name: target.Name, Namespace: target.Namespace},
+				TargetEnvironment: nextEnv,
+				Version:           atlasApp.Spec.Version,
+				Image:             atlasApp.Spec.Image,
+				ImageDigest:       atlasApp.Spec.ImageDigest,
+				MigrationId:       atlasApp.Spec.MigrationId,
+				Decision:          atlasv1.PromotionDecisionPending,
+			},
+		}
+
+		log.Info("Requesting promotion", "promotion", key, "version", atlasApp.Spec.Version)
+		if err := r.Create(ctx, promotion); err != nil {
+			return stagePromotion{}, err
+		}
+		r.Recorder.Eventf(atlasApp, corev1.EventTypeNormal, "PromotionRequested",
+			"Requested promotion of version %s to %s as AtlasPromotion %s/%s", atlasApp.Spec.Version, nextEnv, key.Namespace, key.Name)
+	} else if err != nil {
+		return stagePromotion{}, err
+	}
+
+	switch promotion.Status.Phase {
+	case atlasv1.PromotionPhaseApplied:
+		return stagePromotion{status: metav1.ConditionTrue, reason: reasonPromoted,
+			message: fmt.Sprintf("Version %s promoted to %s by AtlasPromotion %s/%s", atlasApp.Spec.Version, nextEnv, key.Namespace, key.Name)}, nil
+	case atlasv1.PromotionPhaseRejected:
+		return stagePromotion{status: metav1.ConditionFalse, reason: reasonPromotionRejected,
+			message: fmt.Sprintf("AtlasPromotion %s/%s was rejected by %s: %s", key.Namespace, key.Name, promotion.Spec.DecidedBy, promotion.Spec.Reason)}, nil
+	default:
+		return stagePromotion{pending: true, status: metav1.ConditionFalse, reason: reasonAwaitingApproval,
+			message: fmt.Sprintf("Promotion to %s awaits approval of AtlasPromotion %s/%s", nextEnv, key.Namespace, key.Name)}, nil
+	}
+}
+
+// promotionToApps maps an AtlasPromotion to the AtlasApp that requested it, and
+// to its target in the same namespace, whose approval gate it may satisfy
+func promotionToApps(ctx context.Context, obj client.Object) []reconcile.Request {
+	var requests []reconcile.Request
+	labels := obj.GetLabels()
+	if labels[atlasv1.SourceNameLabel] != "" && labels[atlasv1.SourceNamespaceLabel] != "" {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      labels[atlasv1.SourceNameLabel],
+			Namespace: labels[atlasv1.SourceNamespaceLabel],
+		}})
+	}
+	if promotion, ok := obj.(*atlasv1.AtlasPromotion); ok && promotion.Spec.Target.Name != "" {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      promotion.Spec.Target.Name,
+			Namespace: promotion.Namespace,
+		}})
+	}
+	return requests
+}
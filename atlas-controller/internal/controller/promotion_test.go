@@ -0,0 +1,151 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+var _ = Describe("Promoting to the next environment", func() {
+	var (
+		source   *atlasv1.AtlasApp
+		pipeline atlasv1.AtlasPipelineSpec
+		target   types.NamespacedName
+	)
+
+	prodResources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+	}
+
+	BeforeEach(func() {
+		pipeline = atlasv1.DefaultPipelineSpec()
+		source = newApp(newNamespace("promotion-stage"), "stage", "1.22.0")
+		source.Spec.Replicas = 2
+		source.Spec.Env = []corev1.EnvVar{
+			{Name: "LOG_LEVEL", Value: "debug"},
+			{Name: "DATABASE_HOST", Value: "db.stage"},
+		}
+		prodReplicas := int32(4)
+		source.Spec.Overrides = []atlasv1.EnvironmentOverride{{
+			Environment: "prod",
+			Replicas:    &prodReplicas,
+			Resources:   &prodResources,
+			Env:         []corev1.EnvVar{{Name: "DATABASE_HOST", Value: "db.prod"}},
+		}}
+		Expect(k8sClient.Create(ctx, source)).To(Succeed())
+		target = types.NamespacedName{Name: "atlas", Namespace: newNamespace("promotion-prod")}
+	})
+
+	promote := func() int64 {
+		latest := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(source), latest)).To(Succeed())
+		spec := promotedAppSpec(latest, &pipeline, &pipeline.Stages[2])
+		generation, err := promoteToApp(ctx, k8sClient, target, spec, latest)
+		Expect(err).NotTo(HaveOccurred())
+		return generation
+	}
+
+	getTarget := func() *atlasv1.AtlasApp {
+		app := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, target, app)).To(Succeed())
+		return app
+	}
+
+	updateSource := func(update func(spec *atlasv1.AtlasAppSpec)) {
+		latest := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(source), latest)).To(Succeed())
+		update(&latest.Spec)
+		Expect(k8sClient.Update(ctx, latest)).To(Succeed())
+	}
+
+	It("creates the target with the overrides for its environment", func() {
+		Expect(promote()).NotTo(BeZero())
+
+		app := getTarget()
+		Expect(app.Spec.Environment).To(Equal("prod"))
+		Expect(app.Spec.Replicas).To(Equal(int32(4)))
+		Expect(app.Spec.Resources.Requests.Cpu().String()).To(Equal("500m"))
+		Expect(app.Spec.Env).To(Equal([]corev1.EnvVar{
+			{Name: "LOG_LEVEL", Value: "debug"},
+			{Name: "DATABASE_HOST", Value: "db.prod"},
+		}))
+		Expect(app.Spec.RequireApproval).To(BeTrue())
+		Expect(app.Annotations).To(HaveKeyWithValue(atlasv1.PromotedVersionAnnotation, "1.22.0"))
+	})
+
+	It("re-applies changed overrides to an existing target", func() {
+		promote()
+
+		// Settings of the target that are not overridden stay its own
+		app := getTarget()
+		app.Spec.HealthCheckPath = "/ready"
+		app.Spec.Env = append(app.Spec.Env, corev1.EnvVar{Name: "FEATURE_FLAGS", Value: "prod"})
+		Expect(k8sClient.Update(ctx, app)).To(Succeed())
+
+		prodReplicas := int32(6)
+		updateSource(func(spec *atlasv1.AtlasAppSpec) {
+			spec.Version = "1.23.0"
+			spec.Overrides[0].Replicas = &prodReplicas
+		})
+		Expect(promote()).NotTo(BeZero())
+
+		app = getTarget()
+		Expect(app.Spec.Version).To(Equal("1.23.0"))
+		Expect(app.Spec.Replicas).To(Equal(int32(6)))
+		Expect(*app.Spec.Overrides[0].Replicas).To(Equal(int32(6)))
+		Expect(app.Spec.HealthCheckPath).To(Equal("/ready"))
+		Expect(app.Spec.Env).To(ContainElement(corev1.EnvVar{Name: "FEATURE_FLAGS", Value: "prod"}))
+		Expect(app.Spec.Env).To(ContainElement(corev1.EnvVar{Name: "DATABASE_HOST", Value: "db.prod"}))
+	})
+
+	It("re-applies changed overrides without a new version", func() {
+		promote()
+		updateSource(func(spec *atlasv1.AtlasAppSpec) {
+			spec.Overrides[0].Env[0].Value = "db-replica.prod"
+		})
+		Expect(promote()).NotTo(BeZero())
+		Expect(getTarget().Spec.Env).To(ContainElement(corev1.EnvVar{Name: "DATABASE_HOST", Value: "db-replica.prod"}))
+	})
+
+	It("writes nothing when the target is up to date", func() {
+		promote()
+		generation := getTarget().Generation
+		Expect(promote()).To(BeZero())
+		Expect(getTarget().Generation).To(Equal(generation))
+	})
+
+	It("refuses to move the target to an older release", func() {
+		promote()
+		app := getTarget()
+		app.Spec.Version = "1.23.0"
+		Expect(k8sClient.Update(ctx, app)).To(Succeed())
+
+		latest := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(source), latest)).To(Succeed())
+		_, err := promoteToApp(ctx, k8sClient, target, promotedAppSpec(latest, &pipeline, &pipeline.Stages[2]), latest)
+		Expect(err).To(HaveOccurred())
+		Expect(getTarget().Spec.Version).To(Equal("1.23.0"))
+	})
+})
@@ -0,0 +1,160 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+// revisionMatches reports if a recorded revision is the given release
+func revisionMatches(revision atlasv1.Revision, rel release) bool {
+	return revision.Version == rel.version && revision.Image == rel.image && revision.MigrationId == rel.migrationId
+}
+
+// revisionRelease returns the release a recorded revision runs
+func revisionRelease(revision atlasv1.Revision) release {
+	return release{
+		version:     revision.Version,
+		image:       revision.Image,
+		migrationId: revision.MigrationId,
+	}
+}
+
+// recordKnownGood remembers the spec release once it reached the Ready phase
+func recordKnownGood(atlasApp *atlasv1.AtlasApp) {
+	rel := specRelease(atlasApp)
+	if known := atlasApp.Status.LastKnownGood; known != nil && revisionMatches(*known, rel) {
+		return
+	}
+
+	now := metav1.Now()
+	atlasApp.Status.LastKnownGood = &atlasv1.Revision{
+		Version:     rel.version,
+		Image:       rel.image,
+		MigrationId: rel.migrationId,
+		ReadyAt:     &now,
+	}
+}
+
+// rolledBack reports if the spec release was rolled back and must not be rolled out again
+func rolledBack(atlasApp *atlasv1.AtlasApp) bool {
+	rollback := atlasApp.Status.Rollback
+	return rollback != nil && revisionMatches(rollback.Failed, specRelease(atlasApp))
+}
+
+// canRollback reports if a failure of the spec release can be rolled back
+func canRollback(atlasApp *atlasv1.AtlasApp) bool {
+	known := atlasApp.Status.LastKnownGood
+	return atlasApp.Spec.AutoRollback && known != nil && !revisionMatches(*known, specRelease(atlasApp))
+}
+
+// rollback records that the spec release failed and restores the last known-good release
+func (r *AtlasAppReconciler) rollback(ctx context.Context, atlasApp *atlasv1.AtlasApp, reason string) (ctrl.Result, error) {
+	failed := specRelease(atlasApp)
+	known := *atlasApp.Status.LastKnownGood
+
+	atlasApp.Status.Rollback = &atlasv1.RollbackStatus{
+		Failed: atlasv1.Revision{
+			Version:     failed.version,
+			Image:       failed.image,
+			MigrationId: failed.migrationId,
+		},
+		RestoredTo: known,
+		Reason:     reason,
+		Time:       metav1.Now(),
+	}
+	// The failed release is rolled out through the regular Deployment from now on
+	atlasApp.Status.Canary = nil
+	atlasApp.Status.HealthCheck = nil
+
+	if history := atlasApp.Status.History; len(history) > 0 && historyMatches(history[0], failed) {
+		history[0].Outcome = atlasv1.RevisionOutcomeRolledBack
+	}
+	pushRevision(atlasApp, revisionRelease(known), atlasv1.RevisionTriggerRollback, fmt.Sprintf("rollback of version %s", failed.version))
+
+	log.FromContext(ctx).Info("Rolling back", "version", failed.version, "migrationId", failed.migrationId,
+		"toVersion", known.Version, "toMigrationId", known.MigrationId, "reason", reason)
+	r.Recorder.Eventf(atlasApp, corev1.EventTypeWarning, reasonRolledBack, "Rolled version %s back to %s: %s",
+		failed.version, known.Version, reason)
+
+	if atlasApp.Spec.AutoPromote {
+		setCondition(atlasApp, atlasv1.ConditionPromoted, metav1.ConditionFalse, reasonRolledBack,
+			fmt.Sprintf("Version %s is not promoted, it was rolled back", failed.version))
+	}
+
+	return r.reconcileRollback(ctx, atlasApp, false)
+}
+
+// reconcileRollback keeps the known-good release rolled out while the spec asks
+// for the release that was rolled back. Changes are recorded as drift only if
+// detectDrift is set.
+func (r *AtlasAppReconciler) reconcileRollback(ctx context.Context, atlasApp *atlasv1.AtlasApp, detectDrift bool) (ctrl.Result, error) {
+	rollback := atlasApp.Status.Rollback
+	restored := revisionRelease(rollback.RestoredTo)
+
+	// Going back to the restored release is no downgrade
+	atlasApp.Status.DeployedVersion = restored.version
+	atlasApp.Status.DeployedMigrationId = restored.migrationId
+
+	deployment := desiredDeployment(atlasApp, activeDeploymentName(atlasApp), restored, activeReplicas(atlasApp), activeColorLabels(atlasApp))
+	if err := r.applyDeployment(ctx, atlasApp, deployment, detectDrift); err != nil {
+		return r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+	}
+	if err := r.cleanupCanary(ctx, atlasApp); err != nil {
+		return r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+	}
+	if err := r.reconcileService(ctx, atlasApp, true); err != nil {
+		return r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+	}
+	if err := r.reconcileAutoscaler(ctx, atlasApp, true); err != nil {
+		return r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+	}
+	if err := r.reconcileDisruptionBudget(ctx, atlasApp, true); err != nil {
+		return r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+	}
+	if err := r.reconcileExposure(ctx, atlasApp, true); err != nil {
+		return r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+	}
+	if err := r.reconcileNetworkPolicy(ctx, atlasApp, true); err != nil {
+		return r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+	}
+
+	ready, _, err := r.checkDeploymentStatus(ctx, atlasApp)
+	if err != nil {
+		return r.updateStatus(ctx, atlasApp, atlasv1.PhaseFailed, false, err.Error())
+	}
+
+	message := fmt.Sprintf("Version %s rolled back to %s: %s", rollback.Failed.Version, restored.version, rollback.Reason)
+	if ready {
+		markRevisionReady(atlasApp)
+	}
+	result, err := r.updateStatus(ctx, atlasApp, atlasv1.PhaseRolledBack, false, message)
+	if err != nil || !ready {
+		return result, err
+	}
+
+	// Spec changes retry the rollout, no need to poll
+	return ctrl.Result{}, nil
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+var _ = Describe("Automatic rollback", func() {
+	var (
+		r        *AtlasAppReconciler
+		atlasApp *atlasv1.AtlasApp
+		key      types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		r = newAppReconciler()
+		atlasApp = newApp(newNamespace("rollback"), "dev", "1.0.0")
+		atlasApp.Spec.HealthCheckPath = ""
+		atlasApp.Spec.AutoRollback = true
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		key = client.ObjectKeyFromObject(atlasApp)
+
+		reconcileApp(r, atlasApp)
+		markDeploymentReady(key)
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseReady), updated.Status.Message)
+		Expect(updated.Status.LastKnownGood).NotTo(BeNil())
+		Expect(updated.Status.LastKnownGood.Version).To(Equal("1.0.0"))
+	})
+
+	setVersion := func(version string) {
+		latest := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, key, latest)).To(Succeed())
+		latest.Spec.Version = version
+		Expect(k8sClient.Update(ctx, latest)).To(Succeed())
+	}
+
+	// setRollout sets the status of a Deployment whose new pods never become ready
+	setRollout := func(stalled bool) {
+		deployment := getDeployment(key)
+		deployment.Status.ReadyReplicas = 0
+		deployment.Status.AvailableReplicas = 0
+		deployment.Status.Conditions = nil
+		if stalled {
+			deployment.Status.Conditions = []appsv1.DeploymentCondition{{
+				Type:               appsv1.DeploymentProgressing,
+				Status:             corev1.ConditionFalse,
+				Reason:             "ProgressDeadlineExceeded",
+				LastUpdateTime:     metav1.Now(),
+				LastTransitionTime: metav1.Now(),
+			}}
+		}
+		Expect(k8sClient.Status().Update(ctx, deployment)).To(Succeed())
+	}
+
+	It("only rolls back a release other than the last known-good one", func() {
+		app := newApp("default", "dev", "1.0.0")
+		app.Status.LastKnownGood = &atlasv1.Revision{Version: "1.0.0", Image: "nginx:1.0.0"}
+		Expect(canRollback(app)).To(BeFalse())
+
+		app.Spec.AutoRollback = true
+		Expect(canRollback(app)).To(BeFalse())
+
+		app.Spec.Version = "1.1.0"
+		Expect(canRollback(app)).To(BeTrue())
+
+		app.Status.LastKnownGood = nil
+		Expect(canRollback(app)).To(BeFalse())
+	})
+
+	It("does not take the ready pods of the previous release for the new one", func() {
+		setVersion("1.1.0")
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseDeploying))
+		Expect(updated.Status.LastKnownGood.Version).To(Equal("1.0.0"))
+		Expect(updated.Status.History[0].Outcome).To(Equal(atlasv1.RevisionOutcomeProgressing))
+
+		markDeploymentReady(key)
+		_, updated = reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseReady))
+		Expect(updated.Status.LastKnownGood.Version).To(Equal("1.1.0"))
+	})
+
+	It("restores the last known-good release when the rollout stalls", func() {
+		setRollout(false)
+		setVersion("1.1.0")
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseDeploying))
+		Expect(getDeployment(key).Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.1.0"))
+
+		setRollout(true)
+		_, updated = reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseRolledBack))
+		Expect(updated.Status.DeployedVersion).To(Equal("1.0.0"))
+		Expect(updated.Status.Rollback).NotTo(BeNil())
+		Expect(updated.Status.Rollback.Failed.Version).To(Equal("1.1.0"))
+		Expect(updated.Status.Rollback.RestoredTo.Version).To(Equal("1.0.0"))
+		Expect(updated.Status.History[0].Trigger).To(Equal(atlasv1.RevisionTriggerRollback))
+		Expect(updated.Status.History[1].Version).To(Equal("1.1.0"))
+		Expect(updated.Status.History[1].Outcome).To(Equal(atlasv1.RevisionOutcomeRolledBack))
+		Expect(getDeployment(key).Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.0.0"))
+
+		// The failed release stays rolled back until the spec changes
+		_, updated = reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseRolledBack))
+		Expect(getDeployment(key).Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.0.0"))
+
+		setRollout(false)
+		setVersion("1.1.1")
+		_, updated = reconcileApp(r, atlasApp)
+		Expect(updated.Status.Rollback).To(BeNil())
+		Expect(getDeployment(key).Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.1.1"))
+	})
+
+	It("leaves a stalled rollout in place without autoRollback", func() {
+		latest := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, key, latest)).To(Succeed())
+		latest.Spec.AutoRollback = false
+		latest.Spec.Version = "1.1.0"
+		Expect(k8sClient.Update(ctx, latest)).To(Succeed())
+
+		reconcileApp(r, atlasApp)
+		setRollout(true)
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).To(Equal(atlasv1.PhaseDeploying))
+		Expect(updated.Status.Rollback).To(BeNil())
+		Expect(getDeployment(key).Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.1.0"))
+	})
+})
@@ -0,0 +1,76 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+// serviceType returns the type of the managed Service
+func serviceType(atlasApp *atlasv1.AtlasApp) corev1.ServiceType {
+	if atlasApp.Spec.Service == nil || atlasApp.Spec.Service.Type == "" {
+		return corev1.ServiceTypeClusterIP
+	}
+	return atlasApp.Spec.Service.Type
+}
+
+// externalService reports if the managed Service is reachable through node
+// ports, which node port and traffic policy settings require
+func externalService(atlasApp *atlasv1.AtlasApp) bool {
+	t := serviceType(atlasApp)
+	return t == corev1.ServiceTypeNodePort || t == corev1.ServiceTypeLoadBalancer
+}
+
+// serviceAnnotations returns the annotations of the managed Service
+func serviceAnnotations(atlasApp *atlasv1.AtlasApp) map[string]string {
+	if atlasApp.Spec.Service == nil {
+		return nil
+	}
+	return atlasApp.Spec.Service.Annotations
+}
+
+// desiredServiceSpec returns the spec of the managed Service. The cluster IP is
+// left to the API server, so applying it to an existing Service keeps its IP.
+func desiredServiceSpec(atlasApp *atlasv1.AtlasApp) corev1.ServiceSpec {
+	spec := corev1.ServiceSpec{
+		Type:     serviceType(atlasApp),
+		Selector: serviceSelector(atlasApp),
+		Ports:    servicePorts(atlasApp),
+	}
+
+	settings := atlasApp.Spec.Service
+	if settings == nil {
+		return spec
+	}
+	if settings.SessionAffinity == corev1.ServiceAffinityClientIP {
+		spec.SessionAffinity = corev1.ServiceAffinityClientIP
+		if settings.SessionAffinityTimeoutSeconds != nil {
+			spec.SessionAffinityConfig = &corev1.SessionAffinityConfig{
+				ClientIP: &corev1.ClientIPConfig{TimeoutSeconds: settings.SessionAffinityTimeoutSeconds},
+			}
+		}
+	}
+	if externalService(atlasApp) {
+		spec.ExternalTrafficPolicy = settings.ExternalTrafficPolicy
+	}
+	if spec.Type == corev1.ServiceTypeLoadBalancer {
+		spec.LoadBalancerSourceRanges = settings.LoadBalancerSourceRanges
+	}
+	return spec
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	atlasv1 "atlas-controller/api/v1"
+)
+
+var _ = Describe("Service", func() {
+	var (
+		r        *AtlasAppReconciler
+		atlasApp *atlasv1.AtlasApp
+		key      types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		r = newAppReconciler()
+		atlasApp = newApp(newNamespace("service"), "dev", "1.0.0")
+		atlasApp.Spec.HealthCheckPath = ""
+		atlasApp.Spec.Ports = []atlasv1.PortSpec{
+			{Name: "http", ContainerPort: 8080, ServicePort: 80, NodePort: 30080},
+			{Name: "metrics", ContainerPort: 9090},
+		}
+		key = client.ObjectKeyFromObject(atlasApp)
+	})
+
+	getService := func() *corev1.Service {
+		service := &corev1.Service{}
+		Expect(k8sClient.Get(ctx, key, service)).To(Succeed())
+		return service
+	}
+
+	updateService := func(settings *atlasv1.ServiceSpec) {
+		latest := &atlasv1.AtlasApp{}
+		Expect(k8sClient.Get(ctx, key, latest)).To(Succeed())
+		latest.Spec.Service = settings
+		Expect(k8sClient.Update(ctx, latest)).To(Succeed())
+		_, updated := reconcileApp(r, atlasApp)
+		Expect(updated.Status.Phase).NotTo(Equal(atlasv1.PhaseFailed), updated.Status.Message)
+	}
+
+	It("exposes every port on a ClusterIP Service by default", func() {
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		reconcileApp(r, atlasApp)
+
+		service := getService()
+		Expect(service.Spec.Type).To(Equal(corev1.ServiceTypeClusterIP))
+		Expect(service.Spec.Ports).To(HaveLen(2))
+		Expect(service.Spec.Ports[0].Name).To(Equal("http"))
+		Expect(service.Spec.Ports[0].Port).To(Equal(int32(80)))
+		Expect(service.Spec.Ports[0].TargetPort).To(Equal(intstr.FromInt32(8080)))
+		Expect(service.Spec.Ports[0].NodePort).To(BeZero())
+		Expect(service.Spec.Ports[1].Name).To(Equal("metrics"))
+		Expect(service.Spec.Ports[1].Port).To(Equal(int32(9090)))
+		Expect(service.Spec.Ports[1].Protocol).To(Equal(corev1.ProtocolTCP))
+	})
+
+	It("applies the type, node ports and settings of spec.service", func() {
+		timeout := int32(600)
+		atlasApp.Spec.Service = &atlasv1.ServiceSpec{
+			Type:                          corev1.ServiceTypeLoadBalancer,
+			Annotations:                   map[string]string{"service.beta.kubernetes.io/aws-load-balancer-internal": "true"},
+			SessionAffinity:               corev1.ServiceAffinityClientIP,
+			SessionAffinityTimeoutSeconds: &timeout,
+			ExternalTrafficPolicy:         corev1.ServiceExternalTrafficPolicyTypeLocal,
+			LoadBalancerSourceRanges:      []string{"10.0.0.0/8"},
+		}
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		reconcileApp(r, atlasApp)
+
+		service := getService()
+		Expect(service.Spec.Type).To(Equal(corev1.ServiceTypeLoadBalancer))
+		Expect(service.Annotations).To(HaveKeyWithValue("service.beta.kubernetes.io/aws-load-balancer-internal", "true"))
+		Expect(service.Spec.Ports[0].NodePort).To(Equal(int32(30080)))
+		Expect(service.Spec.SessionAffinity).To(Equal(corev1.ServiceAffinityClientIP))
+		Expect(*service.Spec.SessionAffinityConfig.ClientIP.TimeoutSeconds).To(Equal(int32(600)))
+		Expect(service.Spec.ExternalTrafficPolicy).To(Equal(corev1.ServiceExternalTrafficPolicyTypeLocal))
+		Expect(service.Spec.LoadBalancerSourceRanges).To(Equal([]string{"10.0.0.0/8"}))
+	})
+
+	It("keeps the cluster IP when the type changes", func() {
+		// Node ports are unique in the cluster
+		atlasApp.Spec.Ports[0].NodePort = 30081
+		Expect(k8sClient.Create(ctx, atlasApp)).To(Succeed())
+		reconcileApp(r, atlasApp)
+		clusterIP := getService().Spec.ClusterIP
+		Expect(clusterIP).NotTo(BeEmpty())
+
+		updateService(&atlasv1.ServiceSpec{Type: corev1.ServiceTypeNodePort})
+		service := getService()
+		Expect(service.Spec.Type).To(Equal(corev1.ServiceTypeNodePort))
+		Expect(service.Spec.ClusterIP).To(Equal(clusterIP))
+		Expect(service.Spec.Ports[0].NodePort).To(Equal(int32(30081)))
+
+		updateService(nil)
+		service = getService()
+		Expect(service.Spec.Type).To(Equal(corev1.ServiceTypeClusterIP))
+		Expect(service.Spec.ClusterIP).To(Equal(clusterIP))
+		Expect(service.Spec.Ports[0].NodePort).To(BeZero())
+	})
+})
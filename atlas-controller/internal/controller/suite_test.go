@@ -0,0 +1,195 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	atlasv1 "atlas-controller/api/v1"
+	atlasv1beta2 "atlas-controller/api/v1beta2"
+	//+kubebuilder:scaffold:imports
+)
+
+// These tests use Ginkgo (BDD-style Go testing framework). Refer to
+// http://onsi.github.io/ginkgo/ to learn more about Ginkgo.
+
+var (
+	cfg       *rest.Config
+	k8sClient client.Client
+	testEnv   *envtest.Environment
+	ctx       context.Context
+	cancel    context.CancelFunc
+)
+
+func TestControllers(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Controller Suite")
+}
+
+var _ = BeforeSuite(func() {
+	logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
+
+	ctx, cancel = context.WithCancel(context.TODO())
+
+	By("bootstrapping test environment")
+	testEnv = &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "config", "crd")},
+		ErrorIfCRDPathMissing: true,
+	}
+
+	err := atlasv1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+	err = atlasv1beta2.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+
+	//+kubebuilder:scaffold:scheme
+
+	// AtlasApps are stored as v1beta2, the API server converts through the
+	// conversion webhook of the manager started below
+	cfg, err = testEnv.Start()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(cfg).NotTo(BeNil())
+
+	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(k8sClient).NotTo(BeNil())
+
+	webhookInstallOptions := &testEnv.WebhookInstallOptions
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:  scheme.Scheme,
+		Metrics: metricsserver.Options{BindAddress: "0"},
+		WebhookServer: webhook.NewServer(webhook.Options{
+			Host:    webhookInstallOptions.LocalServingHost,
+			Port:    webhookInstallOptions.LocalServingPort,
+			CertDir: webhookInstallOptions.LocalServingCertDir,
+		}),
+	})
+	Expect(err).NotTo(HaveOccurred())
+	err = (&atlasv1beta2.AtlasApp{}).SetupWebhookWithManager(mgr)
+	Expect(err).NotTo(HaveOccurred())
+
+	go func() {
+		defer GinkgoRecover()
+		err := mgr.Start(ctx)
+		Expect(err).NotTo(HaveOccurred(), "failed to run manager")
+	}()
+
+	// Wait for the conversion webhook before the specs create AtlasApps
+	Eventually(func() error {
+		return k8sClient.List(ctx, &atlasv1.AtlasAppList{})
+	}).Should(Succeed())
+})
+
+var _ = AfterSuite(func() {
+	cancel()
+	By("tearing down the test environment")
+	// Nothing to stop if the environment failed to start
+	if cfg == nil {
+		return
+	}
+	err := testEnv.Stop()
+	Expect(err).NotTo(HaveOccurred())
+})
+
+// newNamespace creates a namespace with a unique name for a spec
+func newNamespace(prefix string) string {
+	name := fmt.Sprintf("%s-%s", prefix, rand.String(5))
+	Expect(k8sClient.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})).To(Succeed())
+	return name
+}
+
+// newAppReconciler returns an AtlasAppReconciler using the test client. Its
+// events are buffered, so specs can assert on them.
+func newAppReconciler() *AtlasAppReconciler {
+	return &AtlasAppReconciler{
+		Client:   k8sClient,
+		Scheme:   k8sClient.Scheme(),
+		Recorder: record.NewFakeRecorder(100),
+	}
+}
+
+// reconcileApp runs one reconcile of the AtlasApp and returns it as stored afterwards
+func reconcileApp(r *AtlasAppReconciler, atlasApp *atlasv1.AtlasApp) (ctrl.Result, *atlasv1.AtlasApp) {
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(atlasApp)})
+	Expect(err).NotTo(HaveOccurred())
+
+	updated := &atlasv1.AtlasApp{}
+	Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(atlasApp), updated)).To(Succeed())
+	return result, updated
+}
+
+// newApp returns an AtlasApp with the settings every spec needs
+func newApp(namespace, environment, version string) *atlasv1.AtlasApp {
+	return &atlasv1.AtlasApp{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "atlas",
+			Namespace: namespace,
+		},
+		Spec: atlasv1.AtlasAppSpec{
+			Environment:     environment,
+			Version:         version,
+			Replicas:        1,
+			HealthCheckPath: "/health",
+		},
+	}
+}
+
+// markDeploymentReady sets the status the Deployment controller reports once
+// all replicas run the current template
+func markDeploymentReady(key types.NamespacedName) {
+	deployment := &appsv1.Deployment{}
+	Expect(k8sClient.Get(ctx, key, deployment)).To(Succeed())
+	replicas := *deployment.Spec.Replicas
+	deployment.Status = appsv1.DeploymentStatus{
+		ObservedGeneration: deployment.Generation,
+		Replicas:           replicas,
+		UpdatedReplicas:    replicas,
+		ReadyReplicas:      replicas,
+		AvailableReplicas:  replicas,
+	}
+	Expect(k8sClient.Status().Update(ctx, deployment)).To(Succeed())
+}
+
+// getDeployment returns the stored Deployment
+func getDeployment(key types.NamespacedName) *appsv1.Deployment {
+	deployment := &appsv1.Deployment{}
+	Expect(k8sClient.Get(ctx, key, deployment)).To(Succeed())
+	return deployment
+}
@@ -33,6 +33,7 @@ import (
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	atlasv1 "atlas-controller/api/v1"
+	atlasv1beta2 "atlas-controller/api/v1beta2"
 	"atlas-controller/internal/controller"
 	//+kubebuilder:scaffold:imports
 )
@@ -46,6 +47,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(atlasv1.AddToScheme(scheme))
+	utilruntime.Must(atlasv1beta2.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -81,12 +83,41 @@ func main() {
 	}
 
 	if err = (&controller.AtlasAppReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("atlas-controller"),
+		// Set through the downward API, see config/manager/manager.yaml
+		ControllerNamespace: os.Getenv("POD_NAMESPACE"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "AtlasApp")
 		os.Exit(1)
 	}
+	if err = (&controller.AtlasPromotionReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("atlas-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "AtlasPromotion")
+		os.Exit(1)
+	}
+	// Webhooks need a serving certificate, see config/webhook. AtlasApps are
+	// stored as v1beta2, so the conversion webhook is always served.
+	if err = (&atlasv1beta2.AtlasApp{}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "AtlasApp")
+		os.Exit(1)
+	}
+	// The admission webhooks are registered with failurePolicy Fail, they are
+	// only turned off with ENABLE_WEBHOOKS=false, e.g. when running locally
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&atlasv1.AtlasApp{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "AtlasApp")
+			os.Exit(1)
+		}
+		if err = (&atlasv1.AtlasPromotion{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "AtlasPromotion")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
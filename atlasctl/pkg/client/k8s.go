@@ -131,10 +131,19 @@ func (k *K8sClient) convertDeploymentToAtlasApp(deployment appsv1.Deployment) mo
 
 // extractVersionFromImage extracts version from container image
 func extractVersionFromImage(image string) string {
-	// Extract version from image like "nginx:1.21.0" -> "1.21.0"
-	parts := strings.Split(image, ":")
-	if len(parts) > 1 {
-		return parts[1]
+	// Images are laid out as repository:version[@digest], where the repository
+	// may include a registry port, e.g. "registry:5000/org/app:1.21.0@sha256:..." -> "1.21.0"
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+
+	name := image
+	if i := strings.LastIndex(image, "/"); i >= 0 {
+		name = image[i+1:]
+	}
+
+	if i := strings.LastIndex(name, ":"); i >= 0 && i < len(name)-1 {
+		return name[i+1:]
 	}
 	return "unknown"
 }